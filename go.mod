@@ -5,5 +5,18 @@ go 1.22
 require (
 	golang.org/x/crypto v0.18.0
 	golang.org/x/image v0.15.0
+	golang.org/x/sync v0.6.0
+	golang.org/x/text v0.14.0
 	modernc.org/sqlite v1.28.0
 )
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	modernc.org/libc v1.29.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
+)
@@ -9,39 +9,77 @@ import (
 
 // Config holds app configuration from env (and optional .env file).
 type Config struct {
-	Root                  string        // Filesystem root for files (e.g. /data, /srv)
-	DBPath                string        // SQLite database path
-	SessionSecret         string        // Secret for signing session cookies
-	Port                  string        // HTTP listen port (e.g. 8080, 80)
-	DefaultAdminUser      string        // First-run admin username (when no users exist)
-	DefaultAdminPassword  string        // First-run admin password (change immediately)
-	PUID                  int           // Docker: owner for created files (0 = don't chown)
-	PGID                  int           // Docker: group for created files (0 = don't chown)
-	AppName               string        // Optional app name in UI (e.g. "Studio Photos")
+	Root                 string // Filesystem root for files (e.g. /data, /srv)
+	DBPath               string // SQLite database path
+	SessionSecret        string // Secret for signing session cookies
+	Port                 string // HTTP listen port (e.g. 8080, 80)
+	DefaultAdminUser     string // First-run admin username (when no users exist)
+	DefaultAdminPassword string // First-run admin password (change immediately)
+	PUID                 int    // Docker: owner for created files (0 = don't chown)
+	PGID                 int    // Docker: group for created files (0 = don't chown)
+	AppName              string // Optional app name in UI (e.g. "Studio Photos")
 	// Optimization (docs/optimization-recommendations.md)
-	ReadHeaderTimeout     time.Duration // HTTP read header timeout (slow clients)
-	ReadTimeout           time.Duration // HTTP read body timeout
-	WriteTimeout          time.Duration // HTTP write timeout (e.g. large downloads)
-	MaxUploadBytes        int64         // Max size per file upload (0 = use default 100MB)
-	MaxRequestBytes        int64         // Max request body (0 = use default 500MB)
-	ThumbMaxSizeShare     int           // Thumb max dimension for share page (default 200)
-	ThumbMaxSizeAdmin     int           // Thumb max dimension for admin (default 320)
-	ThumbConcurrency      int           // Max concurrent thumb generations (0 = use default 4)
-	ZipMaxFiles           int           // Max files in one ZIP (0 = use default 500)
-	ZipMaxBytes            int64         // Max total bytes in ZIP (0 = use default 2GB)
-	SQLiteBusyTimeout      time.Duration // SQLite busy timeout (0 = use default 5s)
-	StaticCacheMaxAge      int           // Cache-Control max-age for static assets (seconds, 0 = 86400)
+	ReadHeaderTimeout   time.Duration // HTTP read header timeout (slow clients)
+	ReadTimeout         time.Duration // HTTP read body timeout
+	WriteTimeout        time.Duration // HTTP write timeout (e.g. large downloads)
+	MaxUploadBytes      int64         // Max size per file upload (0 = use default 100MB)
+	MaxRequestBytes     int64         // Max request body (0 = use default 500MB)
+	ThumbMaxSizeShare   int           // Thumb max dimension for share page (default 200)
+	ThumbMaxSizeAdmin   int           // Thumb max dimension for admin (default 320)
+	PreviewMaxSize      int           // Preview max dimension for the share viewer (default 1600)
+	ThumbConcurrency    int           // Max concurrent thumb decodes, and background workers (0 = use default 4)
+	ThumbCacheDir       string        // Thumbnail cache (default: <DB dir>/thumbs; hidden when under ROOT)
+	ThumbCacheMaxBytes  int64         // Cache size bound; least recently used thumbs are evicted past it (default 1GB)
+	ThumbCacheSweep     time.Duration // How often thumbs of removed or changed files are swept (default 24h, 0 = startup only)
+	ThumbMaxPixels      int64         // Images with more pixels are not decoded (default 100 megapixels)
+	ThumbDecodeMaxBytes int64         // Memory all thumbnail decodes may hold at once (default 512MB)
+	ZipMaxFiles         int           // Max files in one ZIP (0 = use default 500)
+	ZipMaxBytes         int64         // Max total bytes in ZIP (0 = use default 2GB)
+	ListPageSize        int           // Entries per page on /files and share pages (default 200)
+	SQLiteBusyTimeout   time.Duration // SQLite busy timeout (0 = use default 5s)
+	StaticCacheMaxAge   int           // Cache-Control max-age for static assets (seconds, 0 = 86400)
+	UploadTmpDir        string        // Staging dir for resumable uploads (default: <DB dir>/uploads; hidden when under ROOT)
+	UploadExpiry        time.Duration // Incomplete resumable uploads expire after this idle time (default 24h)
+	UploadConflict      string        // Default when an upload's name exists: overwrite (default), skip or rename
+	MaxNameBytes        int           // Longest file or folder name written, in UTF-8 bytes (default 255)
+	TrashDir            string        // Recycle bin (default: <DB dir>/trash; hidden when under ROOT)
+	TrashRetention      time.Duration // Trashed items are purged after this long (default 720h = 30 days)
+	VersionsDir         string        // Previous versions of overwritten files (default: <DB dir>/versions; hidden when under ROOT)
+	VersionsKeep        int           // Versions kept per file where no folder limit is set (default 5, 0 = none)
+	SymlinkPolicy       string        // Symlinks under ROOT: deny, inside (default) or follow
+	HiddenFiles         string        // Names kept out of listings, ZIPs and shares: internal, junk (default) or dotfiles
+	StorageBackend      string        // Where files live: local (default), s3 or memory
+	S3Endpoint          string        // S3/MinIO base URL, e.g. http://minio:9000
+	S3Region            string        // S3 signing region (default us-east-1)
+	S3Bucket            string        // S3 bucket name
+	S3Prefix            string        // Key prefix used as the root inside the bucket
+	S3AccessKey         string
+	S3SecretKey         string
+	Watch               string        // Detect changes made outside the app: auto (inotify + rescan, default), poll (rescan only) or off
+	WatchRescanInterval time.Duration // Full rescan period for the watcher (default 15m, 0 = never)
 }
 
 const (
-	defaultMaxUploadBytes   = 100 << 20  // 100MB
-	defaultMaxRequestBytes  = 500 << 20  // 500MB
-	defaultThumbMaxShare     = 200
-	defaultThumbMaxAdmin     = 320
-	defaultThumbConcurrency  = 4
-	defaultZipMaxFiles       = 500
-	defaultZipMaxBytes       = 2 << 30   // 2GB
-	defaultStaticCacheAge   = 86400     // 1 day
+	defaultMaxUploadBytes   = 100 << 20 // 100MB
+	defaultMaxRequestBytes  = 500 << 20 // 500MB
+	defaultThumbMaxShare    = 200
+	defaultThumbMaxAdmin    = 320
+	defaultPreviewMaxSize   = 1600
+	defaultThumbConcurrency = 4
+	defaultThumbCacheMax    = 1 << 30 // 1GB
+	defaultThumbCacheSweep  = 24 * time.Hour
+	defaultThumbMaxPixels   = 100_000_000
+	defaultThumbDecodeMax   = 512 << 20 // 512MB
+	defaultZipMaxFiles      = 500
+	defaultZipMaxBytes      = 2 << 30 // 2GB
+	defaultListPageSize     = 200
+	maxListPageSize         = 5000
+	defaultStaticCacheAge   = 86400 // 1 day
+	defaultUploadExpiry     = 24 * time.Hour
+	defaultTrashRetention   = 30 * 24 * time.Hour
+	defaultVersionsKeep     = 5
+	defaultMaxNameBytes     = 255
+	defaultWatchRescan      = 15 * time.Minute
 )
 
 // Load reads configuration from the environment. For optional .env file,
@@ -84,9 +122,30 @@ func Load() (*Config, error) {
 	if c.ThumbMaxSizeAdmin <= 0 {
 		c.ThumbMaxSizeAdmin = defaultThumbMaxAdmin
 	}
+	c.PreviewMaxSize = intEnv("PREVIEW_MAX_SIZE", defaultPreviewMaxSize)
+	if c.PreviewMaxSize <= 0 {
+		c.PreviewMaxSize = defaultPreviewMaxSize
+	}
 	if c.ThumbConcurrency <= 0 {
 		c.ThumbConcurrency = defaultThumbConcurrency
 	}
+	c.ThumbCacheDir = getEnv("THUMB_CACHE_DIR", filepath.Join(filepath.Dir(c.DBPath), "thumbs"))
+	c.ThumbCacheMaxBytes = int64Env("THUMB_CACHE_MAX_BYTES", defaultThumbCacheMax)
+	if c.ThumbCacheMaxBytes <= 0 {
+		c.ThumbCacheMaxBytes = defaultThumbCacheMax
+	}
+	c.ThumbCacheSweep = durationEnv("THUMB_CACHE_SWEEP", defaultThumbCacheSweep)
+	if c.ThumbCacheSweep < 0 {
+		c.ThumbCacheSweep = defaultThumbCacheSweep
+	}
+	c.ThumbMaxPixels = int64Env("THUMB_MAX_PIXELS", defaultThumbMaxPixels)
+	if c.ThumbMaxPixels <= 0 {
+		c.ThumbMaxPixels = defaultThumbMaxPixels
+	}
+	c.ThumbDecodeMaxBytes = int64Env("THUMB_DECODE_MAX_BYTES", defaultThumbDecodeMax)
+	if c.ThumbDecodeMaxBytes <= 0 {
+		c.ThumbDecodeMaxBytes = defaultThumbDecodeMax
+	}
 
 	// ZIP limits (avoid long-running requests)
 	c.ZipMaxFiles = intEnv("ZIP_MAX_FILES", defaultZipMaxFiles)
@@ -98,12 +157,73 @@ func Load() (*Config, error) {
 		c.ZipMaxBytes = defaultZipMaxBytes
 	}
 
+	// Listing pages (a folder of thousands of photos stays light on a phone)
+	c.ListPageSize = intEnv("LIST_PAGE_SIZE", defaultListPageSize)
+	if c.ListPageSize <= 0 {
+		c.ListPageSize = defaultListPageSize
+	}
+	if c.ListPageSize > maxListPageSize {
+		c.ListPageSize = maxListPageSize
+	}
+
 	c.SQLiteBusyTimeout = durationEnv("SQLITE_BUSY_TIMEOUT", 5*time.Second)
 	c.StaticCacheMaxAge = intEnv("STATIC_CACHE_MAX_AGE", defaultStaticCacheAge)
 	if c.StaticCacheMaxAge <= 0 {
 		c.StaticCacheMaxAge = defaultStaticCacheAge
 	}
 
+	// Resumable uploads: keep staging on the same disk as the DB so the final move is a rename
+	c.UploadTmpDir = getEnv("UPLOAD_TMP_DIR", filepath.Join(filepath.Dir(c.DBPath), "uploads"))
+	c.UploadExpiry = durationEnv("UPLOAD_EXPIRY", defaultUploadExpiry)
+	if c.UploadExpiry <= 0 {
+		c.UploadExpiry = defaultUploadExpiry
+	}
+	c.MaxNameBytes = intEnv("MAX_NAME_BYTES", defaultMaxNameBytes)
+	if c.MaxNameBytes <= 0 || c.MaxNameBytes > defaultMaxNameBytes {
+		c.MaxNameBytes = defaultMaxNameBytes
+	}
+	c.UploadConflict = getEnv("UPLOAD_CONFLICT", "overwrite")
+	if c.UploadConflict != "overwrite" && c.UploadConflict != "skip" && c.UploadConflict != "rename" {
+		c.UploadConflict = "overwrite"
+	}
+
+	// Symlinks: "inside" allows links whose target stays under ROOT
+	c.SymlinkPolicy = getEnv("SYMLINK_POLICY", "inside")
+
+	// Hidden names: "junk" hides the thumbnail cache and OS clutter such as .DS_Store
+	c.HiddenFiles = getEnv("HIDDEN_FILES", "junk")
+
+	// Recycle bin: same disk as ROOT so deletes are a rename. With the default
+	// DB_PATH it lies under ROOT, where Storage keeps it out of view (see Reserve)
+	c.TrashDir = getEnv("TRASH_DIR", filepath.Join(filepath.Dir(c.DBPath), "trash"))
+	c.TrashRetention = durationEnv("TRASH_RETENTION", defaultTrashRetention)
+	if c.TrashRetention <= 0 {
+		c.TrashRetention = defaultTrashRetention
+	}
+
+	// Versions: like the trash, on the same disk as ROOT so keeping one is a rename
+	c.VersionsDir = getEnv("VERSIONS_DIR", filepath.Join(filepath.Dir(c.DBPath), "versions"))
+	c.VersionsKeep = intEnv("VERSIONS_KEEP", defaultVersionsKeep)
+	if c.VersionsKeep < 0 {
+		c.VersionsKeep = defaultVersionsKeep
+	}
+
+	// Storage backend: ROOT is used by "local"; s3 settings only by "s3"
+	c.StorageBackend = getEnv("STORAGE_BACKEND", "local")
+	c.S3Endpoint = os.Getenv("S3_ENDPOINT")
+	c.S3Region = getEnv("S3_REGION", "us-east-1")
+	c.S3Bucket = os.Getenv("S3_BUCKET")
+	c.S3Prefix = os.Getenv("S3_PREFIX")
+	c.S3AccessKey = os.Getenv("S3_ACCESS_KEY")
+	c.S3SecretKey = os.Getenv("S3_SECRET_KEY")
+
+	// Watcher: catches files copied onto the disk directly (e.g. over SMB)
+	c.Watch = getEnv("WATCH", "auto")
+	c.WatchRescanInterval = durationEnv("WATCH_RESCAN_INTERVAL", defaultWatchRescan)
+	if c.WatchRescanInterval < 0 {
+		c.WatchRescanInterval = 0
+	}
+
 	return c, nil
 }
 
@@ -150,7 +270,7 @@ func getEnv(key, def string) string {
 	return def
 }
 
-// EnsureDirs creates ROOT and the parent directory of DBPath if they do not exist.
+// EnsureDirs creates ROOT, the parent directory of DBPath, UploadTmpDir, TrashDir, VersionsDir and ThumbCacheDir if they do not exist.
 // Call at startup so storage and DB work without "directory not found" (Phase 1).
 func EnsureDirs(c *Config) error {
 	if err := os.MkdirAll(c.Root, 0755); err != nil {
@@ -162,5 +282,25 @@ func EnsureDirs(c *Config) error {
 			return err
 		}
 	}
+	if c.UploadTmpDir != "" {
+		if err := os.MkdirAll(c.UploadTmpDir, 0755); err != nil {
+			return err
+		}
+	}
+	if c.TrashDir != "" {
+		if err := os.MkdirAll(c.TrashDir, 0755); err != nil {
+			return err
+		}
+	}
+	if c.VersionsDir != "" {
+		if err := os.MkdirAll(c.VersionsDir, 0755); err != nil {
+			return err
+		}
+	}
+	if c.ThumbCacheDir != "" {
+		if err := os.MkdirAll(c.ThumbCacheDir, 0755); err != nil {
+			return err
+		}
+	}
 	return nil
 }
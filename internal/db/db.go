@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"io/fs"
 	"log"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -22,7 +24,8 @@ type DB struct {
 // It enables WAL mode and foreign keys.
 func Open(dbPath string, busyTimeout time.Duration) (*DB, error) {
 	timeoutMs := int(busyTimeout.Milliseconds())
-	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_timeout=%d", dbPath, timeoutMs)
+	// modernc.org/sqlite applies _pragma on every new connection in the pool
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)", dbPath, timeoutMs)
 
 	conn, err := sql.Open("sqlite", dsn)
 	if err != nil {
@@ -38,15 +41,27 @@ func Open(dbPath string, busyTimeout time.Duration) (*DB, error) {
 	return &DB{conn: conn}, nil
 }
 
-// RunMigrations executes all SQL migration files.
+// RunMigrations executes all SQL migration files in name order.
+// Migrations must be idempotent (CREATE ... IF NOT EXISTS) since every file runs at startup.
 func (db *DB) RunMigrations() error {
-	data, err := migrationsFS.ReadFile("migrations/001_init.sql")
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
 	if err != nil {
-		return fmt.Errorf("read migration file: %w", err)
+		return fmt.Errorf("read migrations dir: %w", err)
 	}
 
-	if _, err := db.conn.Exec(string(data)); err != nil {
-		return fmt.Errorf("execute migration: %w", err)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		data, err := migrationsFS.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return fmt.Errorf("read migration file %s: %w", entry.Name(), err)
+		}
+
+		if _, err := db.conn.Exec(string(data)); err != nil {
+			return fmt.Errorf("execute migration %s: %w", entry.Name(), err)
+		}
 	}
 
 	log.Println("migrations completed successfully")
@@ -0,0 +1,14 @@
+-- Resumable (tus) uploads in progress. Data is staged in UPLOAD_TMP_DIR until complete.
+
+CREATE TABLE IF NOT EXISTS uploads (
+  id TEXT PRIMARY KEY,
+  path TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  offset INTEGER NOT NULL DEFAULT 0,
+  metadata TEXT,
+  username TEXT,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
@@ -0,0 +1,11 @@
+-- Recycle bin: items moved out of ROOT by admin deletes. Data lives in TRASH_DIR.
+
+CREATE TABLE IF NOT EXISTS trash (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  original_path TEXT NOT NULL,
+  trash_name TEXT UNIQUE NOT NULL,
+  is_dir INTEGER NOT NULL DEFAULT 0,
+  size INTEGER NOT NULL DEFAULT 0,
+  deleted_by TEXT,
+  deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
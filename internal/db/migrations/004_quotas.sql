@@ -0,0 +1,20 @@
+-- Storage quotas for top-level folders (scope 'folder', name = folder name) and
+-- users (scope 'user', name = username). used_bytes is kept up to date by the app
+-- on uploads, deletes and moves, and recalculated when a quota is saved.
+
+CREATE TABLE IF NOT EXISTS quotas (
+  scope TEXT NOT NULL,
+  name TEXT NOT NULL,
+  limit_bytes INTEGER NOT NULL,
+  used_bytes INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (scope, name)
+);
+
+-- Who uploaded or copied what, so per-user usage can be adjusted on delete and move.
+CREATE TABLE IF NOT EXISTS file_owners (
+  path TEXT PRIMARY KEY,
+  username TEXT NOT NULL,
+  size INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_owners_username ON file_owners(username);
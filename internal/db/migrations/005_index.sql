@@ -0,0 +1,39 @@
+-- File index: every file and folder under ROOT, built by a startup scan and kept
+-- current from storage changes. file_index_fts is an external-content FTS5 table
+-- over names (trigram tokenizer, so any 3+ character substring matches).
+
+CREATE TABLE IF NOT EXISTS file_index (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  ext TEXT NOT NULL DEFAULT '',
+  is_dir INTEGER NOT NULL DEFAULT 0,
+  size INTEGER NOT NULL DEFAULT 0,
+  mod_time DATETIME NOT NULL,
+  hash TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_index_ext ON file_index(ext);
+CREATE INDEX IF NOT EXISTS idx_file_index_mod_time ON file_index(mod_time);
+CREATE INDEX IF NOT EXISTS idx_file_index_size ON file_index(size);
+CREATE INDEX IF NOT EXISTS idx_file_index_hash ON file_index(hash);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS file_index_fts USING fts5(
+  name,
+  content='file_index',
+  content_rowid='id',
+  tokenize='trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS file_index_ai AFTER INSERT ON file_index BEGIN
+  INSERT INTO file_index_fts(rowid, name) VALUES (new.id, new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_index_ad AFTER DELETE ON file_index BEGIN
+  INSERT INTO file_index_fts(file_index_fts, rowid, name) VALUES ('delete', old.id, old.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS file_index_au AFTER UPDATE OF name ON file_index BEGIN
+  INSERT INTO file_index_fts(file_index_fts, rowid, name) VALUES ('delete', old.id, old.name);
+  INSERT INTO file_index_fts(rowid, name) VALUES (new.id, new.name);
+END;
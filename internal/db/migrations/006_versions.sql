@@ -0,0 +1,20 @@
+-- Previous versions of files replaced by uploads. Data lives in VERSIONS_DIR;
+-- path is where the file was (and is restored to).
+
+CREATE TABLE IF NOT EXISTS versions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL,
+  version_name TEXT UNIQUE NOT NULL,
+  size INTEGER NOT NULL DEFAULT 0,
+  mod_time DATETIME,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_versions_path ON versions(path);
+
+-- How many versions to keep per file, by folder ('' = root). The nearest
+-- ancestor with a limit wins; without one VERSIONS_KEEP applies. 0 = keep none.
+CREATE TABLE IF NOT EXISTS version_limits (
+  folder TEXT PRIMARY KEY,
+  keep INTEGER NOT NULL
+);
@@ -0,0 +1,8 @@
+-- Original names of files and folders whose names were sanitized on write
+-- (NFC, control characters, reserved names, length). path is the stored name.
+
+CREATE TABLE IF NOT EXISTS original_names (
+  path TEXT PRIMARY KEY,
+  original_name TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
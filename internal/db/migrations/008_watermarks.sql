@@ -0,0 +1,12 @@
+-- Watermarks stamped on the images of a share (thumbnails, previews and
+-- downloads). A share without a row serves its images as stored. image is a
+-- PNG; text is drawn when there is none.
+
+CREATE TABLE IF NOT EXISTS share_watermarks (
+  share_id INTEGER PRIMARY KEY REFERENCES shares(id) ON DELETE CASCADE,
+  text TEXT NOT NULL DEFAULT '',
+  image BLOB,
+  position TEXT NOT NULL,
+  opacity INTEGER NOT NULL,
+  scale INTEGER NOT NULL
+);
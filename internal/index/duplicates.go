@@ -0,0 +1,102 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Duplicates is a set of files with identical content (same SHA-256).
+type Duplicates struct {
+	Hash    string
+	Size    int64    // Size of one copy
+	Entries []*Entry // Oldest first
+}
+
+// Wasted returns the bytes used by the extra copies, assuming none share disk blocks yet.
+func (d *Duplicates) Wasted() int64 {
+	return d.Size * int64(len(d.Entries)-1)
+}
+
+// FindDuplicates returns groups of identical non-empty files, largest waste
+// first, at most limit groups (0 = no limit), plus the total number of groups.
+func (s *Store) FindDuplicates(limit int) ([]*Duplicates, int, error) {
+	const groups = `SELECT hash, size, COUNT(*) AS n FROM file_index
+		WHERE is_dir = 0 AND size > 0 AND hash != ''
+		GROUP BY hash, size HAVING n > 1`
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM (" + groups + ")").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count duplicates: %w", err)
+	}
+
+	query := groups + " ORDER BY size * (n - 1) DESC, hash"
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("find duplicates: %w", err)
+	}
+
+	var list []*Duplicates
+	for rows.Next() {
+		var d Duplicates
+		var n int
+		if err := rows.Scan(&d.Hash, &d.Size, &n); err != nil {
+			continue
+		}
+		list = append(list, &d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for _, d := range list {
+		if d.Entries, err = s.entriesWithHash(d.Hash); err != nil {
+			return nil, 0, err
+		}
+	}
+	return list, total, nil
+}
+
+// DuplicatesOf returns the group of files whose content hash is hash,
+// or nil if fewer than two files have it.
+func (s *Store) DuplicatesOf(hash string) (*Duplicates, error) {
+	entries, err := s.entriesWithHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) < 2 {
+		return nil, nil
+	}
+	return &Duplicates{Hash: hash, Size: entries[0].Size, Entries: entries}, nil
+}
+
+// entriesWithHash returns the files with the given content hash, oldest first.
+func (s *Store) entriesWithHash(hash string) ([]*Entry, error) {
+	if strings.TrimSpace(hash) == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query(
+		"SELECT path, name, ext, is_dir, size, mod_time, hash FROM file_index WHERE hash = ? AND is_dir = 0 ORDER BY mod_time, path",
+		hash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Path, &e.Name, &e.Ext, &e.IsDir, &e.Size, &e.ModTime, &e.Hash); err != nil {
+			continue
+		}
+		e.Path = "/" + e.Path
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,61 @@
+// Package index keeps a SQLite index of every file and folder under ROOT
+// (path, size, mtime, extension, SHA-256) for fast search. It is built by a
+// scan at startup and kept current from storage change notifications.
+package index
+
+import (
+	"strings"
+	"time"
+)
+
+// Entry is one indexed file or folder.
+type Entry struct {
+	Path    string    `json:"path"` // Relative to ROOT, with leading slash
+	Name    string    `json:"name"`
+	Ext     string    `json:"ext"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"` // Hex SHA-256 of the content (files only)
+}
+
+// Dir returns the folder containing the entry.
+func (e *Entry) Dir() string {
+	if i := strings.LastIndex(e.Path, "/"); i > 0 {
+		return e.Path[:i]
+	}
+	return "/"
+}
+
+// Query selects entries. Zero values mean "no filter".
+type Query struct {
+	Text    string    // Words that must all appear in the name
+	Exts    []string  // Extensions, e.g. ".jpg" or "jpg"
+	Type    string    // "file", "dir" or "" for both
+	From    time.Time // Modified at or after
+	To      time.Time // Modified before
+	MinSize int64
+	MaxSize int64
+	Limit   int // Default 100, max 1000
+	Offset  int
+}
+
+// Empty reports whether the query has no criteria at all.
+func (q *Query) Empty() bool {
+	return strings.TrimSpace(q.Text) == "" && len(q.Exts) == 0 && q.Type == "" &&
+		q.From.IsZero() && q.To.IsZero() && q.MinSize == 0 && q.MaxSize == 0
+}
+
+// Stats summarizes the index.
+type Stats struct {
+	Files     int
+	Dirs      int
+	TotalSize int64
+	LastScan  time.Time // Zero until the first scan finishes
+	Scanning  bool
+}
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
@@ -0,0 +1,423 @@
+package index
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"nas-dop/internal/storage"
+)
+
+// underPath matches a path and everything beneath it (bound three times):
+// entries inside "p" sort from "p/" up to "p0", as '0' is the byte after '/'.
+const underPath = "(path = ? OR (path >= ? || '/' AND path < ? || '0'))"
+
+// changeQueueSize bounds pending storage changes; on overflow a rescan is scheduled instead.
+const changeQueueSize = 1024
+
+// Store maintains the file index in SQLite.
+type Store struct {
+	db      *sql.DB
+	storage *storage.Storage
+	changes chan storage.Change
+
+	mu       sync.Mutex // Guards lastScan, scanning and pending
+	lastScan time.Time
+	scanning bool
+	pending  bool // A rescan was requested while one was running
+}
+
+// NewStore creates the index store, subscribes to storage changes and starts
+// the initial scan in the background.
+func NewStore(db *sql.DB, st *storage.Storage) *Store {
+	store := &Store{
+		db:      db,
+		storage: st,
+		changes: make(chan storage.Change, changeQueueSize),
+	}
+	st.Subscribe(store.enqueue)
+	go store.changeLoop()
+	store.Rescan()
+	return store
+}
+
+// enqueue receives storage changes without blocking the operation that made them.
+func (s *Store) enqueue(c storage.Change) {
+	select {
+	case s.changes <- c:
+	default:
+		log.Printf("index: change queue full, scheduling rescan")
+		s.Rescan()
+	}
+}
+
+// changeLoop applies queued changes in order.
+func (s *Store) changeLoop() {
+	for c := range s.changes {
+		if err := s.apply(c); err != nil {
+			log.Printf("index: failed to apply %s of %q: %v", c.Op, c.Path, err)
+		}
+	}
+}
+
+// apply updates the index for one change.
+func (s *Store) apply(c storage.Change) error {
+	if s.storage.Hidden(c.Path) {
+		return nil
+	}
+
+	switch c.Op {
+	case storage.ChangeRemove:
+		return s.remove(c.Path)
+	case storage.ChangeRename:
+		return s.rename(c.OldPath, c.Path)
+	default:
+		return s.update(c.Path, c.Hash)
+	}
+}
+
+// Rescan walks the whole tree in the background, re-hashing only files whose
+// size or mtime changed and dropping entries that no longer exist.
+// Calls during a running scan queue one more scan.
+func (s *Store) Rescan() {
+	s.mu.Lock()
+	if s.scanning {
+		s.pending = true
+		s.mu.Unlock()
+		return
+	}
+	s.scanning = true
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			start := time.Now()
+			if err := s.scan(); err != nil {
+				log.Printf("index: scan failed: %v", err)
+			} else {
+				log.Printf("index: scan finished in %s", time.Since(start).Round(time.Millisecond))
+			}
+
+			s.mu.Lock()
+			s.lastScan = time.Now()
+			if !s.pending {
+				s.scanning = false
+				s.mu.Unlock()
+				return
+			}
+			s.pending = false
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// scan reconciles the index with the tree.
+func (s *Store) scan() error {
+	known, err := s.known("")
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(known))
+	if err := s.walk("", known, seen); err != nil {
+		return err
+	}
+
+	for p := range known {
+		if !seen[p] {
+			if _, err := s.db.Exec("DELETE FROM file_index WHERE path = ?", p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexed is what the index knows about a path, used to skip unchanged files.
+type indexed struct {
+	size    int64
+	modTime time.Time
+}
+
+// known loads indexed entries at or under p ("" = everything).
+func (s *Store) known(p string) (map[string]indexed, error) {
+	query := "SELECT path, size, mod_time FROM file_index"
+	var args []interface{}
+	if p != "" {
+		query += " WHERE " + underPath
+		args = append(args, p, p, p)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[string]indexed)
+	for rows.Next() {
+		var key string
+		var entry indexed
+		if err := rows.Scan(&key, &entry.size, &entry.modTime); err != nil {
+			continue
+		}
+		known[key] = entry
+	}
+	return known, rows.Err()
+}
+
+// walk indexes the directory p recursively, marking every path in seen.
+func (s *Store) walk(p string, known map[string]indexed, seen map[string]bool) error {
+	files, err := s.storage.List(p)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		child := path.Join(p, f.Name)
+		if storage.IsInternal(child) {
+			continue
+		}
+		seen[child] = true
+
+		if prev, ok := known[child]; !ok || prev.size != f.Size || !prev.modTime.Equal(f.ModTime.UTC()) {
+			if err := s.put(child, f, ""); err != nil {
+				log.Printf("index: failed to index %q: %v", child, err)
+			}
+		}
+		if f.IsDir {
+			if err := s.walk(child, known, seen); err != nil {
+				log.Printf("index: failed to scan %q: %v", child, err)
+			}
+		}
+	}
+	return nil
+}
+
+// update (re)indexes p; for a directory, everything beneath it too. hash is
+// the content hash if the writer already computed it ("" = read the file).
+func (s *Store) update(p, hash string) error {
+	info, err := s.storage.Stat(p)
+	if err != nil {
+		return err
+	}
+	info.Name = path.Base(p)
+
+	known, err := s.known(p)
+	if err != nil {
+		return err
+	}
+	// Skip re-hashing when the same write is reported twice
+	if prev, ok := known[p]; !ok || prev.size != info.Size || !prev.modTime.Equal(info.ModTime.UTC()) {
+		if err := s.put(p, *info, hash); err != nil {
+			return err
+		}
+	}
+	if !info.IsDir {
+		return nil
+	}
+	return s.walk(p, known, make(map[string]bool))
+}
+
+// put upserts one entry, hashing file contents unless hash is given.
+func (s *Store) put(p string, f storage.FileInfo, hash string) error {
+	if f.IsDir {
+		hash = ""
+	} else if hash == "" {
+		var err error
+		if hash, err = s.hashFile(p); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO file_index (path, name, ext, is_dir, size, mod_time, hash) VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(path) DO UPDATE SET name = excluded.name, ext = excluded.ext, is_dir = excluded.is_dir,
+		   size = excluded.size, mod_time = excluded.mod_time, hash = excluded.hash`,
+		p, f.Name, strings.ToLower(path.Ext(f.Name)), f.IsDir, f.Size, f.ModTime.UTC(), hash,
+	)
+	return err
+}
+
+// hashFile returns the hex SHA-256 of a file's contents.
+func (s *Store) hashFile(p string) (string, error) {
+	f, err := s.storage.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remove drops p and everything beneath it.
+func (s *Store) remove(p string) error {
+	_, err := s.db.Exec("DELETE FROM file_index WHERE "+underPath, p, p, p)
+	return err
+}
+
+// rename moves p and everything beneath it to newPath, keeping hashes.
+// The same rename can arrive twice (from Storage and from the watcher); if
+// nothing is indexed at oldPath any more, newPath is just reindexed.
+func (s *Store) rename(oldPath, newPath string) error {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM file_index WHERE path = ?", oldPath).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return s.update(newPath, "")
+	}
+
+	// Anything already indexed at the destination was replaced
+	if err := s.remove(newPath); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(
+		"UPDATE file_index SET path = ? || substr(path, length(?)+1) WHERE "+underPath,
+		newPath, oldPath, oldPath, oldPath, oldPath,
+	)
+	if err != nil {
+		return err
+	}
+
+	name := path.Base(newPath)
+	_, err = s.db.Exec(
+		"UPDATE file_index SET name = ?, ext = ? WHERE path = ?",
+		name, strings.ToLower(path.Ext(name)), newPath,
+	)
+	return err
+}
+
+// Search returns entries matching q and the total number of matches.
+func (s *Store) Search(q Query) ([]*Entry, int, error) {
+	from := "file_index f"
+	var where []string
+	var args []interface{}
+	ranked := false
+
+	// Trigram FTS needs 3+ characters; shorter words fall back to LIKE
+	var ftsTerms []string
+	for _, word := range strings.Fields(q.Text) {
+		if utf8.RuneCountInString(word) >= 3 {
+			ftsTerms = append(ftsTerms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+			continue
+		}
+		where = append(where, `f.name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(word)+"%")
+	}
+	if len(ftsTerms) > 0 {
+		from += " JOIN file_index_fts ON file_index_fts.rowid = f.id"
+		where = append(where, "file_index_fts MATCH ?")
+		args = append(args, strings.Join(ftsTerms, " AND "))
+		ranked = true
+	}
+
+	if len(q.Exts) > 0 {
+		placeholders := make([]string, len(q.Exts))
+		for i, ext := range q.Exts {
+			placeholders[i] = "?"
+			args = append(args, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+		}
+		where = append(where, "f.ext IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	switch q.Type {
+	case "file":
+		where = append(where, "f.is_dir = 0")
+	case "dir":
+		where = append(where, "f.is_dir = 1")
+	}
+	if !q.From.IsZero() {
+		where = append(where, "f.mod_time >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "f.mod_time < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.MinSize > 0 {
+		where = append(where, "f.size >= ?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		where = append(where, "f.size <= ?")
+		args = append(args, q.MaxSize)
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM "+from+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count search results: %w", err)
+	}
+
+	order := " ORDER BY f.is_dir DESC, f.mod_time DESC"
+	if ranked {
+		order = " ORDER BY f.is_dir DESC, file_index_fts.rank"
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	rows, err := s.db.Query(
+		"SELECT f.path, f.name, f.ext, f.is_dir, f.size, f.mod_time, f.hash FROM "+from+clause+order+" LIMIT ? OFFSET ?",
+		append(args, limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Path, &e.Name, &e.Ext, &e.IsDir, &e.Size, &e.ModTime, &e.Hash); err != nil {
+			continue
+		}
+		e.Path = "/" + e.Path
+		entries = append(entries, &e)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// Stats returns index totals and scan state.
+func (s *Store) Stats() (*Stats, error) {
+	var st Stats
+	err := s.db.QueryRow(
+		"SELECT COALESCE(SUM(is_dir = 0), 0), COALESCE(SUM(is_dir = 1), 0), COALESCE(SUM(CASE WHEN is_dir = 0 THEN size END), 0) FROM file_index",
+	).Scan(&st.Files, &st.Dirs, &st.TotalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	st.LastScan = s.lastScan
+	st.Scanning = s.scanning
+	s.mu.Unlock()
+	return &st, nil
+}
+
+// escapeLike escapes LIKE wildcards with a backslash.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
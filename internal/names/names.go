@@ -0,0 +1,125 @@
+// Package names keeps the original names of files and folders that storage
+// renamed while sanitizing, and follows them through moves and deletes.
+package names
+
+import (
+	"database/sql"
+	"log"
+	"path"
+	"strings"
+
+	"nas-dop/internal/storage"
+)
+
+// underPath matches a path and the paths beneath it, given the path three
+// times. A range rather than substr keeps non-ASCII folder names working.
+const underPath = "(path = ? OR (path >= ? || '/' AND path < ? || '0'))"
+
+// Store manages original names in SQLite.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates the store and registers it with storage for sanitized
+// names and for moves and deletes of the paths it knows.
+func NewStore(db *sql.DB, st *storage.Storage) *Store {
+	store := &Store{db: db}
+	st.OnNameChange(func(c storage.NameChange) {
+		store.Record(c.Path, c.Original)
+	})
+	st.Subscribe(store.onChange)
+	return store
+}
+
+// Record keeps original as the name relPath was given before sanitizing.
+// Nothing is kept when the stored name is the same.
+func (s *Store) Record(relPath, original string) {
+	p := cleanPath(relPath)
+	if p == "" || path.Base(p) == original {
+		return
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO original_names (path, original_name) VALUES (?, ?) ON CONFLICT(path) DO UPDATE SET original_name = excluded.original_name",
+		p, original,
+	)
+	if err != nil {
+		log.Printf("names: failed to record original name of %q: %v", p, err)
+	}
+}
+
+// Original returns the name relPath was given, or "" if it was not changed.
+func (s *Store) Original(relPath string) string {
+	var original string
+	s.db.QueryRow("SELECT original_name FROM original_names WHERE path = ?", cleanPath(relPath)).Scan(&original)
+	return original
+}
+
+// Originals returns the original names of entries directly inside dir, by stored name.
+func (s *Store) Originals(dir string) (map[string]string, error) {
+	dir = cleanPath(dir)
+	query := "SELECT path, original_name FROM original_names"
+	var args []interface{}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+		query += " WHERE path >= ? || '/' AND path < ? || '0'"
+		args = append(args, dir, dir)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	originals := make(map[string]string)
+	for rows.Next() {
+		var p, original string
+		if err := rows.Scan(&p, &original); err != nil {
+			continue
+		}
+		if name := strings.TrimPrefix(p, prefix); !strings.Contains(name, "/") {
+			originals[name] = original
+		}
+	}
+	return originals, rows.Err()
+}
+
+// onChange drops records of removed paths and moves records along with their
+// paths. A rename to a new name replaces the original name, so that record goes.
+func (s *Store) onChange(c storage.Change) {
+	var err error
+	switch c.Op {
+	case storage.ChangeRemove:
+		_, err = s.db.Exec(
+			"DELETE FROM original_names WHERE "+underPath,
+			c.Path, c.Path, c.Path,
+		)
+	case storage.ChangeRename:
+		// Whatever was at the destination was replaced
+		_, err = s.db.Exec(
+			"DELETE FROM original_names WHERE "+underPath,
+			c.Path, c.Path, c.Path,
+		)
+		if err != nil {
+			break
+		}
+		if path.Base(c.OldPath) != path.Base(c.Path) {
+			if _, err = s.db.Exec("DELETE FROM original_names WHERE path = ?", c.OldPath); err != nil {
+				break
+			}
+		}
+		_, err = s.db.Exec(
+			"UPDATE original_names SET path = ? || substr(path, length(?)+1) WHERE "+underPath,
+			c.Path, c.OldPath, c.OldPath, c.OldPath, c.OldPath,
+		)
+	}
+	if err != nil {
+		log.Printf("names: failed to follow %s of %q: %v", c.Op, c.Path, err)
+	}
+}
+
+// cleanPath normalizes a path the way storage does: slash-separated, no leading slash.
+func cleanPath(relPath string) string {
+	return strings.Trim(path.Clean("/"+relPath), "/")
+}
@@ -0,0 +1,86 @@
+// Package quota enforces storage limits for top-level folders and users.
+// Usage is tracked incrementally in SQLite as files are uploaded, deleted and
+// moved, so checks never have to walk the disk.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned for unknown quotas.
+	ErrNotFound = errors.New("quota not found")
+	// ErrExceeded matches any *ExceededError via errors.Is.
+	ErrExceeded = errors.New("quota exceeded")
+)
+
+// Scope says what a quota applies to.
+type Scope string
+
+const (
+	ScopeFolder Scope = "folder" // A top-level folder under ROOT, by name
+	ScopeUser   Scope = "user"   // Everything a user uploaded or copied, by username
+)
+
+// ParseScope converts a form value to a Scope.
+func ParseScope(v string) (Scope, bool) {
+	switch Scope(v) {
+	case ScopeFolder, ScopeUser:
+		return Scope(v), true
+	}
+	return "", false
+}
+
+// Quota is a storage limit and its current usage.
+type Quota struct {
+	Scope Scope
+	Name  string
+	Limit int64 // Bytes
+	Used  int64 // Bytes
+}
+
+// Free returns the bytes left before the limit (never negative).
+func (q *Quota) Free() int64 {
+	if q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
+
+// Percent returns usage as a percentage of the limit, capped at 100.
+func (q *Quota) Percent() int {
+	if q.Limit <= 0 || q.Used >= q.Limit {
+		return 100
+	}
+	return int(q.Used * 100 / q.Limit)
+}
+
+// ExceededError reports which quota an operation would exceed.
+type ExceededError struct {
+	Quota *Quota
+	Size  int64 // Bytes the operation needed
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota for %q exceeded: need %d bytes, %d of %d free",
+		e.Quota.Scope, e.Quota.Name, e.Size, e.Quota.Free(), e.Quota.Limit)
+}
+
+// Is makes errors.Is(err, ErrExceeded) true.
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrExceeded
+}
+
+// cleanPath normalizes a storage-relative path to "a/b" form ("" for root).
+func cleanPath(relPath string) string {
+	return strings.Trim(path.Clean("/"+relPath), "/")
+}
+
+// topFolder returns the top-level folder a path belongs to (its first component).
+func topFolder(p string) string {
+	folder, _, _ := strings.Cut(p, "/")
+	return folder
+}
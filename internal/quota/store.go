@@ -0,0 +1,358 @@
+package quota
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+
+	"nas-dop/internal/storage"
+)
+
+// underPath matches a path and everything beneath it, taking the path three
+// times. Children sort between "p/" and "p0" ('0' follows '/'), which avoids
+// substr lengths: SQLite counts those in characters, Go in bytes.
+const underPath = "(path = ? OR (path >= ? || '/' AND path < ? || '0'))"
+
+// Store manages quotas and usage in SQLite.
+type Store struct {
+	db      *sql.DB
+	storage *storage.Storage
+}
+
+// NewStore creates a new quota store.
+func NewStore(db *sql.DB, st *storage.Storage) *Store {
+	return &Store{
+		db:      db,
+		storage: st,
+	}
+}
+
+// List returns all quotas, folders first.
+func (s *Store) List() ([]*Quota, error) {
+	rows, err := s.db.Query("SELECT scope, name, limit_bytes, used_bytes FROM quotas ORDER BY scope, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotas []*Quota
+	for rows.Next() {
+		var q Quota
+		if err := rows.Scan(&q.Scope, &q.Name, &q.Limit, &q.Used); err != nil {
+			continue
+		}
+		quotas = append(quotas, &q)
+	}
+
+	return quotas, nil
+}
+
+// Get retrieves one quota.
+func (s *Store) Get(scope Scope, name string) (*Quota, error) {
+	q := Quota{Scope: scope, Name: name}
+	err := s.db.QueryRow(
+		"SELECT limit_bytes, used_bytes FROM quotas WHERE scope = ? AND name = ?",
+		scope, name,
+	).Scan(&q.Limit, &q.Used)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query quota: %w", err)
+	}
+	return &q, nil
+}
+
+// Set creates or updates a quota and recalculates its usage.
+func (s *Store) Set(scope Scope, name string, limit int64) (*Quota, error) {
+	if scope == ScopeFolder {
+		name = cleanPath(name)
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("folder quotas apply to top-level folders only")
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("name required")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	q := &Quota{Scope: scope, Name: name, Limit: limit}
+	used, err := s.usage(q)
+	if err != nil {
+		return nil, err
+	}
+	q.Used = used
+
+	_, err = s.db.Exec(
+		`INSERT INTO quotas (scope, name, limit_bytes, used_bytes) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(scope, name) DO UPDATE SET limit_bytes = excluded.limit_bytes, used_bytes = excluded.used_bytes`,
+		q.Scope, q.Name, q.Limit, q.Used,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("save quota: %w", err)
+	}
+	return q, nil
+}
+
+// Remove deletes a quota.
+func (s *Store) Remove(scope Scope, name string) error {
+	result, err := s.db.Exec("DELETE FROM quotas WHERE scope = ? AND name = ?", scope, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Recalculate recomputes usage for every quota, e.g. after files were changed
+// outside the app.
+func (s *Store) Recalculate() error {
+	quotas, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, q := range quotas {
+		used, err := s.usage(q)
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec("UPDATE quotas SET used_bytes = ? WHERE scope = ? AND name = ?", used, q.Scope, q.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usage computes current usage from scratch: folder size on disk, or the
+// total of files recorded as owned by the user.
+func (s *Store) usage(q *Quota) (int64, error) {
+	if q.Scope == ScopeFolder {
+		size, err := s.storage.Size(q.Name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return size, err
+	}
+
+	var used int64
+	err := s.db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM file_owners WHERE username = ?", q.Name).Scan(&used)
+	return used, err
+}
+
+// Applicable returns the quotas that apply to writing relPath as username:
+// the quota of its top-level folder and the user's quota, if set.
+// An empty username skips the user quota.
+func (s *Store) Applicable(relPath, username string) ([]*Quota, error) {
+	var quotas []*Quota
+
+	candidates := []struct {
+		scope Scope
+		name  string
+	}{
+		{ScopeFolder, topFolder(cleanPath(relPath))},
+		{ScopeUser, username},
+	}
+	for _, c := range candidates {
+		if c.name == "" {
+			continue
+		}
+		q, err := s.Get(c.scope, c.name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+
+	return quotas, nil
+}
+
+// Allowance returns how many bytes may still be written to relPath by username
+// under the tightest applicable quota, and that quota. It returns -1 and a nil
+// quota when no quota applies.
+func (s *Store) Allowance(relPath, username string) (int64, *Quota, error) {
+	quotas, err := s.Applicable(relPath, username)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var tightest *Quota
+	for _, q := range quotas {
+		if tightest == nil || q.Free() < tightest.Free() {
+			tightest = q
+		}
+	}
+
+	if tightest == nil {
+		return -1, nil, nil
+	}
+	return tightest.Free(), tightest, nil
+}
+
+// Check returns an *ExceededError if writing size more bytes to relPath as
+// username would go over a quota.
+func (s *Store) Check(relPath, username string, size int64) error {
+	free, q, err := s.Allowance(relPath, username)
+	if err != nil {
+		return err
+	}
+	if q != nil && size > free {
+		return &ExceededError{Quota: q, Size: size}
+	}
+	return nil
+}
+
+// CheckCopy checks that copying srcPath to dstPath as username fits the quotas.
+// replaced is the size of an item the copy will overwrite (0 if none).
+func (s *Store) CheckCopy(srcPath, dstPath, username string, replaced int64) error {
+	size, err := s.storage.Size(srcPath)
+	if err != nil {
+		return err
+	}
+	return s.Check(dstPath, username, size-replaced)
+}
+
+// CheckMove checks that moving srcPath to dstPath fits the destination folder
+// quota. Moves within one top-level folder never change its usage, and user
+// usage follows the files, so only cross-folder moves are checked.
+func (s *Store) CheckMove(srcPath, dstPath string, replaced int64) error {
+	if topFolder(cleanPath(srcPath)) == topFolder(cleanPath(dstPath)) {
+		return nil
+	}
+	size, err := s.storage.Size(srcPath)
+	if err != nil {
+		return err
+	}
+	return s.Check(dstPath, "", size-replaced)
+}
+
+// Added records that size bytes were written to relPath by username, replacing
+// replaced bytes that were there before (0 for a new item).
+func (s *Store) Added(relPath, username string, size, replaced int64) {
+	p := cleanPath(relPath)
+
+	s.addUsage(ScopeFolder, topFolder(p), size-replaced)
+	s.dropOwners(p)
+
+	if username == "" {
+		return
+	}
+	if _, err := s.db.Exec("INSERT INTO file_owners (path, username, size) VALUES (?, ?, ?)", p, username, size); err != nil {
+		log.Printf("quota: failed to record owner of %q: %v", p, err)
+		return
+	}
+	s.addUsage(ScopeUser, username, size)
+}
+
+// Removed records that relPath (size bytes in total) left the tree.
+func (s *Store) Removed(relPath string, size int64) {
+	p := cleanPath(relPath)
+
+	s.addUsage(ScopeFolder, topFolder(p), -size)
+	s.dropOwners(p)
+}
+
+// Moved records that oldPath now lives at newPath. Folder usage moves with it
+// when the top-level folder changes; ownership is kept. A renamed top-level
+// folder keeps its quota under the new name, unless that name has its own;
+// a top-level folder moved into another one leaves its quota behind, so the
+// quota is dropped.
+func (s *Store) Moved(oldPath, newPath string) {
+	oldP, newP := cleanPath(oldPath), cleanPath(newPath)
+
+	if oldFolder, newFolder := topFolder(oldP), topFolder(newP); oldFolder != newFolder {
+		if oldP != oldFolder || newP != newFolder || !s.renameFolderQuota(oldFolder, newFolder) {
+			size, err := s.storage.Size(newP)
+			if err != nil {
+				log.Printf("quota: failed to size %q: %v", newP, err)
+			} else {
+				s.addUsage(ScopeFolder, oldFolder, -size)
+				s.addUsage(ScopeFolder, newFolder, size)
+			}
+		}
+		if oldP == oldFolder {
+			if err := s.Remove(ScopeFolder, oldFolder); err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("quota: failed to drop quota of moved folder %q: %v", oldFolder, err)
+			}
+		}
+	}
+
+	_, err := s.db.Exec(
+		"UPDATE file_owners SET path = ? || substr(path, length(?)+1) WHERE "+underPath,
+		newP, oldP, oldP, oldP, oldP,
+	)
+	if err != nil {
+		log.Printf("quota: failed to move owners from %q to %q: %v", oldP, newP, err)
+	}
+}
+
+// renameFolderQuota moves the quota of folder oldName, usage included, to
+// newName. It reports false when oldName has no quota or newName has one.
+func (s *Store) renameFolderQuota(oldName, newName string) bool {
+	result, err := s.db.Exec(
+		"UPDATE OR IGNORE quotas SET name = ? WHERE scope = ? AND name = ?",
+		newName, ScopeFolder, oldName,
+	)
+	if err != nil {
+		log.Printf("quota: failed to move quota of %q to %q: %v", oldName, newName, err)
+		return false
+	}
+	n, _ := result.RowsAffected()
+	return n > 0
+}
+
+// addUsage adjusts used_bytes of a quota if one exists.
+func (s *Store) addUsage(scope Scope, name string, delta int64) {
+	if name == "" || delta == 0 {
+		return
+	}
+	_, err := s.db.Exec(
+		"UPDATE quotas SET used_bytes = MAX(0, used_bytes + ?) WHERE scope = ? AND name = ?",
+		delta, scope, name,
+	)
+	if err != nil {
+		log.Printf("quota: failed to update %s usage for %q: %v", scope, name, err)
+	}
+}
+
+// dropOwners forgets ownership of p and everything beneath it, releasing the
+// bytes from each owner's usage.
+func (s *Store) dropOwners(p string) {
+	rows, err := s.db.Query(
+		"SELECT username, SUM(size) FROM file_owners WHERE "+underPath+" GROUP BY username",
+		p, p, p,
+	)
+	if err != nil {
+		log.Printf("quota: failed to query owners of %q: %v", p, err)
+		return
+	}
+
+	released := make(map[string]int64)
+	for rows.Next() {
+		var username string
+		var size int64
+		if err := rows.Scan(&username, &size); err != nil {
+			continue
+		}
+		released[username] = size
+	}
+	rows.Close()
+
+	for username, size := range released {
+		s.addUsage(ScopeUser, username, -size)
+	}
+	if _, err := s.db.Exec("DELETE FROM file_owners WHERE "+underPath, p, p, p); err != nil {
+		log.Printf("quota: failed to delete owners of %q: %v", p, err)
+	}
+}
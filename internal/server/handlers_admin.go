@@ -2,15 +2,22 @@ package server
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"nas-dop/internal/auth"
+	"nas-dop/internal/quota"
+	"nas-dop/internal/storage"
+	"nas-dop/internal/watermark"
 )
 
 // handleLoginForm renders the login page.
@@ -97,7 +104,15 @@ func (s *Server) handleAdminRoot(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleFilesList(w http.ResponseWriter, r *http.Request) {
 	path := r.PathValue("path")
 
-	files, err := s.storage.List(path)
+	page, list, err := s.listPage(r, path)
+	if errors.Is(err, storage.ErrBadCursor) {
+		http.Error(w, "Invalid page cursor", 400)
+		return
+	}
+	if errors.Is(err, storage.ErrHidden) {
+		http.Error(w, "Not found", 404)
+		return
+	}
 	if err != nil {
 		log.Printf("files: failed to list files at path %q: %v", path, err)
 		http.Error(w, "Failed to list files", 500)
@@ -107,50 +122,232 @@ func (s *Server) handleFilesList(w http.ResponseWriter, r *http.Request) {
 	// Build breadcrumbs
 	breadcrumbs := buildBreadcrumbs(path)
 
+	// Usage bars for the quotas that apply here
+	quotas, err := s.quotas.Applicable(path, auth.GetUsername(r))
+	if err != nil {
+		log.Printf("files: failed to load quotas for path %q: %v", path, err)
+	}
+
+	// Version counts for the "Versions" links
+	versionCounts, err := s.versions.Counts(path)
+	if err != nil {
+		log.Printf("files: failed to count versions for path %q: %v", path, err)
+	}
+
+	// Names as uploaded, where sanitizing changed them
+	originals, err := s.names.Originals(path)
+	if err != nil {
+		log.Printf("files: failed to load original names for path %q: %v", path, err)
+	}
+
 	s.render(w, "admin/files", map[string]interface{}{
 		"Path":        path,
-		"Files":       files,
+		"Files":       page.Files,
+		"List":        list,
 		"Breadcrumbs": breadcrumbs,
+		"Quotas":      quotas,
+		"Versions":    versionCounts,
+		"Originals":   originals,
+		"Upload":      takeUploadFlash(w, r),
+		"Conflict":    s.cfg.UploadConflict,
+		"Success":     filesMessages[r.URL.Query().Get("success")],
+		"Error":       filesMessages[r.URL.Query().Get("error")],
 	})
 }
 
+// filesMessages maps ?success= / ?error= codes to messages on the files page.
+var filesMessages = map[string]string{
+	"moved":    "Moved.",
+	"copied":   "Copied.",
+	"exists":   "Some items were skipped because the destination already has an item with the same name.",
+	"failed":   "Some items could not be moved or copied. Check the server log for details.",
+	"nodest":   "Choose a destination folder.",
+	"noselect": "Select at least one item.",
+	"quota":    "Some items were skipped because the destination is over its quota.",
+}
+
 // handleUpload handles file uploads.
+// Parts are streamed straight to disk via MultipartReader so large batches
+// never sit in memory; MaxUploadBytes is enforced per file. The target
+// directory comes from the "path" query parameter or a "path" form field, and
+// the conflict policy (overwrite, skip or rename; default UPLOAD_CONFLICT) from
+// a "conflict" field; both must come before the file parts (the admin form
+// places them first). Folder and user quotas cap each file at the space left.
+// Every file gets a result (saved, renamed, skipped or failed with a reason),
+// returned as JSON or as a summary on the files page (see writeUploadResults).
 func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
-	path := r.FormValue("path")
+	path := r.URL.Query().Get("path")
+	conflict := r.URL.Query().Get("conflict")
+	username := auth.GetUsername(r)
 
-	// Parse multipart form
-	err := r.ParseMultipartForm(s.cfg.MaxUploadBytes)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		if IsRequestEntityTooLarge(err) {
-			WriteRequestEntityTooLarge(w)
-			return
-		}
-		log.Printf("upload: failed to parse form at path %q: %v", path, err)
+		log.Printf("upload: failed to read multipart body at path %q: %v", path, err)
 		http.Error(w, "Failed to parse form", 400)
 		return
 	}
 
-	files := r.MultipartForm.File["files"]
-	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
+	var results []uploadResult
+parts:
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			continue
+			if IsRequestEntityTooLarge(err) {
+				results = append(results, uploadResult{Name: "(remaining files)", Status: uploadFailed,
+					Reason: requestTooLargeReason, code: http.StatusRequestEntityTooLarge})
+				break
+			}
+			log.Printf("upload: failed to read part at path %q: %v", path, err)
+			http.Error(w, "Failed to parse form", 400)
+			return
 		}
 
-		data, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			continue
+		switch part.FormName() {
+		case "path", "conflict":
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			part.Close()
+			if err != nil {
+				if IsRequestEntityTooLarge(err) {
+					WriteRequestEntityTooLarge(w)
+					return
+				}
+				http.Error(w, "Failed to parse form", 400)
+				return
+			}
+			if part.FormName() == "path" {
+				path = string(value)
+			} else {
+				conflict = string(value)
+			}
+
+		case "files":
+			// Browsers send one empty part when no file was chosen
+			if part.FileName() == "" {
+				part.Close()
+				continue
+			}
+			res := s.uploadPart(part, path, conflict, username)
+			part.Close()
+			results = append(results, res)
+			if res.Reason == requestTooLargeReason {
+				// The request body hit its limit; nothing more can be read
+				break parts
+			}
+
+		default:
+			part.Close()
 		}
+	}
 
-		// Write file to storage
-		filePath := filepath.Join(path, fileHeader.Filename)
-		if err := s.storage.Write(filePath, data); err != nil {
-			continue
+	writeUploadResults(w, r, path, results)
+}
+
+// uploadPart stores one uploaded file in dir, applying the conflict policy
+// and quotas, and reports the outcome. A file name with directories (folder
+// uploads send "DCIM/100CANON/IMG_0001.JPG") is stored at that relative path,
+// creating missing folders.
+func (s *Server) uploadPart(part *multipart.Part, dir, conflict, username string) uploadResult {
+	res := uploadResult{Name: partFilename(part), Status: uploadSaved}
+	fail := func(code int, reason string) uploadResult {
+		res.Status, res.Reason, res.code = uploadFailed, reason, code
+		return res
+	}
+
+	subdir, filename, ok := splitUploadName(res.Name)
+	if !ok {
+		return fail(http.StatusBadRequest, "invalid file name")
+	}
+	if err := s.storage.Validate(filepath.Join(dir, subdir, filename)); err != nil {
+		return fail(http.StatusBadRequest, "invalid file name")
+	}
+	if subdir != "" {
+		folder := filepath.Join(dir, subdir)
+		if info, err := s.storage.Stat(folder); err != nil {
+			if err := s.storage.Mkdir(folder); err != nil {
+				log.Printf("upload: failed to create folder %q: %v", folder, err)
+				return fail(http.StatusInternalServerError, "could not create its folder")
+			}
+		} else if !info.IsDir {
+			return fail(http.StatusConflict, "a file is in the way of its folder")
 		}
 	}
 
-	http.Redirect(w, r, "/files/"+strings.TrimPrefix(path, "/"), http.StatusSeeOther)
+	// Names are sanitized on write; work with the name it will be stored under
+	filePath, err := s.storage.CleanPath(filepath.Join(dir, subdir, filename))
+	if err != nil {
+		return fail(http.StatusBadRequest, "invalid file name")
+	}
+	sanitized := filepath.Base(filePath) != filename // Before any conflict rename
+
+	// Apply the conflict policy to an existing file of the same name
+	if conflict == "" {
+		conflict = s.cfg.UploadConflict
+	}
+	var replaced int64
+	if info, err := s.storage.Stat(filePath); err == nil {
+		if info.IsDir {
+			return fail(http.StatusConflict, "a folder with this name exists")
+		}
+		switch storage.ParseConflict(conflict) {
+		case storage.ConflictOverwrite:
+			replaced = info.Size
+		case storage.ConflictRename:
+			free, err := s.storage.FreeName(filePath)
+			if err != nil {
+				return fail(http.StatusBadRequest, "invalid file name")
+			}
+			filePath = free
+			res.Status = uploadRenamed
+		default:
+			res.Status, res.Reason = uploadSkipped, "a file with this name already exists"
+			return res
+		}
+	}
+	res.Path = "/" + strings.TrimPrefix(filepath.ToSlash(filePath), "/")
+
+	// Cap the file at the space left under any quota; an overwritten
+	// file's bytes are freed by the upload
+	limit := s.cfg.MaxUploadBytes
+	free, q, err := s.quotas.Allowance(filePath, username)
+	if err != nil {
+		log.Printf("upload: failed to check quota for %q: %v", filePath, err)
+	}
+	overQuota := q != nil && free+replaced < limit
+	if overQuota {
+		limit = free + replaced
+		if limit <= 0 {
+			return fail(http.StatusInsufficientStorage, quotaExceededMessage(q))
+		}
+	}
+
+	// Stream file to storage
+	n, err := s.storage.WriteStream(filePath, part, limit)
+	if errors.Is(err, storage.ErrFileTooLarge) && overQuota {
+		log.Printf("upload: %q exceeds %s quota for %q", filePath, q.Scope, q.Name)
+		return fail(http.StatusInsufficientStorage, quotaExceededMessage(q))
+	}
+	if errors.Is(err, storage.ErrFileTooLarge) {
+		log.Printf("upload: %q exceeds max upload size of %d bytes", filePath, s.cfg.MaxUploadBytes)
+		return fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("exceeds the maximum upload size of %s", FormatBytes(s.cfg.MaxUploadBytes)))
+	}
+	if IsRequestEntityTooLarge(err) {
+		return fail(http.StatusRequestEntityTooLarge, requestTooLargeReason)
+	}
+	if err != nil {
+		log.Printf("upload: failed to write %q: %v", filePath, err)
+		return fail(http.StatusInternalServerError, "could not be saved")
+	}
+	s.quotas.Added(filePath, username, n, replaced)
+	if sanitized {
+		s.names.Record(filePath, filename)
+	}
+	s.thumbs.Enqueue(filePath, s.cfg.ThumbMaxSizeAdmin)
+
+	res.Size = n
+	return res
 }
 
 // handleMkdir creates a new directory.
@@ -173,15 +370,17 @@ func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/files/"+strings.TrimPrefix(path, "/"), http.StatusSeeOther)
 }
 
-// handleDelete deletes a file or directory.
+// handleDelete moves a file or directory to the trash.
 func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 	path := r.FormValue("path")
 
-	if err := s.storage.Delete(path); err != nil {
-		log.Printf("delete: failed to delete %q: %v", path, err)
+	item, err := s.trash.Delete(path, auth.GetUsername(r))
+	if err != nil {
+		log.Printf("delete: failed to move %q to trash: %v", path, err)
 		http.Error(w, "Failed to delete", 500)
 		return
 	}
+	s.quotas.Removed(path, item.Size)
 
 	// Redirect to parent directory
 	parent := filepath.Dir(path)
@@ -198,32 +397,127 @@ func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.storage.Rename(path, newName); err != nil {
+	newPath, err := s.storage.Rename(path, newName)
+	if err != nil {
 		log.Printf("rename: failed to rename %q to %q: %v", path, newName, err)
 		http.Error(w, "Failed to rename", 500)
 		return
 	}
+	s.quotas.Moved(path, newPath)
 
 	// Redirect to parent directory
 	parent := filepath.Dir(path)
 	http.Redirect(w, r, "/files/"+strings.TrimPrefix(parent, "/"), http.StatusSeeOther)
 }
 
+// handleMove moves the selected paths into a destination folder.
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, false)
+}
+
+// handleCopy copies the selected paths into a destination folder.
+func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, true)
+}
+
+// handleTransfer implements move and copy for one or many "paths" form values,
+// with the "conflict" form value choosing fail, overwrite or rename.
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request, copy bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", 400)
+		return
+	}
+
+	from := "/files/" + strings.TrimPrefix(r.FormValue("from"), "/")
+	paths := r.Form["paths"]
+	dest := strings.TrimSpace(r.FormValue("dest"))
+	conflict := storage.ParseConflict(r.FormValue("conflict"))
+
+	if len(paths) == 0 {
+		http.Redirect(w, r, from+"?error=noselect", http.StatusSeeOther)
+		return
+	}
+	if dest == "" {
+		http.Redirect(w, r, from+"?error=nodest", http.StatusSeeOther)
+		return
+	}
+
+	op, result := "move", "moved"
+	if copy {
+		op, result = "copy", "copied"
+	}
+
+	username := auth.GetUsername(r)
+	var failed, exists, overQuota bool
+	for _, path := range paths {
+		// Bytes freed when an existing item is replaced
+		target := filepath.Join(dest, filepath.Base(path))
+		var replaced int64
+		if conflict == storage.ConflictOverwrite {
+			replaced, _ = s.storage.Size(target)
+		}
+
+		var newPath string
+		var err error
+		if copy {
+			if err = s.quotas.CheckCopy(path, target, username, replaced); err == nil {
+				newPath, err = s.storage.Copy(path, dest, conflict)
+			}
+		} else {
+			if err = s.quotas.CheckMove(path, target, replaced); err == nil {
+				newPath, err = s.storage.Move(path, dest, conflict)
+			}
+		}
+		if errors.Is(err, storage.ErrExists) {
+			exists = true
+			continue
+		}
+		if errors.Is(err, quota.ErrExceeded) {
+			overQuota = true
+			continue
+		}
+		if err != nil {
+			log.Printf("%s: failed to %s %q to %q: %v", op, op, path, dest, err)
+			failed = true
+			continue
+		}
+
+		if copy {
+			size, _ := s.storage.Size(newPath)
+			s.quotas.Added(newPath, username, size, replaced)
+		} else if newPath != path {
+			if replaced > 0 {
+				s.quotas.Removed(newPath, replaced)
+			}
+			s.quotas.Moved(path, newPath)
+		}
+	}
+
+	switch {
+	case failed:
+		http.Redirect(w, r, from+"?error=failed", http.StatusSeeOther)
+	case overQuota:
+		http.Redirect(w, r, from+"?error=quota", http.StatusSeeOther)
+	case exists:
+		http.Redirect(w, r, from+"?error=exists", http.StatusSeeOther)
+	default:
+		http.Redirect(w, r, from+"?success="+result, http.StatusSeeOther)
+	}
+}
+
 // handleDownload serves a file for download.
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	path := r.PathValue("path")
 
-	data, err := s.storage.Read(path)
+	f, err := s.storage.Open(path)
 	if err != nil {
-		log.Printf("download: failed to read file %q: %v", path, err)
+		log.Printf("download: failed to open file %q: %v", path, err)
 		http.Error(w, "File not found", 404)
 		return
 	}
+	defer f.Close()
 
-	filename := filepath.Base(path)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(data)
+	serveFile(w, r, f, filepath.Base(path))
 }
 
 // handleFilesThumb serves a thumbnail for an image file.
@@ -233,7 +527,7 @@ func (s *Server) handleFilesThumb(w http.ResponseWriter, r *http.Request) {
 	data, err := s.storage.GenerateThumbnail(path, s.cfg.ThumbMaxSizeAdmin)
 	if err != nil {
 		log.Printf("thumbnail: failed to generate thumbnail for %q: %v", path, err)
-		http.Error(w, "Thumbnail not available", 404)
+		thumbError(w, err)
 		return
 	}
 
@@ -247,9 +541,10 @@ func (s *Server) handleShareForm(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 
 	s.render(w, "admin/share_create", map[string]interface{}{
-		"Path":    path,
-		"Success": "",
-		"ShareURL": "",
+		"Path":      path,
+		"Success":   "",
+		"ShareURL":  "",
+		"Watermark": watermarkFields(nil),
 	})
 }
 
@@ -260,6 +555,12 @@ func (s *Server) handleShareCreate(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	expiresStr := r.FormValue("expires")
 
+	// Hidden paths (thumbnail cache, OS clutter) stay out of shares
+	if s.storage.Hidden(path) {
+		http.Error(w, "This path cannot be shared", 400)
+		return
+	}
+
 	var expiresAt *time.Time
 	if expiresStr != "" {
 		t, err := time.Parse("2006-01-02", expiresStr)
@@ -268,12 +569,35 @@ func (s *Server) handleShareCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	wm, err := watermarkFromForm(r, nil)
+	if err != nil {
+		s.render(w, "admin/share_create", map[string]interface{}{
+			"Path":      path,
+			"Error":     "Invalid watermark: " + err.Error(),
+			"Watermark": watermarkFields(wm),
+		})
+		return
+	}
+
 	share, err := s.shareStore.Create(path, name, password, expiresAt)
 	if err != nil {
 		log.Printf("share: failed to create share for path %q: %v", path, err)
 		http.Error(w, "Failed to create share", 500)
 		return
 	}
+	if wm != nil {
+		if err := s.shareStore.SetWatermark(share.ID, wm); err != nil {
+			// Without its watermark the share would hand out clean images
+			log.Printf("share: failed to save watermark for share %q: %v", share.Token, err)
+			s.shareStore.Delete(share.Token)
+			http.Error(w, "Failed to create share", 500)
+			return
+		}
+		share.Watermark = wm
+	}
+	// Have thumbnails and previews ready before the first visitor opens the share
+	s.thumbs.EnqueueDir(share.Path, s.cfg.ThumbMaxSizeShare, shareWatermark(share))
+	s.thumbs.EnqueueDir(share.Path, s.cfg.PreviewMaxSize, shareWatermark(share))
 
 	// Detect protocol from request
 	scheme := "http"
@@ -283,9 +607,10 @@ func (s *Server) handleShareCreate(w http.ResponseWriter, r *http.Request) {
 	shareURL := fmt.Sprintf("%s://%s/share/%s", scheme, r.Host, share.Token)
 
 	s.render(w, "admin/share_create", map[string]interface{}{
-		"Path":     path,
-		"Success":  "Share created successfully!",
-		"ShareURL": shareURL,
+		"Path":      path,
+		"Success":   "Share created successfully!",
+		"ShareURL":  shareURL,
+		"Watermark": watermarkFields(share.Watermark),
 	})
 }
 
@@ -325,6 +650,143 @@ func (s *Server) handleShareDelete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/shares?success=deleted", http.StatusSeeOther)
 }
 
+// handleShareWatermarkForm shows the watermark settings of a share.
+func (s *Server) handleShareWatermarkForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	sh, err := s.shareStore.GetByToken(token)
+	if err != nil {
+		http.Error(w, "Share not found", 404)
+		return
+	}
+
+	success := ""
+	if r.URL.Query().Get("success") == "saved" {
+		success = "Watermark saved."
+	}
+	s.render(w, "admin/share_watermark", map[string]interface{}{
+		"Share":     sh,
+		"Watermark": watermarkFields(sh.Watermark),
+		"Success":   success,
+	})
+}
+
+// handleShareWatermarkSave sets or removes the watermark of a share, and
+// queues thumbnails and previews with the new settings.
+func (s *Server) handleShareWatermarkSave(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	sh, err := s.shareStore.GetByToken(token)
+	if err != nil {
+		http.Error(w, "Share not found", 404)
+		return
+	}
+
+	wm, err := watermarkFromForm(r, sh.Watermark)
+	if err != nil {
+		s.render(w, "admin/share_watermark", map[string]interface{}{
+			"Share":     sh,
+			"Watermark": watermarkFields(wm),
+			"Error":     "Invalid watermark: " + err.Error(),
+		})
+		return
+	}
+	if err := s.shareStore.SetWatermark(sh.ID, wm); err != nil {
+		log.Printf("share: failed to save watermark for share %q: %v", token, err)
+		http.Error(w, "Failed to save watermark", 500)
+		return
+	}
+	sh.Watermark = wm
+	s.thumbs.EnqueueDir(sh.Path, s.cfg.ThumbMaxSizeShare, shareWatermark(sh))
+	s.thumbs.EnqueueDir(sh.Path, s.cfg.PreviewMaxSize, shareWatermark(sh))
+
+	http.Redirect(w, r, "/shares/watermark?token="+url.QueryEscape(token)+"&success=saved", http.StatusSeeOther)
+}
+
+// watermarkFromForm reads the watermark fields of the share forms: the kind
+// ("" for none, "text" or "image"), text, PNG upload, position, opacity and
+// size. An image watermark without a new upload keeps current's PNG. The
+// settings are returned with their validation error, to show them again.
+func watermarkFromForm(r *http.Request, current *watermark.Watermark) (*watermark.Watermark, error) {
+	kind := r.FormValue("watermark")
+	if kind == "" {
+		return nil, nil
+	}
+
+	wm := &watermark.Watermark{
+		Position: r.FormValue("watermark_position"),
+		Opacity:  watermark.DefaultOpacity,
+		Scale:    watermark.DefaultScale,
+	}
+	if wm.Position == "" {
+		wm.Position = watermark.PositionCenter
+	}
+	if v := r.FormValue("watermark_opacity"); v != "" {
+		wm.Opacity, _ = strconv.Atoi(v)
+	}
+	if v := r.FormValue("watermark_scale"); v != "" {
+		wm.Scale, _ = strconv.Atoi(v)
+	}
+	switch kind {
+	case "text":
+		wm.Text = strings.TrimSpace(r.FormValue("watermark_text"))
+	case "image":
+		file, _, err := r.FormFile("watermark_image")
+		switch {
+		case err == nil:
+			defer file.Close()
+			if wm.Image, err = io.ReadAll(io.LimitReader(file, watermark.MaxImageBytes+1)); err != nil {
+				return wm, errors.New("could not read the watermark image")
+			}
+		case current != nil && len(current.Image) > 0:
+			wm.Image = current.Image
+		default:
+			return wm, errors.New("choose a PNG image")
+		}
+	default:
+		return nil, errors.New("unknown watermark type")
+	}
+	return wm, wm.Validate()
+}
+
+// watermarkPositions labels the watermark positions for the share forms.
+var watermarkPositions = map[string]string{
+	watermark.PositionCenter:      "Center",
+	watermark.PositionTopLeft:     "Top left",
+	watermark.PositionTopRight:    "Top right",
+	watermark.PositionBottomLeft:  "Bottom left",
+	watermark.PositionBottomRight: "Bottom right",
+	watermark.PositionTile:        "Tiled over the image",
+}
+
+// watermarkFields fills the watermark fields of the share forms from wm (nil
+// = none, with defaults for when one is turned on).
+func watermarkFields(wm *watermark.Watermark) map[string]interface{} {
+	var positions []map[string]string
+	for _, p := range watermark.Positions {
+		positions = append(positions, map[string]string{"Value": p, "Label": watermarkPositions[p]})
+	}
+	fields := map[string]interface{}{
+		"Kind":      "",
+		"Text":      "",
+		"HasImage":  false,
+		"Position":  watermark.PositionCenter,
+		"Opacity":   watermark.DefaultOpacity,
+		"Scale":     watermark.DefaultScale,
+		"Positions": positions,
+	}
+	if wm != nil {
+		fields["Kind"] = "text"
+		if len(wm.Image) > 0 {
+			fields["Kind"] = "image"
+		}
+		fields["Text"] = wm.Text
+		fields["HasImage"] = len(wm.Image) > 0
+		fields["Position"] = wm.Position
+		fields["Opacity"] = wm.Opacity
+		fields["Scale"] = wm.Scale
+	}
+	return fields
+}
+
 // buildBreadcrumbs creates breadcrumb navigation from a path.
 func buildBreadcrumbs(path string) []map[string]string {
 	if path == "/" || path == "" {
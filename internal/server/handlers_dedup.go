@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"nas-dop/internal/index"
+	"nas-dop/internal/storage"
+)
+
+// maxDuplicateGroups caps how many duplicate groups the page shows at once.
+const maxDuplicateGroups = 200
+
+// duplicatesMessages maps ?success= / ?error= codes to messages on the duplicates page.
+var duplicatesMessages = map[string]string{
+	"linked":      "Duplicates linked to the kept copy.",
+	"partial":     "Some duplicates could not be linked (changed since indexing or on another filesystem). Check the server log for details.",
+	"unsupported": "This storage backend cannot hardlink files.",
+	"notfound":    "Those duplicates are no longer in the index.",
+	"nokeep":      "Choose the copy to keep.",
+}
+
+// duplicateGroup is a set of identical files as shown on the duplicates page.
+type duplicateGroup struct {
+	*index.Duplicates
+	Files       []duplicateFile
+	Copies      int   // Distinct copies on disk (linked files count once)
+	Reclaimable int64 // Bytes freed by linking the rest to one copy
+}
+
+// duplicateFile is one path in a duplicateGroup.
+type duplicateFile struct {
+	*index.Entry
+	Linked bool // Already shares its disk copy with an earlier file in the group
+}
+
+// newDuplicateGroup works out which files of d already share a disk copy.
+func (s *Server) newDuplicateGroup(d *index.Duplicates) *duplicateGroup {
+	g := &duplicateGroup{Duplicates: d}
+	for i, e := range d.Entries {
+		f := duplicateFile{Entry: e}
+		for _, prev := range d.Entries[:i] {
+			if s.storage.SameFile(prev.Path, e.Path) {
+				f.Linked = true
+				break
+			}
+		}
+		if !f.Linked {
+			g.Copies++
+		}
+		g.Files = append(g.Files, f)
+	}
+	if g.Copies > 1 {
+		g.Reclaimable = d.Size * int64(g.Copies-1)
+	}
+	return g
+}
+
+// handleDuplicates lists files with identical content across ROOT.
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	list, total, err := s.index.FindDuplicates(maxDuplicateGroups)
+	if err != nil {
+		log.Printf("dedup: failed to find duplicates: %v", err)
+		http.Error(w, "Failed to find duplicates", 500)
+		return
+	}
+	stats, err := s.index.Stats()
+	if err != nil {
+		log.Printf("dedup: failed to load index stats: %v", err)
+	}
+
+	var groups []*duplicateGroup
+	var reclaimable int64
+	for _, d := range list {
+		g := s.newDuplicateGroup(d)
+		reclaimable += g.Reclaimable
+		groups = append(groups, g)
+	}
+
+	s.render(w, "admin/duplicates", map[string]interface{}{
+		"Groups":      groups,
+		"Total":       total,
+		"Reclaimable": reclaimable,
+		"CanLink":     s.storage.CanLink(),
+		"Stats":       stats,
+		"Success":     duplicatesMessages[r.URL.Query().Get("success")],
+		"Error":       duplicatesMessages[r.URL.Query().Get("error")],
+	})
+}
+
+// handleDuplicatesLink keeps one copy of a group (hash, keep) and replaces the
+// others with hardlinks to it.
+func (s *Server) handleDuplicatesLink(w http.ResponseWriter, r *http.Request) {
+	if !s.storage.CanLink() {
+		http.Redirect(w, r, "/duplicates?error=unsupported", http.StatusSeeOther)
+		return
+	}
+	keep := r.FormValue("keep")
+	if keep == "" {
+		http.Redirect(w, r, "/duplicates?error=nokeep", http.StatusSeeOther)
+		return
+	}
+
+	d, err := s.index.DuplicatesOf(r.FormValue("hash"))
+	if err != nil {
+		log.Printf("dedup: failed to load duplicates: %v", err)
+		http.Error(w, "Failed to link duplicates", 500)
+		return
+	}
+	if d == nil {
+		http.Redirect(w, r, "/duplicates?error=notfound", http.StatusSeeOther)
+		return
+	}
+
+	if s.linkDuplicates(d, keep) > 0 {
+		http.Redirect(w, r, "/duplicates?error=partial", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/duplicates?success=linked", http.StatusSeeOther)
+}
+
+// handleDuplicatesLinkAll links every duplicate group to its oldest copy.
+func (s *Server) handleDuplicatesLinkAll(w http.ResponseWriter, r *http.Request) {
+	if !s.storage.CanLink() {
+		http.Redirect(w, r, "/duplicates?error=unsupported", http.StatusSeeOther)
+		return
+	}
+
+	list, _, err := s.index.FindDuplicates(0)
+	if err != nil {
+		log.Printf("dedup: failed to find duplicates: %v", err)
+		http.Error(w, "Failed to link duplicates", 500)
+		return
+	}
+
+	failed := 0
+	for _, d := range list {
+		failed += s.linkDuplicates(d, d.Entries[0].Path)
+	}
+
+	if failed > 0 {
+		http.Redirect(w, r, "/duplicates?error=partial", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/duplicates?success=linked", http.StatusSeeOther)
+}
+
+// linkDuplicates replaces every file of d except keep with a hardlink to keep.
+// Returns how many could not be linked.
+func (s *Server) linkDuplicates(d *index.Duplicates, keep string) int {
+	found := false
+	for _, e := range d.Entries {
+		if e.Path == keep {
+			found = true
+		}
+	}
+	if !found {
+		log.Printf("dedup: %q is not in duplicate group %s", keep, d.Hash)
+		return len(d.Entries)
+	}
+
+	failed := 0
+	for _, e := range d.Entries {
+		if e.Path == keep {
+			continue
+		}
+		if err := s.storage.Link(keep, e.Path); err != nil {
+			if errors.Is(err, storage.ErrContentDiffers) {
+				log.Printf("dedup: %q no longer matches %q, skipped", e.Path, keep)
+			} else {
+				log.Printf("dedup: failed to link %q to %q: %v", e.Path, keep, err)
+			}
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"nas-dop/internal/quota"
+)
+
+// quotaMessages maps ?success= / ?error= codes to messages on the quotas page.
+var quotaMessages = map[string]string{
+	"saved":        "Quota saved.",
+	"removed":      "Quota removed.",
+	"recalculated": "Usage recalculated.",
+	"invalid":      "Enter a top-level folder or username and a size such as 50GB.",
+	"notfound":     "Quota not found.",
+}
+
+// handleQuotasList displays quotas with usage bars.
+func (s *Server) handleQuotasList(w http.ResponseWriter, r *http.Request) {
+	quotas, err := s.quotas.List()
+	if err != nil {
+		log.Printf("quota: failed to list quotas: %v", err)
+		http.Error(w, "Failed to list quotas", 500)
+		return
+	}
+
+	s.render(w, "admin/quotas", map[string]interface{}{
+		"Quotas":  quotas,
+		"Success": quotaMessages[r.URL.Query().Get("success")],
+		"Error":   quotaMessages[r.URL.Query().Get("error")],
+	})
+}
+
+// handleQuotaSet creates or updates a quota from "scope", "name" and "limit" (e.g. "50GB").
+func (s *Server) handleQuotaSet(w http.ResponseWriter, r *http.Request) {
+	scope, ok := quota.ParseScope(r.FormValue("scope"))
+	if !ok {
+		http.Redirect(w, r, "/quotas?error=invalid", http.StatusSeeOther)
+		return
+	}
+	limit, err := parseBytes(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		http.Redirect(w, r, "/quotas?error=invalid", http.StatusSeeOther)
+		return
+	}
+
+	name := r.FormValue("name")
+	if _, err := s.quotas.Set(scope, name, limit); err != nil {
+		log.Printf("quota: failed to set %s quota for %q: %v", scope, name, err)
+		http.Redirect(w, r, "/quotas?error=invalid", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/quotas?success=saved", http.StatusSeeOther)
+}
+
+// handleQuotaDelete removes a quota.
+func (s *Server) handleQuotaDelete(w http.ResponseWriter, r *http.Request) {
+	scope := quota.Scope(r.FormValue("scope"))
+	name := r.FormValue("name")
+
+	if err := s.quotas.Remove(scope, name); err != nil {
+		if errors.Is(err, quota.ErrNotFound) {
+			http.Redirect(w, r, "/quotas?error=notfound", http.StatusSeeOther)
+			return
+		}
+		log.Printf("quota: failed to remove %s quota for %q: %v", scope, name, err)
+		http.Error(w, "Failed to remove quota", 500)
+		return
+	}
+
+	http.Redirect(w, r, "/quotas?success=removed", http.StatusSeeOther)
+}
+
+// handleQuotaRecalculate recomputes usage for all quotas (e.g. after files were
+// added or removed outside the app).
+func (s *Server) handleQuotaRecalculate(w http.ResponseWriter, r *http.Request) {
+	if err := s.quotas.Recalculate(); err != nil {
+		log.Printf("quota: failed to recalculate usage: %v", err)
+		http.Error(w, "Failed to recalculate usage", 500)
+		return
+	}
+
+	http.Redirect(w, r, "/quotas?success=recalculated", http.StatusSeeOther)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nas-dop/internal/index"
+)
+
+// searchMessages maps ?success= codes to messages on the search page.
+var searchMessages = map[string]string{
+	"rescan": "Rescan started. Results update as the index catches up.",
+}
+
+// parseSearchQuery reads search criteria from the URL query:
+// q (name words), ext (comma-separated), type (file/dir), from/to (YYYY-MM-DD,
+// inclusive), min/max (sizes such as 10MB), limit and offset.
+func parseSearchQuery(r *http.Request) (index.Query, error) {
+	v := r.URL.Query()
+	q := index.Query{
+		Text: strings.TrimSpace(v.Get("q")),
+		Type: v.Get("type"),
+	}
+
+	for _, ext := range strings.Split(v.Get("ext"), ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			q.Exts = append(q.Exts, ext)
+		}
+	}
+	if q.Type != "" && q.Type != "file" && q.Type != "dir" {
+		return q, fmt.Errorf("type must be file or dir")
+	}
+
+	if s := v.Get("from"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return q, fmt.Errorf("invalid from date %q", s)
+		}
+		q.From = t
+	}
+	if s := v.Get("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return q, fmt.Errorf("invalid to date %q", s)
+		}
+		q.To = t.AddDate(0, 0, 1) // Include the whole day
+	}
+
+	var err error
+	if s := v.Get("min"); s != "" {
+		if q.MinSize, err = parseBytes(s); err != nil {
+			return q, err
+		}
+	}
+	if s := v.Get("max"); s != "" {
+		if q.MaxSize, err = parseBytes(s); err != nil {
+			return q, err
+		}
+	}
+
+	q.Limit, _ = strconv.Atoi(v.Get("limit"))
+	q.Offset, _ = strconv.Atoi(v.Get("offset"))
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	return q, nil
+}
+
+// handleSearch renders the search page and, when criteria are given, the results.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.index.Stats()
+	if err != nil {
+		log.Printf("search: failed to load index stats: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"Form":    r.URL.Query(),
+		"Stats":   stats,
+		"Success": searchMessages[r.URL.Query().Get("success")],
+	}
+
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		data["Error"] = err.Error()
+		s.render(w, "admin/search", data)
+		return
+	}
+
+	if !q.Empty() {
+		results, total, err := s.index.Search(q)
+		if err != nil {
+			log.Printf("search: query failed: %v", err)
+			http.Error(w, "Search failed", 500)
+			return
+		}
+		data["Searched"] = true
+		data["Results"] = results
+		data["Total"] = total
+
+		// Link to the next page by bumping offset
+		if q.Offset+len(results) < total {
+			next := r.URL.Query()
+			next.Set("offset", strconv.Itoa(q.Offset+len(results)))
+			data["NextPage"] = "/search?" + next.Encode()
+		}
+	}
+
+	s.render(w, "admin/search", data)
+}
+
+// handleSearchAPI returns search results as JSON:
+// {"total": n, "offset": n, "results": [{path, name, ext, is_dir, size, mod_time, hash}]}.
+func (s *Server) handleSearchAPI(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	results, total, err := s.index.Search(q)
+	if err != nil {
+		log.Printf("search: query failed: %v", err)
+		http.Error(w, "Search failed", 500)
+		return
+	}
+	if results == nil {
+		results = []*index.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":   total,
+		"offset":  q.Offset,
+		"results": results,
+	})
+}
+
+// handleSearchRescan starts a full index rescan in the background.
+func (s *Server) handleSearchRescan(w http.ResponseWriter, r *http.Request) {
+	s.index.Rescan()
+	http.Redirect(w, r, "/search?success=rescan", http.StatusSeeOther)
+}
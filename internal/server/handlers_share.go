@@ -1,11 +1,15 @@
 package server
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
 	"log"
 	"net/http"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"nas-dop/internal/share"
 	"nas-dop/internal/storage"
@@ -43,7 +47,15 @@ func (s *Server) handleSharePage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// List files
-	files, err := s.storage.List(sh.Path)
+	page, list, err := s.listPage(r, sh.Path)
+	if errors.Is(err, storage.ErrBadCursor) {
+		http.Error(w, "Invalid page cursor", 400)
+		return
+	}
+	if errors.Is(err, storage.ErrHidden) {
+		http.Error(w, "Share not found", 404)
+		return
+	}
 	if err != nil {
 		log.Printf("share: failed to list files for share %q at path %q: %v", token, sh.Path, err)
 		http.Error(w, "Failed to list files", 500)
@@ -54,7 +66,9 @@ func (s *Server) handleSharePage(w http.ResponseWriter, r *http.Request) {
 		"Token": token,
 		"Name":  sh.Name,
 		"Path":  sh.Path,
-		"Files": files,
+		"Files": page.Files,
+		"List":  list,
+		"Query": listQuery(r),
 	})
 }
 
@@ -121,28 +135,68 @@ func (s *Server) handleShareDownload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify path is within share path
-	fullPath := filepath.Join(sh.Path, filePath)
-	if !strings.HasPrefix(filepath.Clean(fullPath), filepath.Clean(sh.Path)) {
+	fullPath, ok := s.sharePath(sh.Path, filePath)
+	if !ok {
 		http.Error(w, "Access denied", 403)
 		return
 	}
 
-	// Read file
-	data, err := s.storage.Read(fullPath)
+	// Watermarked shares never hand out an image as stored, whatever its name
+	wm := shareWatermark(sh)
+	stamp := false
+	if wm != nil {
+		if stamp, err = s.storage.NeedsWatermark(fullPath); err != nil {
+			log.Printf("share: cannot watermark %q for share %q: %v", fullPath, token, err)
+			if errors.Is(err, storage.ErrCannotWatermark) {
+				http.Error(w, "This file cannot be downloaded from this share", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "File not found", 404)
+			return
+		}
+	}
+	if stamp {
+		data, err := s.storage.GenerateWatermarked(fullPath, 0, wm)
+		if err != nil {
+			log.Printf("share: failed to watermark %q for share %q: %v", fullPath, token, err)
+			if errors.Is(err, storage.ErrImageTooLarge) {
+				http.Error(w, "Image too large to watermark", http.StatusUnprocessableEntity)
+				return
+			}
+			http.Error(w, "File not found", 404)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", storage.WatermarkedName(filepath.Base(filePath))))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+
+	// Stream file (supports Range / conditional GET)
+	f, err := s.storage.Open(fullPath)
 	if err != nil {
-		log.Printf("share: failed to read file %q for share %q: %v", fullPath, token, err)
+		log.Printf("share: failed to open file %q for share %q: %v", fullPath, token, err)
 		http.Error(w, "File not found", 404)
 		return
 	}
+	defer f.Close()
 
-	filename := filepath.Base(filePath)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(data)
+	serveFile(w, r, f, filepath.Base(filePath))
 }
 
 // handleShareThumb serves a thumbnail for an image file in a share.
 func (s *Server) handleShareThumb(w http.ResponseWriter, r *http.Request) {
+	s.serveShareImage(w, r, s.cfg.ThumbMaxSizeShare)
+}
+
+// handleSharePreview serves a web preview (PREVIEW_MAX_SIZE) of an image file
+// in a share, as shown by the viewer.
+func (s *Server) handleSharePreview(w http.ResponseWriter, r *http.Request) {
+	s.serveShareImage(w, r, s.cfg.PreviewMaxSize)
+}
+
+// serveShareImage serves an image file in a share resized to maxSize.
+func (s *Server) serveShareImage(w http.ResponseWriter, r *http.Request, maxSize int) {
 	token := r.PathValue("token")
 	filePath := r.PathValue("path")
 
@@ -169,17 +223,17 @@ func (s *Server) handleShareThumb(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify path is within share path
-	fullPath := filepath.Join(sh.Path, filePath)
-	if !strings.HasPrefix(filepath.Clean(fullPath), filepath.Clean(sh.Path)) {
+	fullPath, ok := s.sharePath(sh.Path, filePath)
+	if !ok {
 		http.Error(w, "Access denied", 403)
 		return
 	}
 
-	// Generate thumbnail
-	data, err := s.storage.GenerateThumbnail(fullPath, s.cfg.ThumbMaxSizeShare)
+	// Generate thumbnail (watermarked if the share has one)
+	data, err := s.storage.GenerateWatermarked(fullPath, maxSize, shareWatermark(sh))
 	if err != nil {
-		log.Printf("share: failed to generate thumbnail for %q in share %q: %v", fullPath, token, err)
-		http.Error(w, "Thumbnail not available", 404)
+		log.Printf("share: failed to generate %dpx image for %q in share %q: %v", maxSize, fullPath, token, err)
+		thumbError(w, err)
 		return
 	}
 
@@ -188,6 +242,95 @@ func (s *Server) handleShareThumb(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// handleShareView shows one image of a share as a web preview, with links to
+// the previous and next images in the gallery's order and a download button.
+func (s *Server) handleShareView(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	filePath := r.PathValue("path")
+
+	// Load share
+	sh, err := s.shareStore.GetByToken(token)
+	if err != nil {
+		http.Error(w, "Share not found", 404)
+		return
+	}
+
+	// Check if expired
+	if share.IsExpired(sh) {
+		http.Error(w, "Share has expired", 410)
+		return
+	}
+
+	// Check password protection (the share page asks for it)
+	if sh.PasswordHash != "" {
+		cookie, err := r.Cookie("share_" + token)
+		if err != nil || cookie.Value != "validated" {
+			http.Redirect(w, r, "/share/"+token, http.StatusSeeOther)
+			return
+		}
+	}
+
+	// Verify path is within share path
+	fullPath, ok := s.sharePath(sh.Path, filePath)
+	if !ok {
+		http.Error(w, "Access denied", 403)
+		return
+	}
+	info, err := s.storage.Stat(fullPath)
+	if err != nil || info.IsDir || !storage.IsImage(fullPath) || s.storage.Hidden(fullPath) {
+		http.Error(w, "Image not found", 404)
+		return
+	}
+
+	// Images around this one, in the order of the gallery the visitor came
+	// from (a share of a single file has just that one)
+	rel := strings.TrimPrefix(path.Clean("/"+filePath), "/")
+	name := path.Base(fullPath)
+	images := []string{name}
+	if fullPath != path.Clean("/"+sh.Path) {
+		opts := s.listOptions(r)
+		opts.After, opts.Limit = "", 0
+		page, err := s.storage.ListPage(path.Dir(fullPath), opts)
+		if err != nil {
+			log.Printf("share: failed to list images around %q in share %q: %v", fullPath, token, err)
+			http.Error(w, "Failed to list files", 500)
+			return
+		}
+		var names []string
+		for _, f := range page.Files {
+			if !f.IsDir && f.IsImage() {
+				names = append(names, f.Name)
+			}
+		}
+		if slices.Contains(names, name) { // Else filtered out: shown alone
+			images = names
+		}
+	}
+	i := slices.Index(images, name)
+	neighbour := func(j int) string {
+		if j < 0 || j >= len(images) {
+			return ""
+		}
+		return path.Join(path.Dir(rel), images[j])
+	}
+
+	query := listQuery(r)
+	s.render(w, "share/view", map[string]interface{}{
+		"Token":       token,
+		"Name":        sh.Name,
+		"Path":        rel,
+		"FileName":    name,
+		"Size":        info.Size,
+		"Watermarked": sh.Watermark != nil,
+		"Prev":        neighbour(i - 1),
+		"Next":        neighbour(i + 1),
+		"Index":       i + 1,
+		"Count":       len(images),
+		"Query":       query,
+		"Back":        "/share/" + token + query,
+	})
+}
+
 // handleShareZip creates a ZIP of selected files (Phase 3 - basic implementation).
 func (s *Server) handleShareZip(w http.ResponseWriter, r *http.Request) {
 	token := r.PathValue("token")
@@ -230,8 +373,8 @@ func (s *Server) handleShareZip(w http.ResponseWriter, r *http.Request) {
 	// Validate all paths are within share path
 	var fullPaths []string
 	for _, relPath := range paths {
-		fullPath := filepath.Join(sh.Path, relPath)
-		if !strings.HasPrefix(filepath.Clean(fullPath), filepath.Clean(sh.Path)) {
+		fullPath, ok := s.sharePath(sh.Path, relPath)
+		if !ok {
 			http.Error(w, "Access denied", 403)
 			return
 		}
@@ -246,13 +389,35 @@ func (s *Server) handleShareZip(w http.ResponseWriter, r *http.Request) {
 
 	// Set headers
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sh.Name+".zip"))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", sh.Name+".zip"))
 
-	// Stream ZIP
-	if err := s.storage.CreateZip(w, fullPaths, limits); err != nil {
+	// Stream ZIP (images watermarked if the share has one)
+	if err := s.storage.CreateWatermarkedZip(w, fullPaths, limits, shareWatermark(sh)); err != nil {
 		// Can't send error response after headers are sent
 		// Log the error instead
 		log.Printf("share: failed to create ZIP for share %q: %v", token, err)
 		return
 	}
 }
+
+// shareWatermark returns the watermark stamped on a share's images, or nil
+// (a nil interface, not a nil *watermark.Watermark) when it has none.
+func shareWatermark(sh *share.Share) storage.Watermark {
+	if sh.Watermark == nil {
+		return nil
+	}
+	return sh.Watermark
+}
+
+// sharePath joins relPath onto a share's root path and reports whether the
+// result stays inside it. The lexical check compares on a path-separator
+// boundary (a share of /cust cannot reach /cust2); Storage.Within then checks
+// real paths so a symlink cannot lead out of the shared folder either.
+func (s *Server) sharePath(root, relPath string) (string, bool) {
+	rootClean := filepath.Clean("/" + root)
+	fullPath := filepath.Clean("/" + filepath.Join(root, relPath))
+	if rootClean != "/" && fullPath != rootClean && !strings.HasPrefix(fullPath, rootClean+"/") {
+		return fullPath, false
+	}
+	return fullPath, s.storage.Within(fullPath, rootClean)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"nas-dop/internal/storage"
+)
+
+// thumbnailsMessages maps ?success= / ?error= codes to messages on the thumbnails page.
+var thumbnailsMessages = map[string]string{
+	"swept":  "Cache swept.",
+	"failed": "The sweep failed. Check the server log for details.",
+}
+
+// thumbSweepLoop sweeps the thumbnail cache at startup and every
+// THUMB_CACHE_SWEEP, dropping thumbs of removed or changed files.
+func (s *Server) thumbSweepLoop() {
+	s.sweepThumbnails()
+	if s.cfg.ThumbCacheSweep <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.ThumbCacheSweep)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sweepThumbnails()
+	}
+}
+
+func (s *Server) sweepThumbnails() error {
+	if err := s.storage.SweepThumbnails(); err != nil {
+		log.Printf("thumbnail: failed to sweep cache: %v", err)
+		return err
+	}
+	return nil
+}
+
+// maxQueueShown caps how many queued jobs the thumbnails page lists.
+const maxQueueShown = 20
+
+// handleThumbnails shows thumbnail cache usage and hit rate, and the
+// background generation queue.
+func (s *Server) handleThumbnails(w http.ResponseWriter, r *http.Request) {
+	s.render(w, "admin/thumbnails", map[string]interface{}{
+		"Cache":   s.storage.ThumbCacheStats(),
+		"Queue":   s.thumbs.Stats(maxQueueShown),
+		"Success": thumbnailsMessages[r.URL.Query().Get("success")],
+		"Error":   thumbnailsMessages[r.URL.Query().Get("error")],
+	})
+}
+
+// handleThumbnailsSweep runs a cache sweep now.
+func (s *Server) handleThumbnailsSweep(w http.ResponseWriter, r *http.Request) {
+	if err := s.sweepThumbnails(); err != nil {
+		http.Redirect(w, r, "/thumbnails?error=failed", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/thumbnails?success=swept", http.StatusSeeOther)
+}
+
+// thumbError answers a thumbnail request that failed. Images over the decode
+// limits say so; pages show a file icon either way.
+func thumbError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrImageTooLarge) {
+		http.Error(w, "Image too large for a thumbnail", http.StatusUnprocessableEntity)
+		return
+	}
+	http.Error(w, "Thumbnail not available", 404)
+}
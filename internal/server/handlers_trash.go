@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nas-dop/internal/auth"
+	"nas-dop/internal/quota"
+	"nas-dop/internal/storage"
+	"nas-dop/internal/trash"
+)
+
+// trashMessages maps ?success= / ?error= codes to messages on the trash page.
+var trashMessages = map[string]string{
+	"restored": "Item restored.",
+	"deleted":  "Item permanently deleted.",
+	"emptied":  "Trash emptied.",
+	"exists":   "Cannot restore: something already exists at the original path. Rename or move it first.",
+	"notfound": "Trash item not found.",
+	"quota":    "Cannot restore: the item does not fit the quota of its folder or user.",
+}
+
+// handleTrashList displays the recycle bin.
+func (s *Server) handleTrashList(w http.ResponseWriter, r *http.Request) {
+	items, err := s.trash.List()
+	if err != nil {
+		log.Printf("trash: failed to list items: %v", err)
+		http.Error(w, "Failed to list trash", 500)
+		return
+	}
+
+	s.render(w, "admin/trash", map[string]interface{}{
+		"Items":         items,
+		"RetentionDays": int(s.trash.Retention().Hours() / 24),
+		"Retention":     s.trash.Retention(),
+		"Success":       trashMessages[r.URL.Query().Get("success")],
+		"Error":         trashMessages[r.URL.Query().Get("error")],
+	})
+}
+
+// handleTrashRestore moves a trashed item back to its original path.
+func (s *Server) handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", 400)
+		return
+	}
+
+	// Restored bytes count against quotas again
+	item, err := s.trash.Get(id)
+	if errors.Is(err, trash.ErrNotFound) {
+		http.Redirect(w, r, "/trash?error=notfound", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("trash: failed to get item %d: %v", id, err)
+		http.Error(w, "Failed to restore", 500)
+		return
+	}
+	username := auth.GetUsername(r)
+	if err := s.quotas.Check(item.OriginalPath, username, item.Size); err != nil {
+		if errors.Is(err, quota.ErrExceeded) {
+			http.Redirect(w, r, "/trash?error=quota", http.StatusSeeOther)
+			return
+		}
+		log.Printf("trash: failed to check quota for %q: %v", item.OriginalPath, err)
+	}
+
+	if _, err := s.trash.Restore(id); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrExists):
+			http.Redirect(w, r, "/trash?error=exists", http.StatusSeeOther)
+		case errors.Is(err, trash.ErrNotFound):
+			http.Redirect(w, r, "/trash?error=notfound", http.StatusSeeOther)
+		default:
+			log.Printf("trash: failed to restore item %d: %v", id, err)
+			http.Error(w, "Failed to restore", 500)
+		}
+		return
+	}
+
+	s.quotas.Added(item.OriginalPath, username, item.Size, 0)
+
+	http.Redirect(w, r, "/trash?success=restored", http.StatusSeeOther)
+}
+
+// handleTrashPurge permanently deletes one trashed item.
+func (s *Server) handleTrashPurge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", 400)
+		return
+	}
+
+	if err := s.trash.Purge(id); err != nil {
+		if errors.Is(err, trash.ErrNotFound) {
+			http.Redirect(w, r, "/trash?error=notfound", http.StatusSeeOther)
+			return
+		}
+		log.Printf("trash: failed to purge item %d: %v", id, err)
+		http.Error(w, "Failed to delete", 500)
+		return
+	}
+
+	http.Redirect(w, r, "/trash?success=deleted", http.StatusSeeOther)
+}
+
+// handleTrashEmpty permanently deletes everything in the trash.
+func (s *Server) handleTrashEmpty(w http.ResponseWriter, r *http.Request) {
+	items, err := s.trash.List()
+	if err != nil {
+		log.Printf("trash: failed to list items: %v", err)
+		http.Error(w, "Failed to empty trash", 500)
+		return
+	}
+
+	for _, item := range items {
+		if err := s.trash.Purge(item.ID); err != nil {
+			log.Printf("trash: failed to purge item %d: %v", item.ID, err)
+		}
+	}
+
+	http.Redirect(w, r, "/trash?success=emptied", http.StatusSeeOther)
+}
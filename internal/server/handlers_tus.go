@@ -0,0 +1,305 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"nas-dop/internal/auth"
+	"nas-dop/internal/quota"
+	"nas-dop/internal/storage"
+	"nas-dop/internal/upload"
+)
+
+// Resumable uploads implementing the tus 1.0 core protocol plus the creation,
+// expiration and termination extensions (https://tus.io/protocols/resumable-upload).
+// Clients send Upload-Metadata with "filename" and optionally "path" (target directory).
+
+// tusExtensions lists the tus extensions this server supports.
+const tusExtensions = "creation,expiration,termination"
+
+// handleTusOptions advertises tus capabilities.
+func (s *Server) handleTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", upload.TusVersion)
+	w.Header().Set("Tus-Version", upload.TusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.cfg.MaxUploadBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTusCreate creates a new resumable upload (creation extension).
+func (s *Server) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "Upload-Length required", 400)
+		return
+	}
+	if size > s.cfg.MaxUploadBytes {
+		http.Error(w, "Upload exceeds maximum upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata := r.Header.Get("Upload-Metadata")
+	meta := upload.ParseMetadata(metadata)
+	filename := filepath.Base(meta["filename"])
+	if filename == "." || filename == "/" || filename == "" {
+		http.Error(w, "Upload-Metadata filename required", 400)
+		return
+	}
+	targetPath, err := s.storage.CleanPath(filepath.Join(meta["path"], filename))
+	if err != nil || s.storage.Validate(targetPath) != nil {
+		http.Error(w, "Invalid path", 400)
+		return
+	}
+	conflict := s.tusConflict(meta)
+	if conflict == storage.ConflictFail && s.storage.Exists(targetPath) {
+		http.Error(w, "A file with this name already exists", http.StatusConflict)
+		return
+	}
+
+	// Reserve nothing, but refuse uploads that cannot fit a quota up front
+	username := auth.GetUsername(r)
+	var replaced int64
+	if conflict == storage.ConflictOverwrite {
+		replaced = s.existingSize(targetPath)
+	}
+	if err := s.quotas.Check(targetPath, username, size-replaced); err != nil {
+		if errors.Is(err, quota.ErrExceeded) {
+			writeQuotaExceeded(w, err)
+			return
+		}
+		log.Printf("tus: failed to check quota for %q: %v", targetPath, err)
+	}
+
+	up, err := s.uploads.Create(targetPath, size, metadata, username)
+	if err != nil {
+		log.Printf("tus: failed to create upload for %q: %v", targetPath, err)
+		http.Error(w, "Failed to create upload", 500)
+		return
+	}
+
+	// Empty files are complete on creation
+	if up.Complete() && !s.finishTusUpload(w, up) {
+		return
+	}
+
+	w.Header().Set("Location", "/files/tus/"+up.ID)
+	w.Header().Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleTusHead reports the current offset of an upload.
+func (s *Server) handleTusHead(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+
+	up, ok := s.tusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Size, 10))
+	w.Header().Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	if up.Metadata != "" {
+		w.Header().Set("Upload-Metadata", up.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTusPatch appends a chunk and moves the file into storage once complete.
+func (s *Server) handleTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset required", 400)
+		return
+	}
+
+	up, ok := s.tusUpload(w, r)
+	if !ok {
+		return
+	}
+	if r.ContentLength > 0 && offset+r.ContentLength > up.Size {
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id := up.ID
+	up, err = s.uploads.Append(id, offset, r.Body)
+	if err != nil {
+		if IsRequestEntityTooLarge(err) {
+			WriteRequestEntityTooLarge(w)
+			return
+		}
+		if up != nil && !errors.Is(err, upload.ErrOffsetMismatch) {
+			// Client went away mid-chunk; bytes so far are recorded for resume
+			log.Printf("tus: upload %q interrupted at offset %d: %v", id, up.Offset, err)
+		}
+		s.writeTusError(w, err)
+		return
+	}
+
+	if up.Complete() && !s.finishTusUpload(w, up) {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTusDelete cancels an upload and removes staged data (termination extension).
+func (s *Server) handleTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTusVersion(w, r) {
+		return
+	}
+
+	up, ok := s.tusUpload(w, r)
+	if !ok {
+		return
+	}
+	id := up.ID
+	if err := s.uploads.Delete(id); err != nil {
+		log.Printf("tus: failed to delete upload %q: %v", id, err)
+		http.Error(w, "Failed to delete upload", 500)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", upload.TusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a complete upload into storage and drops its record,
+// applying the upload's conflict policy ("conflict" metadata) to the target
+// name as it is now. Quotas are checked again, as other uploads may have
+// used the room since creation; finishes run one at a time so two cannot
+// both pass the check. On failure the upload is kept, so a zero-length PATCH
+// retries the move.
+func (s *Server) finishTusUpload(w http.ResponseWriter, up *upload.Upload) bool {
+	s.tusFinish.Lock()
+	defer s.tusFinish.Unlock()
+
+	// The original name is only kept when sanitizing changed it, not when the
+	// conflict policy picks "name (1).jpg"
+	filename := filepath.Base(upload.ParseMetadata(up.Metadata)["filename"])
+	sanitized := filepath.Base(up.Path) != filename
+
+	if s.storage.Exists(up.Path) {
+		switch s.tusConflict(upload.ParseMetadata(up.Metadata)) {
+		case storage.ConflictFail:
+			// Created after the upload started
+			if err := s.uploads.Delete(up.ID); err != nil {
+				log.Printf("tus: failed to delete skipped upload %q: %v", up.ID, err)
+			}
+			http.Error(w, "A file with this name already exists", http.StatusConflict)
+			return false
+		case storage.ConflictRename:
+			free, err := s.storage.FreeName(up.Path)
+			if err != nil {
+				log.Printf("tus: failed to pick a free name for %q: %v", up.Path, err)
+				http.Error(w, "Failed to store upload", 500)
+				return false
+			}
+			up.Path = free
+		}
+	}
+
+	replaced := s.existingSize(up.Path)
+	if err := s.quotas.Check(up.Path, up.Username, up.Size-replaced); err != nil {
+		if errors.Is(err, quota.ErrExceeded) {
+			writeQuotaExceeded(w, err)
+			return false
+		}
+		log.Printf("tus: failed to check quota for %q: %v", up.Path, err)
+	}
+	if err := s.storage.ImportFile(up.Path, s.uploads.DataPath(up.ID)); err != nil {
+		log.Printf("tus: failed to store upload %q at %q: %v", up.ID, up.Path, err)
+		http.Error(w, "Failed to store upload", 500)
+		return false
+	}
+	s.quotas.Added(up.Path, up.Username, up.Size, replaced)
+	if sanitized {
+		s.names.Record(up.Path, filename)
+	}
+	s.thumbs.Enqueue(up.Path, s.cfg.ThumbMaxSizeAdmin)
+	if err := s.uploads.Delete(up.ID); err != nil {
+		log.Printf("tus: failed to delete finished upload %q: %v", up.ID, err)
+	}
+	return true
+}
+
+// tusConflict returns the conflict policy of an upload: its "conflict"
+// metadata, else UPLOAD_CONFLICT.
+func (s *Server) tusConflict(meta map[string]string) storage.Conflict {
+	if v := meta["conflict"]; v != "" {
+		return storage.ParseConflict(v)
+	}
+	return storage.ParseConflict(s.cfg.UploadConflict)
+}
+
+// existingSize returns the size of the file an upload to relPath would replace (0 if none).
+func (s *Server) existingSize(relPath string) int64 {
+	info, err := s.storage.Stat(relPath)
+	if err != nil || info.IsDir {
+		return 0
+	}
+	return info.Size
+}
+
+// tusUpload loads the upload named in the URL. Uploads of other users are
+// answered like unknown ones (404), so their IDs cannot be probed.
+func (s *Server) tusUpload(w http.ResponseWriter, r *http.Request) (*upload.Upload, bool) {
+	up, err := s.uploads.Get(r.PathValue("id"))
+	if err == nil && up.Username != auth.GetUsername(r) {
+		err = upload.ErrNotFound
+	}
+	if err != nil {
+		s.writeTusError(w, err)
+		return nil, false
+	}
+	return up, true
+}
+
+// checkTusVersion sets Tus-Resumable and rejects clients speaking another version (412).
+func (s *Server) checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", upload.TusVersion)
+	if r.Header.Get("Tus-Resumable") != upload.TusVersion {
+		w.Header().Set("Tus-Version", upload.TusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// writeTusError maps upload store errors to tus status codes.
+func (s *Server) writeTusError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		http.Error(w, "Upload not found", 404)
+	case errors.Is(err, upload.ErrOffsetMismatch):
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+	case errors.Is(err, upload.ErrLocked):
+		http.Error(w, "Upload is in use", http.StatusLocked)
+	case errors.Is(err, upload.ErrTooLong):
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, "Upload failed", 500)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"nas-dop/internal/auth"
+	"nas-dop/internal/quota"
+	"nas-dop/internal/storage"
+	"nas-dop/internal/versions"
+)
+
+// versionsMessages maps ?success= / ?error= codes to messages on the versions page.
+var versionsMessages = map[string]string{
+	"restored":     "Version restored. The file it replaced was kept as a version.",
+	"deleted":      "Version permanently deleted.",
+	"limitsaved":   "Version limit saved.",
+	"limitremoved": "Version limit removed.",
+	"notfound":     "Version not found.",
+	"quota":        "Cannot restore: the version does not fit the quota of its folder or user.",
+	"invalid":      "Enter a folder and a number of versions (0 or more).",
+}
+
+// versionsURL returns the versions page for a file ("" = overview) with a message code.
+func versionsURL(relPath, key, code string) string {
+	q := url.Values{}
+	if relPath != "" {
+		q.Set("path", "/"+strings.TrimPrefix(relPath, "/"))
+	}
+	if key != "" {
+		q.Set(key, code)
+	}
+	if len(q) == 0 {
+		return "/versions"
+	}
+	return "/versions?" + q.Encode()
+}
+
+// handleVersionsList shows the versions of one file (?path=), or the folder
+// limits and recently kept versions.
+func (s *Server) handleVersionsList(w http.ResponseWriter, r *http.Request) {
+	relPath := r.URL.Query().Get("path")
+	data := map[string]interface{}{
+		"Path":        relPath,
+		"DefaultKeep": s.versions.DefaultKeep(),
+		"Success":     versionsMessages[r.URL.Query().Get("success")],
+		"Error":       versionsMessages[r.URL.Query().Get("error")],
+	}
+
+	if relPath != "" {
+		list, err := s.versions.List(relPath)
+		if err != nil {
+			log.Printf("versions: failed to list versions of %q: %v", relPath, err)
+			http.Error(w, "Failed to list versions", 500)
+			return
+		}
+		data["Versions"] = list
+		data["Dir"] = path.Dir("/" + strings.TrimPrefix(relPath, "/"))
+		data["Keep"] = s.versions.Keep(relPath)
+		if info, err := s.storage.Stat(relPath); err == nil && !info.IsDir {
+			data["Current"] = info
+		}
+	} else {
+		recent, err := s.versions.Recent(100)
+		if err != nil {
+			log.Printf("versions: failed to list recent versions: %v", err)
+			http.Error(w, "Failed to list versions", 500)
+			return
+		}
+		limits, err := s.versions.Limits()
+		if err != nil {
+			log.Printf("versions: failed to list limits: %v", err)
+			http.Error(w, "Failed to list versions", 500)
+			return
+		}
+		data["Versions"] = recent
+		data["Limits"] = limits
+	}
+
+	s.render(w, "admin/versions", data)
+}
+
+// versionFromPath loads the version named by the {id} path segment, writing 404 if unknown.
+func (s *Server) versionFromPath(w http.ResponseWriter, r *http.Request) (*versions.Version, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", 400)
+		return nil, false
+	}
+	v, err := s.versions.Get(id)
+	if errors.Is(err, versions.ErrNotFound) {
+		http.Error(w, "Version not found", 404)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("versions: failed to get version %d: %v", id, err)
+		http.Error(w, "Failed to open version", 500)
+		return nil, false
+	}
+	return v, true
+}
+
+// handleVersionView displays a version in the browser where possible (images, PDFs).
+func (s *Server) handleVersionView(w http.ResponseWriter, r *http.Request) {
+	s.serveVersion(w, r, serveInline)
+}
+
+// handleVersionDownload downloads a version under the file's own name.
+func (s *Server) handleVersionDownload(w http.ResponseWriter, r *http.Request) {
+	s.serveVersion(w, r, serveFile)
+}
+
+func (s *Server) serveVersion(w http.ResponseWriter, r *http.Request, serve func(http.ResponseWriter, *http.Request, storage.File, string) bool) {
+	v, ok := s.versionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := s.storage.OpenVersion(v.Name)
+	if err != nil {
+		log.Printf("versions: failed to open version %q: %v", v.Name, err)
+		http.Error(w, "Version not found", 404)
+		return
+	}
+	defer f.Close()
+
+	serve(w, r, f, path.Base(v.Path))
+}
+
+// handleVersionRestore puts a version back in place of the current file.
+func (s *Server) handleVersionRestore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", 400)
+		return
+	}
+
+	v, err := s.versions.Get(id)
+	if errors.Is(err, versions.ErrNotFound) {
+		http.Redirect(w, r, versionsURL(r.FormValue("path"), "error", "notfound"), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("versions: failed to get version %d: %v", id, err)
+		http.Error(w, "Failed to restore", 500)
+		return
+	}
+
+	// Only growth over the current file counts against quotas
+	username := auth.GetUsername(r)
+	replaced := s.existingSize(v.Path)
+	if grow := v.Size - replaced; grow > 0 {
+		if err := s.quotas.Check(v.Path, username, grow); err != nil {
+			if errors.Is(err, quota.ErrExceeded) {
+				http.Redirect(w, r, versionsURL(v.Path, "error", "quota"), http.StatusSeeOther)
+				return
+			}
+			log.Printf("versions: failed to check quota for %q: %v", v.Path, err)
+		}
+	}
+
+	if _, err := s.versions.Restore(id); err != nil {
+		if errors.Is(err, versions.ErrNotFound) {
+			http.Redirect(w, r, versionsURL(v.Path, "error", "notfound"), http.StatusSeeOther)
+			return
+		}
+		log.Printf("versions: failed to restore version %d of %q: %v", id, v.Path, err)
+		http.Error(w, "Failed to restore", 500)
+		return
+	}
+
+	s.quotas.Added(v.Path, username, v.Size, replaced)
+
+	http.Redirect(w, r, versionsURL(v.Path, "success", "restored"), http.StatusSeeOther)
+}
+
+// handleVersionPurge permanently deletes one version.
+func (s *Server) handleVersionPurge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", 400)
+		return
+	}
+	back := r.FormValue("path")
+
+	if err := s.versions.Purge(id); err != nil {
+		if errors.Is(err, versions.ErrNotFound) {
+			http.Redirect(w, r, versionsURL(back, "error", "notfound"), http.StatusSeeOther)
+			return
+		}
+		log.Printf("versions: failed to purge version %d: %v", id, err)
+		http.Error(w, "Failed to delete", 500)
+		return
+	}
+
+	http.Redirect(w, r, versionsURL(back, "success", "deleted"), http.StatusSeeOther)
+}
+
+// handleVersionLimitSet sets how many versions are kept per file under a folder.
+func (s *Server) handleVersionLimitSet(w http.ResponseWriter, r *http.Request) {
+	folder := r.FormValue("folder")
+	keep, err := strconv.Atoi(strings.TrimSpace(r.FormValue("keep")))
+	if err != nil || keep < 0 || s.storage.Validate(folder) != nil {
+		http.Redirect(w, r, versionsURL("", "error", "invalid"), http.StatusSeeOther)
+		return
+	}
+
+	if err := s.versions.SetLimit(folder, keep); err != nil {
+		log.Printf("versions: failed to set limit for %q: %v", folder, err)
+		http.Error(w, "Failed to save limit", 500)
+		return
+	}
+
+	http.Redirect(w, r, versionsURL("", "success", "limitsaved"), http.StatusSeeOther)
+}
+
+// handleVersionLimitDelete removes a folder's version limit.
+func (s *Server) handleVersionLimitDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.versions.RemoveLimit(r.FormValue("folder")); err != nil {
+		log.Printf("versions: failed to remove limit for %q: %v", r.FormValue("folder"), err)
+		http.Error(w, "Failed to remove limit", 500)
+		return
+	}
+
+	http.Redirect(w, r, versionsURL("", "success", "limitremoved"), http.StatusSeeOther)
+}
@@ -1,14 +1,26 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"nas-dop/internal/quota"
+	"nas-dop/internal/storage"
 )
 
 // errRequestBodyTooLarge is the message Go's http.MaxBytesReader returns when limit is exceeded.
 const errRequestBodyTooLarge = "http: request body too large"
 
+// maxFormFieldBytes caps non-file multipart fields read by streaming handlers.
+const maxFormFieldBytes = 4 << 10
+
 // IsRequestEntityTooLarge reports whether err is from reading past MaxBytesReader limit.
 // Handlers that read the body (e.g. upload) should use this and respond 413 when true.
 func IsRequestEntityTooLarge(err error) bool {
@@ -37,3 +49,90 @@ func FormatBytes(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// parseBytes parses a size such as "500MB", "1.5 GB", "2T" or "1048576".
+// Units are binary (1 KB = 1024 bytes), matching FormatBytes.
+func parseBytes(v string) (int64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "IB"), "B")
+
+	multiplier := int64(1)
+	if n := len(v); n > 0 {
+		if i := strings.IndexByte("KMGTPE", v[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			v = strings.TrimSpace(v[:n-1])
+		}
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// quotaExceededMessage describes which quota blocked a write.
+func quotaExceededMessage(q *quota.Quota) string {
+	return fmt.Sprintf("Quota exceeded: %s %q has %s free of %s", q.Scope, q.Name, FormatBytes(q.Free()), FormatBytes(q.Limit))
+}
+
+// writeQuotaExceeded sends 507 Insufficient Storage naming the quota that was hit.
+func writeQuotaExceeded(w http.ResponseWriter, err error) {
+	var qe *quota.ExceededError
+	if errors.As(err, &qe) {
+		http.Error(w, quotaExceededMessage(qe.Quota), http.StatusInsufficientStorage)
+		return
+	}
+	http.Error(w, "Quota exceeded", http.StatusInsufficientStorage)
+}
+
+// serveFile streams f as a download via http.ServeContent, which handles Range,
+// If-Range, If-None-Match and If-Modified-Since. The ETag is derived from size
+// and mtime, and Content-Type is picked from the extension or sniffed.
+// Returns false (after writing 404) when f is a directory.
+func serveFile(w http.ResponseWriter, r *http.Request, f storage.File, filename string) bool {
+	return serveContent(w, r, f, filename, "attachment")
+}
+
+// serveInline is like serveFile but lets the browser display images and PDFs
+// instead of downloading them. Anything else (HTML in particular) is still
+// served as an attachment so it never runs in the app's origin.
+func serveInline(w http.ResponseWriter, r *http.Request, f storage.File, filename string) bool {
+	kind := "attachment"
+	ct := mime.TypeByExtension(path.Ext(filename))
+	if strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg") || ct == "application/pdf" {
+		kind = "inline"
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	return serveContent(w, r, f, filename, kind)
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, f storage.File, filename, kind string) bool {
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, "File not found", 404)
+		return false
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	w.Header().Set("Content-Disposition", contentDisposition(kind, filename))
+	http.ServeContent(w, r, filename, info.ModTime(), f)
+	return true
+}
+
+// contentDisposition builds a Content-Disposition value with an ASCII fallback
+// filename plus an RFC 5987 filename* parameter for non-ASCII names.
+func contentDisposition(kind, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= utf8.RuneSelf || r == '"' || r == '\\' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	if fallback == filename {
+		return fmt.Sprintf("%s; filename=%q", kind, filename)
+	}
+	return fmt.Sprintf("%s; filename=%q; filename*=UTF-8''%s", kind, fallback, url.PathEscape(filename))
+}
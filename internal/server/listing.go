@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"nas-dop/internal/storage"
+)
+
+// maxListLimit caps ?limit= on listing pages.
+const maxListLimit = 5000
+
+// listing is the sort, filter and paging state of a directory page, as shown
+// by the listing controls on /files and share pages.
+type listing struct {
+	Sort  string // name, size, mtime or taken
+	Desc  bool
+	Ext   string // Extension filter as typed ("jpg, png")
+	Q     string // Name filter
+	Total int    // Entries matching the filters
+	From  int    // 1-based position of the first entry shown
+	To    int    // Position of the last entry shown
+	Next  string // URL of the following page ("" = last page)
+	First string // URL of the first page ("" = already on it)
+}
+
+// listOptions reads ?sort=, ?order=, ?ext=, ?q=, ?after= and ?limit= into
+// ListOptions. Extensions may be given with or without the dot, separated by
+// commas or spaces.
+func (s *Server) listOptions(r *http.Request) storage.ListOptions {
+	q := r.URL.Query()
+	opts := storage.ListOptions{
+		Sort:     storage.ParseSortKey(q.Get("sort")),
+		Desc:     q.Get("order") == "desc",
+		Contains: strings.TrimSpace(q.Get("q")),
+		After:    q.Get("after"),
+		Limit:    s.cfg.ListPageSize,
+	}
+	for _, ext := range strings.FieldsFunc(q.Get("ext"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		opts.Exts = append(opts.Exts, "."+strings.ToLower(strings.TrimPrefix(ext, ".")))
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		opts.Limit = min(n, maxListLimit)
+	}
+	return opts
+}
+
+// listPage lists relPath with the request's listing options and describes the
+// page for the template.
+func (s *Server) listPage(r *http.Request, relPath string) (storage.ListPage, listing, error) {
+	opts := s.listOptions(r)
+	page, err := s.storage.ListPage(relPath, opts)
+	if err != nil {
+		return page, listing{}, err
+	}
+
+	l := listing{
+		Sort:  string(opts.Sort),
+		Desc:  opts.Desc,
+		Ext:   r.URL.Query().Get("ext"),
+		Q:     opts.Contains,
+		Total: page.Total,
+		From:  page.Offset + 1,
+		To:    page.Offset + len(page.Files),
+	}
+	if page.Next != "" {
+		l.Next = pageURL(r, page.Next)
+	}
+	if opts.After != "" {
+		l.First = pageURL(r, "")
+	}
+	return page, l, nil
+}
+
+// pageURL returns the request's URL with ?after= set to cursor (removed when
+// empty), keeping the other listing parameters.
+func pageURL(r *http.Request, cursor string) string {
+	u := *r.URL
+	q := u.Query()
+	if cursor == "" {
+		q.Del("after")
+	} else {
+		q.Set("after", cursor)
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// listQuery returns the request's sort and filter parameters as a query
+// string ("?sort=taken&order=desc", "" if none), for links that keep the
+// listing order but not the page.
+func listQuery(r *http.Request) string {
+	in := r.URL.Query()
+	out := url.Values{}
+	for _, key := range []string{"sort", "order", "q", "ext"} {
+		if v := in.Get(key); v != "" {
+			out.Set(key, v)
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return "?" + out.Encode()
+}
@@ -30,13 +30,45 @@ func (s *Server) routes() {
 	adminMux.HandleFunc("GET /files", s.handleFilesList)
 	adminMux.HandleFunc("GET /files/{path...}", s.handleFilesList)
 	adminMux.HandleFunc("POST /files/upload", s.handleUpload)
+	adminMux.HandleFunc("OPTIONS /files/tus", s.handleTusOptions)
+	adminMux.HandleFunc("POST /files/tus", s.handleTusCreate)
+	adminMux.HandleFunc("HEAD /files/tus/{id}", s.handleTusHead)
+	adminMux.HandleFunc("PATCH /files/tus/{id}", s.handleTusPatch)
+	adminMux.HandleFunc("DELETE /files/tus/{id}", s.handleTusDelete)
 	adminMux.HandleFunc("POST /files/mkdir", s.handleMkdir)
 	adminMux.HandleFunc("POST /files/delete", s.handleDelete)
 	adminMux.HandleFunc("POST /files/rename", s.handleRename)
+	adminMux.HandleFunc("POST /files/move", s.handleMove)
+	adminMux.HandleFunc("POST /files/copy", s.handleCopy)
 	adminMux.HandleFunc("GET /files/download/{path...}", s.handleDownload)
+	adminMux.HandleFunc("GET /trash", s.handleTrashList)
+	adminMux.HandleFunc("POST /trash/restore", s.handleTrashRestore)
+	adminMux.HandleFunc("POST /trash/delete", s.handleTrashPurge)
+	adminMux.HandleFunc("POST /trash/empty", s.handleTrashEmpty)
+	adminMux.HandleFunc("GET /versions", s.handleVersionsList)
+	adminMux.HandleFunc("GET /versions/{id}/view", s.handleVersionView)
+	adminMux.HandleFunc("GET /versions/{id}/download", s.handleVersionDownload)
+	adminMux.HandleFunc("POST /versions/restore", s.handleVersionRestore)
+	adminMux.HandleFunc("POST /versions/delete", s.handleVersionPurge)
+	adminMux.HandleFunc("POST /versions/limits", s.handleVersionLimitSet)
+	adminMux.HandleFunc("POST /versions/limits/delete", s.handleVersionLimitDelete)
+	adminMux.HandleFunc("GET /search", s.handleSearch)
+	adminMux.HandleFunc("POST /search/rescan", s.handleSearchRescan)
+	adminMux.HandleFunc("GET /api/search", s.handleSearchAPI)
+	adminMux.HandleFunc("GET /duplicates", s.handleDuplicates)
+	adminMux.HandleFunc("POST /duplicates/link", s.handleDuplicatesLink)
+	adminMux.HandleFunc("POST /duplicates/link-all", s.handleDuplicatesLinkAll)
+	adminMux.HandleFunc("GET /quotas", s.handleQuotasList)
+	adminMux.HandleFunc("POST /quotas", s.handleQuotaSet)
+	adminMux.HandleFunc("POST /quotas/delete", s.handleQuotaDelete)
+	adminMux.HandleFunc("POST /quotas/recalculate", s.handleQuotaRecalculate)
 	adminMux.HandleFunc("GET /shares", s.handleSharesList)
 	adminMux.HandleFunc("POST /shares/delete", s.handleShareDelete)
+	adminMux.HandleFunc("GET /shares/watermark", s.handleShareWatermarkForm)
+	adminMux.HandleFunc("POST /shares/watermark", s.handleShareWatermarkSave)
 	adminMux.HandleFunc("GET /files/thumb/{path...}", s.handleFilesThumb)
+	adminMux.HandleFunc("GET /thumbnails", s.handleThumbnails)
+	adminMux.HandleFunc("POST /thumbnails/sweep", s.handleThumbnailsSweep)
 
 	s.mux.Handle("/", auth.RequireAuth(s.sessionStore, adminMux))
 
@@ -50,4 +82,6 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("GET /share/{token}/dl/{path...}", s.handleShareDownload)
 	s.mux.HandleFunc("POST /share/{token}/zip", s.handleShareZip)
 	s.mux.HandleFunc("GET /share/{token}/thumb/{path...}", s.handleShareThumb)
+	s.mux.HandleFunc("GET /share/{token}/preview/{path...}", s.handleSharePreview)
+	s.mux.HandleFunc("GET /share/{token}/view/{path...}", s.handleShareView)
 }
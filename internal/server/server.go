@@ -5,13 +5,24 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"nas-dop/internal/auth"
 	"nas-dop/internal/config"
 	"nas-dop/internal/db"
+	"nas-dop/internal/index"
+	"nas-dop/internal/names"
+	"nas-dop/internal/quota"
 	"nas-dop/internal/share"
 	"nas-dop/internal/storage"
+	"nas-dop/internal/thumbs"
+	"nas-dop/internal/trash"
+	"nas-dop/internal/upload"
+	"nas-dop/internal/versions"
+	"nas-dop/internal/watcher"
 	"nas-dop/web"
 )
 
@@ -23,7 +34,16 @@ type Server struct {
 	sessionStore *auth.SessionStore
 	storage      *storage.Storage
 	shareStore   *share.Store
+	uploads      *upload.Store
+	trash        *trash.Store
+	versions     *versions.Store
+	names        *names.Store
+	quotas       *quota.Store
+	index        *index.Store
+	thumbs       *thumbs.Pool
 	templates    *template.Template
+
+	tusFinish sync.Mutex // Serializes quota check and move of finished tus uploads
 }
 
 // New builds a new Server with all dependencies wired.
@@ -36,19 +56,165 @@ func New(cfg *config.Config, database *db.DB) (*Server, error) {
 		return nil, fmt.Errorf("parse templates: %w", err)
 	}
 
+	st, err := newStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	st.SetMaxNameBytes(cfg.MaxNameBytes)
+	st.SetHidePolicy(storage.ParseHidePolicy(cfg.HiddenFiles))
+	st.SetThumbCache(storage.NewThumbCache(cfg.ThumbCacheDir, cfg.ThumbCacheMaxBytes))
+	st.SetThumbConcurrency(cfg.ThumbConcurrency)
+	st.SetDecodeLimits(cfg.ThumbMaxPixels, cfg.ThumbDecodeMaxBytes)
+
 	s := &Server{
 		cfg:          cfg,
 		mux:          http.NewServeMux(),
 		db:           database,
 		sessionStore: auth.NewSessionStore(),
-		storage:      storage.New(cfg.Root, cfg.PUID, cfg.PGID),
+		storage:      st,
 		shareStore:   share.NewStore(database.DB()),
+		uploads:      upload.NewStore(database.DB(), cfg.UploadTmpDir, cfg.UploadExpiry),
+		trash:        trash.NewStore(database.DB(), st, cfg.TrashRetention),
+		versions:     versions.NewStore(database.DB(), st, cfg.VersionsKeep),
+		names:        names.NewStore(database.DB(), st),
+		quotas:       quota.NewStore(database.DB(), st),
+		index:        index.NewStore(database.DB(), st),
+		thumbs:       thumbs.New(st, cfg.ThumbConcurrency),
 		templates:    tmpl,
 	}
+	// Keep caches and shares in step with changes, including ones made outside the app
+	st.Subscribe(s.onStorageChange)
+	if cfg.Watch != "off" {
+		w := watcher.New(st, cfg.WatchRescanInterval, filepath.Dir(cfg.DBPath), cfg.UploadTmpDir, cfg.TrashDir, cfg.VersionsDir, cfg.ThumbCacheDir)
+		w.Start(cfg.Watch == "auto")
+	}
+	go s.thumbSweepLoop()
+
 	s.routes()
 	return s, nil
 }
 
+// onStorageChange invalidates cached thumbnails and moves share paths when the
+// tree changes. It runs on the goroutine that made or observed the change.
+func (s *Server) onStorageChange(c storage.Change) {
+	switch c.Op {
+	case storage.ChangeWrite, storage.ChangeRemove:
+		if err := s.storage.InvalidateThumbnails(c.Path); err != nil {
+			log.Printf("thumbnail: failed to invalidate %q: %v", c.Path, err)
+		}
+	case storage.ChangeRename:
+		if err := s.storage.InvalidateThumbnails(c.OldPath); err != nil {
+			log.Printf("thumbnail: failed to invalidate %q: %v", c.OldPath, err)
+		}
+		if err := s.shareStore.Moved(c.OldPath, c.Path); err != nil {
+			log.Printf("share: failed to follow %q to %q: %v", c.OldPath, c.Path, err)
+		}
+		s.versions.Moved(c.OldPath, c.Path)
+	}
+}
+
+// newStorage builds Storage on the backend selected by STORAGE_BACKEND.
+// The recycle bin and file versions live beside the tree on the same backend so
+// deletes and overwrites stay cheap.
+func newStorage(cfg *config.Config) (*storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "local", "":
+		local := storage.NewLocalBackend(cfg.Root, cfg.PUID, cfg.PGID)
+		local.SetSymlinkPolicy(storage.ParseSymlinkPolicy(cfg.SymlinkPolicy))
+		st := storage.NewWithBackend(local)
+		st.SetTrash(storage.NewLocalBackend(cfg.TrashDir, cfg.PUID, cfg.PGID))
+		st.SetVersions(storage.NewLocalBackend(cfg.VersionsDir, cfg.PUID, cfg.PGID))
+		if err := reserveAppData(st, cfg); err != nil {
+			return nil, err
+		}
+		return st, nil
+
+	case "s3":
+		s3, err := storage.NewS3Backend(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		st := storage.NewWithBackend(s3)
+		p := strings.Trim(cfg.S3Prefix, "/")
+		st.SetTrash(s3.WithPrefix(p + ".trash"))
+		st.SetVersions(s3.WithPrefix(p + ".versions"))
+		if p == "" {
+			// Without S3_PREFIX the tree is the whole bucket, so the areas
+			// beside it (".trash/", ".versions/") are inside it
+			for _, area := range []string{".trash", ".versions"} {
+				if err := st.Reserve(area); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return st, nil
+
+	case "memory":
+		st := storage.NewWithBackend(storage.NewMemoryBackend())
+		st.SetTrash(storage.NewMemoryBackend())
+		st.SetVersions(storage.NewMemoryBackend())
+		return st, nil
+	}
+	return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (want local, s3 or memory)", cfg.StorageBackend)
+}
+
+// reserveAppData keeps the app's own files out of the tree when they live
+// under ROOT, as they do with the default DB_PATH (/data/db under ROOT=/data),
+// so they are never listed, shared, indexed or counted against quotas. A
+// data directory that is ROOT itself cannot be hidden and refuses to start.
+func reserveAppData(st *storage.Storage, cfg *config.Config) error {
+	root := realPath(cfg.Root)
+	data := []struct{ env, path string }{
+		{"DB_PATH", cfg.DBPath},
+		{"DB_PATH", cfg.DBPath + "-wal"},
+		{"DB_PATH", cfg.DBPath + "-shm"},
+		{"DB_PATH", cfg.DBPath + "-journal"},
+		{"TRASH_DIR", cfg.TrashDir},
+		{"UPLOAD_TMP_DIR", cfg.UploadTmpDir},
+		{"VERSIONS_DIR", cfg.VersionsDir},
+		{"THUMB_CACHE_DIR", cfg.ThumbCacheDir},
+	}
+	for _, d := range data {
+		if d.path == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, realPath(d.path))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue // Outside ROOT
+		}
+		if rel == "." {
+			return fmt.Errorf("%s must not be ROOT", d.env)
+		}
+		if err := st.Reserve(filepath.ToSlash(rel)); err != nil {
+			return fmt.Errorf("%s: %w", d.env, err)
+		}
+	}
+	return nil
+}
+
+// realPath makes p absolute and resolves symlinks, in its parent directory
+// when p itself does not exist (yet).
+func realPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(dir, filepath.Base(abs))
+	}
+	return abs
+}
+
 // Listen starts the HTTP server on addr (e.g. ":8080").
 // Uses ReadHeaderTimeout, ReadTimeout, WriteTimeout from config (optimization-recommendations.md).
 func (s *Server) Listen(addr string) error {
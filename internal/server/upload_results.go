@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Upload result statuses, per file.
+const (
+	uploadSaved   = "saved"   // Stored under its own name (possibly replacing a file)
+	uploadRenamed = "renamed" // Name was taken; stored as "name (N).ext"
+	uploadSkipped = "skipped" // Name was taken and the policy is skip
+	uploadFailed  = "failed"  // Not stored; Reason says why
+)
+
+// requestTooLargeReason is the failure reason once the request body itself is
+// over MAX_REQUEST_BYTES; the rest of the upload cannot be read.
+const requestTooLargeReason = "the upload is larger than the server accepts at once"
+
+// uploadResult reports what happened to one file of an upload.
+type uploadResult struct {
+	Name   string `json:"name"`           // File name as sent
+	Path   string `json:"path,omitempty"` // Where it was stored, with leading slash
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	code   int    // HTTP status describing a failure
+}
+
+// partFilename returns the file name a part was sent with, including any
+// directories. multipart.Part.FileName strips those, but folder uploads
+// (webkitdirectory) need them.
+func partFilename(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// splitUploadName splits an uploaded file name into its relative directory
+// ("" for a plain file) and base name. Backslashes count as separators, empty
+// and "." components are dropped, and ".." anywhere makes it invalid.
+func splitUploadName(name string) (dir, base string, ok bool) {
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", "", false
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", "", false
+	}
+	return strings.Join(parts[:len(parts)-1], "/"), parts[len(parts)-1], true
+}
+
+// uploadSummary counts upload results and lists everything that was not
+// simply saved. It is what the files page shows after an HTML upload.
+type uploadSummary struct {
+	Saved   int      `json:"saved"`
+	Renamed int      `json:"renamed"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Notes   []string `json:"notes,omitempty"`
+}
+
+// summarizeUploads builds the summary for a list of results.
+func summarizeUploads(results []uploadResult) *uploadSummary {
+	sum := &uploadSummary{}
+	for _, res := range results {
+		switch res.Status {
+		case uploadSaved:
+			sum.Saved++
+			if _, base, _ := splitUploadName(res.Name); base != path.Base(res.Path) {
+				sum.Notes = append(sum.Notes, fmt.Sprintf("%s was saved as %s", res.Name, res.Path))
+			}
+		case uploadRenamed:
+			sum.Renamed++
+			sum.Notes = append(sum.Notes, fmt.Sprintf("%s was saved as %s", res.Name, res.Path))
+		case uploadSkipped:
+			sum.Skipped++
+			sum.Notes = append(sum.Notes, fmt.Sprintf("%s skipped: %s", res.Name, res.Reason))
+		case uploadFailed:
+			sum.Failed++
+			sum.Notes = append(sum.Notes, fmt.Sprintf("%s failed: %s", res.Name, res.Reason))
+		}
+	}
+	return sum
+}
+
+// writeUploadResults answers an upload: JSON for scripts and XHR clients
+// (Accept: application/json or X-Requested-With: XMLHttpRequest), otherwise a
+// flash summary cookie and a redirect back to the folder.
+// JSON responses are 200 unless every file failed, in which case the status
+// of the last failure is used (413, 507, ...).
+func writeUploadResults(w http.ResponseWriter, r *http.Request, dir string, results []uploadResult) {
+	sum := summarizeUploads(results)
+
+	if wantsJSON(r) {
+		status := http.StatusOK
+		if sum.Failed > 0 && sum.Failed == len(results) {
+			status = results[len(results)-1].code
+		}
+		if results == nil {
+			results = []uploadResult{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":    "/" + strings.TrimPrefix(dir, "/"),
+			"saved":   sum.Saved,
+			"renamed": sum.Renamed,
+			"skipped": sum.Skipped,
+			"failed":  sum.Failed,
+			"results": results,
+		})
+		return
+	}
+
+	setUploadFlash(w, sum)
+	http.Redirect(w, r, "/files/"+strings.TrimPrefix(dir, "/"), http.StatusSeeOther)
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+const (
+	uploadFlashCookie = "upload_result"
+	maxFlashBytes     = 3000 // Keeps the cookie well under the 4KB browser limit
+)
+
+// setUploadFlash stores sum in a short-lived cookie for the next files page.
+// Notes that do not fit are replaced by a count.
+func setUploadFlash(w http.ResponseWriter, sum *uploadSummary) {
+	all := sum.Notes
+	notes := all
+	var value string
+	for {
+		sum.Notes = notes
+		if dropped := len(all) - len(notes); dropped > 0 {
+			sum.Notes = append(notes[:len(notes):len(notes)], fmt.Sprintf("… and %d more", dropped))
+		}
+		data, _ := json.Marshal(sum)
+		value = base64.RawURLEncoding.EncodeToString(data)
+		if len(value) <= maxFlashBytes || len(notes) == 0 {
+			break
+		}
+		notes = notes[:len(notes)-1]
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     uploadFlashCookie,
+		Value:    value,
+		Path:     "/files",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   60,
+	})
+}
+
+// takeUploadFlash returns the summary left by the last upload, if any, and clears it.
+func takeUploadFlash(w http.ResponseWriter, r *http.Request) *uploadSummary {
+	cookie, err := r.Cookie(uploadFlashCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   uploadFlashCookie,
+		Path:   "/files",
+		MaxAge: -1,
+	})
+
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	var sum uploadSummary
+	if json.Unmarshal(data, &sum) != nil {
+		return nil
+	}
+	return &sum
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"nas-dop/internal/watermark"
 )
 
 // Share represents a shared file or directory.
@@ -17,6 +19,7 @@ type Share struct {
 	ExpiresAt    *time.Time
 	Name         string
 	CreatedAt    time.Time
+	Watermark    *watermark.Watermark // Stamped on the share's images (nil = served as stored)
 }
 
 // GenerateToken generates a secure random token for a share (16 bytes, base64 URL-safe).
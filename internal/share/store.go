@@ -3,9 +3,12 @@ package share
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"nas-dop/internal/watermark"
 )
 
 // Store manages share persistence in SQLite.
@@ -54,41 +57,103 @@ func (s *Store) Create(path, name, password string, expiresAt *time.Time) (*Shar
 	}, nil
 }
 
-// GetByToken retrieves a share by its token.
-func (s *Store) GetByToken(token string) (*Share, error) {
+// Moved rewrites share paths after a file or folder was renamed or moved, so
+// shares of it (or of anything inside it) follow. Deleted items keep their
+// shares so restoring from the trash brings them back.
+func (s *Store) Moved(oldPath, newPath string) error {
+	oldPath = strings.Trim(oldPath, "/")
+	newPath = strings.Trim(newPath, "/")
+
+	// Paths beneath oldPath sort between "oldPath/" and "oldPath0" ('0' follows
+	// '/'); no length arithmetic, as SQLite counts characters, not bytes.
+	_, err := s.db.Exec(
+		`UPDATE shares SET path = '/' || ? || substr(ltrim(path, '/'), length(?)+1)
+		 WHERE ltrim(path, '/') = ? OR (ltrim(path, '/') >= ? || '/' AND ltrim(path, '/') < ? || '0')`,
+		newPath, oldPath, oldPath, oldPath, oldPath,
+	)
+	if err != nil {
+		return fmt.Errorf("update share paths: %w", err)
+	}
+	return nil
+}
+
+// shareColumns selects a share with its watermark, in the order scanShare reads.
+const shareColumns = `s.id, s.token, s.path, s.password_hash, s.expires_at, s.name, s.created_at,
+	w.share_id, w.text, w.image, w.position, w.opacity, w.scale
+	FROM shares s LEFT JOIN share_watermarks w ON w.share_id = s.id`
+
+// scanShare reads a row of shareColumns.
+func scanShare(scan func(dest ...interface{}) error) (*Share, error) {
 	var share Share
 	var expiresAt sql.NullTime
+	var wmShare sql.NullInt64
+	var wm watermark.Watermark
+	var wmText, wmPosition sql.NullString
+	var wmOpacity, wmScale sql.NullInt64
 
-	err := s.db.QueryRow(
-		"SELECT id, token, path, password_hash, expires_at, name, created_at FROM shares WHERE token = ?",
-		token,
-	).Scan(&share.ID, &share.Token, &share.Path, &share.PasswordHash, &expiresAt, &share.Name, &share.CreatedAt)
+	err := scan(&share.ID, &share.Token, &share.Path, &share.PasswordHash, &expiresAt, &share.Name, &share.CreatedAt,
+		&wmShare, &wmText, &wm.Image, &wmPosition, &wmOpacity, &wmScale)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Time
+	}
+	if wmShare.Valid {
+		wm.Text, wm.Position = wmText.String, wmPosition.String
+		wm.Opacity, wm.Scale = int(wmOpacity.Int64), int(wmScale.Int64)
+		share.Watermark = &wm
+	}
+	return &share, nil
+}
 
+// GetByToken retrieves a share by its token.
+func (s *Store) GetByToken(token string) (*Share, error) {
+	share, err := scanShare(s.db.QueryRow("SELECT "+shareColumns+" WHERE s.token = ?", token).Scan)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("share not found")
 	}
 	if err != nil {
 		return nil, fmt.Errorf("query share: %w", err)
 	}
+	return share, nil
+}
 
-	if expiresAt.Valid {
-		share.ExpiresAt = &expiresAt.Time
+// SetWatermark sets the watermark stamped on a share's images, or removes it
+// when wm is nil.
+func (s *Store) SetWatermark(shareID int, wm *watermark.Watermark) error {
+	if wm == nil {
+		if _, err := s.db.Exec("DELETE FROM share_watermarks WHERE share_id = ?", shareID); err != nil {
+			return fmt.Errorf("delete watermark: %w", err)
+		}
+		return nil
 	}
 
-	return &share, nil
+	_, err := s.db.Exec(
+		`INSERT INTO share_watermarks (share_id, text, image, position, opacity, scale) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(share_id) DO UPDATE SET text = excluded.text, image = excluded.image,
+		   position = excluded.position, opacity = excluded.opacity, scale = excluded.scale`,
+		shareID, wm.Text, wm.Image, wm.Position, wm.Opacity, wm.Scale,
+	)
+	if err != nil {
+		return fmt.Errorf("save watermark: %w", err)
+	}
+	return nil
 }
 
-// Delete removes a share by its token.
+// Delete removes a share by its token, with its watermark.
 func (s *Store) Delete(token string) error {
+	if _, err := s.db.Exec("DELETE FROM share_watermarks WHERE share_id IN (SELECT id FROM shares WHERE token = ?)", token); err != nil {
+		return err
+	}
 	_, err := s.db.Exec("DELETE FROM shares WHERE token = ?", token)
 	return err
 }
 
 // List returns all shares (for admin UI).
 func (s *Store) List() ([]*Share, error) {
-	rows, err := s.db.Query(
-		"SELECT id, token, path, password_hash, expires_at, name, created_at FROM shares ORDER BY created_at DESC",
-	)
+	rows, err := s.db.Query("SELECT " + shareColumns + " ORDER BY s.created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -96,18 +161,11 @@ func (s *Store) List() ([]*Share, error) {
 
 	var shares []*Share
 	for rows.Next() {
-		var share Share
-		var expiresAt sql.NullTime
-
-		if err := rows.Scan(&share.ID, &share.Token, &share.Path, &share.PasswordHash, &expiresAt, &share.Name, &share.CreatedAt); err != nil {
+		share, err := scanShare(rows.Scan)
+		if err != nil {
 			continue
 		}
-
-		if expiresAt.Valid {
-			share.ExpiresAt = &expiresAt.Time
-		}
-
-		shares = append(shares, &share)
+		shares = append(shares, share)
 	}
 
 	return shares, nil
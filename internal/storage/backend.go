@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Backend is a storage driver. Storage validates and cleans every path before
+// calling it, so drivers receive slash-separated paths relative to their root
+// with no leading slash ("" is the root itself) and never see "..".
+//
+// Drivers: LocalBackend (filesystem under ROOT), S3Backend (S3-compatible
+// bucket such as MinIO) and MemoryBackend (in-memory, for tests and demos).
+type Backend interface {
+	// List returns the entries of a directory.
+	List(path string) ([]FileInfo, error)
+	// Open opens a file for streaming, seekable reads.
+	Open(path string) (File, error)
+	// Create starts writing a file, creating parent directories as needed.
+	// Data replaces path only when the Writer is closed successfully.
+	Create(path string) (Writer, error)
+	// Stat returns metadata for a file or directory; fs.ErrNotExist if missing.
+	Stat(path string) (*FileInfo, error)
+	// Delete removes a file or a directory and everything beneath it.
+	Delete(path string) error
+	// Rename moves a file or directory to newPath (parents are created).
+	Rename(oldPath, newPath string) error
+	// Mkdir creates a directory and any missing parents.
+	Mkdir(path string) error
+}
+
+// File is an open file returned by Backend.Open. *os.File satisfies it.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// Writer is returned by Backend.Create. Close commits the data; Abort
+// discards it and must be safe to call after a failed write.
+type Writer interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// importer is implemented by backends that can take over a local file cheaply
+// (LocalBackend renames it into place).
+type importer interface {
+	Import(path, srcPath string) error
+}
+
+// copier is implemented by backends with a native copy (tree copy on disk,
+// server-side CopyObject on S3).
+type copier interface {
+	Copy(srcPath, dstPath string) error
+}
+
+// realPather is implemented by backends where paths can alias each other
+// through symlinks; RealPath returns the fully resolved location.
+type realPather interface {
+	RealPath(path string) (string, error)
+}
+
+// linker is implemented by backends that can share one copy of identical
+// files between several paths (LocalBackend, via hardlinks).
+type linker interface {
+	// Link replaces path with a hardlink to target, atomically.
+	Link(target, path string) error
+	// SameFile reports whether both paths are already the same file on disk.
+	SameFile(a, b string) (bool, error)
+}
+
+// errIsDir is returned by Open for directories.
+var errIsDir = errors.New("is a directory")
+
+// fileStat adapts FileInfo to fs.FileInfo for non-local File implementations.
+type fileStat struct {
+	info FileInfo
+}
+
+func (f fileStat) Name() string       { return f.info.Name }
+func (f fileStat) Size() int64        { return f.info.Size }
+func (f fileStat) ModTime() time.Time { return f.info.ModTime }
+func (f fileStat) IsDir() bool        { return f.info.IsDir }
+func (f fileStat) Sys() interface{}   { return nil }
+
+func (f fileStat) Mode() fs.FileMode {
+	if f.info.IsDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// newFileInfo builds a FileInfo for a slash-separated backend path.
+func newFileInfo(p string, size int64, modTime time.Time, isDir bool) FileInfo {
+	name := path.Base(p)
+	if p == "" {
+		name = "/"
+	}
+	info := FileInfo{
+		Name:    name,
+		Size:    size,
+		ModTime: modTime,
+		IsDir:   isDir,
+	}
+	if !isDir {
+		info.Ext = strings.ToLower(path.Ext(name))
+	}
+	return info
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+// moveAcross renames src to dst, falling back to copy + remove when the two
+// paths are on different filesystems (EXDEV).
+func (b *LocalBackend) moveAcross(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	_, existed := os.Lstat(dst)
+	if err := b.copyTree(src, dst); err != nil {
+		if existed != nil {
+			os.RemoveAll(dst) // Only what the copy created
+		}
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies a file or directory recursively, preserving mtimes and
+// applying PUID/PGID to everything it creates.
+func (b *LocalBackend) copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return nil
+		default:
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		}
+
+		_ = chown(target, b.puid, b.pgid)
+		_ = os.Chtimes(target, info.ModTime(), info.ModTime())
+		return nil
+	})
+}
+
+// copyFile copies a regular file's contents to dst and fsyncs it. Like
+// Create it writes a temp file beside dst and renames it into place, so an
+// existing dst is replaced whole (never truncated, which would also change
+// the versions hardlinked to it) and stays intact if the copy fails.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".upload-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Chmod(0644); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// copyBetween copies a file or directory tree through the Backend interface,
+// which works between any two drivers (or within one lacking a native copy).
+func copyBetween(src Backend, srcPath string, dst Backend, dstPath string) error {
+	info, err := src.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir {
+		if err := dst.Mkdir(dstPath); err != nil {
+			return err
+		}
+		entries, err := src.List(srcPath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyBetween(src, path.Join(srcPath, entry.Name), dst, path.Join(dstPath, entry.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	in, err := src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := dst.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Close()
+}
+
+// moveBetween moves a file or tree from one backend to another. Two local
+// backends use a rename (copy + delete across filesystems); anything else is
+// copied through the Backend interface and then deleted from the source.
+func moveBetween(src Backend, srcPath string, dst Backend, dstPath string) error {
+	if srcLocal, ok := src.(*LocalBackend); ok {
+		if dstLocal, ok := dst.(*LocalBackend); ok {
+			srcAbsPath, err := srcLocal.abs(srcPath)
+			if err != nil {
+				return err
+			}
+			dstAbsPath, err := dstLocal.abs(dstPath)
+			if err != nil {
+				return err
+			}
+			if err := dstLocal.mkdirAll(filepath.Dir(dstAbsPath)); err != nil {
+				return err
+			}
+			return dstLocal.moveAcross(srcAbsPath, dstAbsPath)
+		}
+	}
+
+	if src == dst {
+		return src.Rename(srcPath, dstPath)
+	}
+	if err := copyBetween(src, srcPath, dst, dstPath); err != nil {
+		dst.Delete(dstPath)
+		return err
+	}
+	return src.Delete(srcPath)
+}
+
+// keepBetween puts a copy of the file at srcPath into dst, leaving the source
+// in place. Two local backends share the data through a hardlink when they
+// are on one filesystem; anything else is copied.
+func keepBetween(src Backend, srcPath string, dst Backend, dstPath string) error {
+	if srcLocal, ok := src.(*LocalBackend); ok {
+		if dstLocal, ok := dst.(*LocalBackend); ok {
+			srcAbsPath, err := srcLocal.abs(srcPath)
+			if err != nil {
+				return err
+			}
+			dstAbsPath, err := dstLocal.abs(dstPath)
+			if err != nil {
+				return err
+			}
+			if err := dstLocal.mkdirAll(filepath.Dir(dstAbsPath)); err != nil {
+				return err
+			}
+			if os.Link(srcAbsPath, dstAbsPath) == nil {
+				return nil
+			}
+			if err := dstLocal.copyTree(srcAbsPath, dstAbsPath); err != nil {
+				os.Remove(dstAbsPath)
+				return err
+			}
+			return nil
+		}
+	}
+
+	if err := copyBetween(src, srcPath, dst, dstPath); err != nil {
+		dst.Delete(dstPath)
+		return err
+	}
+	return nil
+}
+
+// treeSize returns the total size of files under path (the file size for a file).
+func treeSize(b Backend, p string) int64 {
+	info, err := b.Stat(p)
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir {
+		return info.Size
+	}
+
+	var total int64
+	entries, err := b.List(p)
+	if err != nil {
+		return 0
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			total += treeSize(b, path.Join(p, entry.Name))
+		} else {
+			total += entry.Size
+		}
+	}
+	return total
+}
@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"sync"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+var (
+	// ErrUnknownFormat is returned when a file's content is not an image format
+	// thumbnails can be made from, whatever its extension says.
+	ErrUnknownFormat = errors.New("unsupported image format")
+
+	// ErrImageTooLarge is returned for images over the pixel limit, or whose
+	// decode would need more memory than the whole decode budget.
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// Image formats recognized by sniffFormat.
+const (
+	formatJPEG = "jpeg"
+	formatPNG  = "png"
+	formatGIF  = "gif"
+	formatWebP = "webp"
+	formatBMP  = "bmp"
+	formatTIFF = "tiff"
+)
+
+// sniffLen is how many leading bytes sniffFormat needs, enough to also see
+// the WebP VP8X flags.
+const sniffLen = 21
+
+// sniffFormat names the image format of a file from its leading bytes, or
+// returns "" when it is none of the supported ones.
+func sniffFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return formatJPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return formatPNG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return formatGIF
+	case len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "WEBP":
+		return formatWebP
+	case bytes.HasPrefix(head, []byte("BM")):
+		return formatBMP
+	case isTIFFHeader(head):
+		return formatTIFF
+	}
+	return ""
+}
+
+// decodeImage decodes an image, picking the decoder from the content rather
+// than the file name. Animated GIF and WebP give their first frame.
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(sniffLen)
+	format := sniffFormat(head)
+
+	var img image.Image
+	var err error
+	switch format {
+	case formatJPEG:
+		img, err = jpeg.Decode(br)
+	case formatPNG:
+		img, err = png.Decode(br)
+	case formatGIF:
+		img, err = gif.Decode(br) // First frame only
+	case formatWebP:
+		img, err = decodeWebP(br, head)
+	case formatBMP:
+		img, err = bmp.Decode(br)
+	case formatTIFF:
+		img, err = tiff.Decode(br)
+	default:
+		return nil, "", ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, format, fmt.Errorf("decode %s: %w", format, err)
+	}
+	return img, format, nil
+}
+
+// decodeConfig reads an image's format, dimensions and color model from its
+// header, without decoding the pixels.
+func decodeConfig(r io.Reader) (image.Config, string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(sniffLen)
+	format := sniffFormat(head)
+
+	var cfg image.Config
+	var err error
+	switch format {
+	case formatJPEG:
+		cfg, err = jpeg.DecodeConfig(br)
+	case formatPNG:
+		cfg, err = png.DecodeConfig(br)
+	case formatGIF:
+		cfg, err = gif.DecodeConfig(br)
+	case formatWebP:
+		cfg, err = webp.DecodeConfig(br)
+	case formatBMP:
+		cfg, err = bmp.DecodeConfig(br)
+	case formatTIFF:
+		cfg, err = tiff.DecodeConfig(br)
+	default:
+		return cfg, "", ErrUnknownFormat
+	}
+	if err != nil {
+		return cfg, format, fmt.Errorf("decode %s header: %w", format, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return cfg, format, fmt.Errorf("decode %s header: image has no pixels", format)
+	}
+	return cfg, format, nil
+}
+
+// SetDecodeLimits bounds image decoding for thumbnails: images over maxPixels
+// are refused before decoding, and decodes running at once hold at most
+// maxBytes between them, waiting for room otherwise (0 = no limit).
+func (s *Storage) SetDecodeLimits(maxPixels, maxBytes int64) {
+	s.maxPixels = maxPixels
+	if maxBytes > 0 {
+		s.decodeBudget = newMemoryBudget(maxBytes)
+	}
+}
+
+// decodeBounded decodes an image for a thumbnail of maxSize (0 = full size)
+// within the decode limits: the header is checked against the pixel limit,
+// the decode's memory is reserved from the budget, and large baseline JPEGs
+// are decoded at reduced size. Call release once done with the image.
+func (s *Storage) decodeBounded(r io.ReadSeeker, maxSize int) (img image.Image, release func(), err error) {
+	cfg, format, err := decodeConfig(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if px := int64(cfg.Width) * int64(cfg.Height); s.maxPixels > 0 && px > s.maxPixels {
+		return nil, nil, fmt.Errorf("%w: %dx%d is %.1f megapixels, over the %.1f megapixel limit",
+			ErrImageTooLarge, cfg.Width, cfg.Height, float64(px)/1e6, float64(s.maxPixels)/1e6)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	thumb := 2 * 4 * int64(maxSize) * int64(maxSize) // Resized RGBA, and its upright copy
+	if maxSize <= 0 {
+		maxSize = max(cfg.Width, cfg.Height)
+		thumb = 2 * 4 * int64(cfg.Width) * int64(cfg.Height)
+	}
+
+	if format == formatJPEG {
+		if scale := jpegScale(cfg.Width, cfg.Height, maxSize); scale > 1 {
+			release, err := s.decodeBudget.acquire(decodeCost(cfg, scale) + thumb)
+			if err != nil {
+				return nil, nil, err
+			}
+			img, err := decodeJPEGScaled(r, scale)
+			if err == nil {
+				return img, release, nil
+			}
+			release()
+			if !errors.Is(err, errJPEGUnsupported) {
+				return nil, nil, fmt.Errorf("decode jpeg: %w", err)
+			}
+			// Progressive and other JPEGs are decoded at full size
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	release, err = s.decodeBudget.acquire(decodeCost(cfg, 1) + thumb)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err = decodeImage(r)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return img, release, nil
+}
+
+// decodeCost estimates the memory a decode of cfg at 1/scale size holds, from
+// the bytes per pixel of the image it produces.
+func decodeCost(cfg image.Config, scale int) int64 {
+	bpp := int64(4)
+	if _, ok := cfg.ColorModel.(color.Palette); ok {
+		bpp = 1
+	} else {
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			bpp = 1
+		case color.Gray16Model:
+			bpp = 2
+		case color.YCbCrModel: // 3 for 4:4:4, less when subsampled
+			bpp = 3
+		case color.RGBA64Model, color.NRGBA64Model:
+			bpp = 8
+		}
+	}
+	w := (int64(cfg.Width) + int64(scale) - 1) / int64(scale)
+	h := (int64(cfg.Height) + int64(scale) - 1) / int64(scale)
+	return w * h * bpp
+}
+
+// memoryBudget bounds the memory held by decodes running at once.
+type memoryBudget struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	total int64
+	used  int64
+}
+
+func newMemoryBudget(total int64) *memoryBudget {
+	b := &memoryBudget{total: total}
+	b.freed = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire reserves n bytes, waiting until other decodes free enough. It fails
+// at once when n is more than the whole budget. A nil budget is unlimited.
+func (b *memoryBudget) acquire(n int64) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	if n > b.total {
+		return nil, fmt.Errorf("%w: decoding needs about %.1f MB, over the %.1f MB decode memory budget",
+			ErrImageTooLarge, float64(n)/(1<<20), float64(b.total)/(1<<20))
+	}
+	b.mu.Lock()
+	for b.used+n > b.total {
+		b.freed.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		b.used -= n
+		b.mu.Unlock()
+		b.freed.Broadcast()
+	}, nil
+}
+
+// webpAnimationFlag marks an animated WebP in the VP8X chunk flags.
+const webpAnimationFlag = 1 << 1
+
+// decodeWebP decodes a still WebP, or the first frame of an animated one
+// (which x/image/webp does not handle on its own).
+func decodeWebP(r io.Reader, head []byte) (image.Image, error) {
+	if len(head) < sniffLen || string(head[12:16]) != "VP8X" || head[20]&webpAnimationFlag == 0 {
+		return webp.Decode(r)
+	}
+	frame, err := firstWebPFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return webp.Decode(bytes.NewReader(frame))
+}
+
+// firstWebPFrame reads an animated WebP up to its first ANMF chunk and
+// rebuilds that frame as a still WebP: a VP8X header sized to the frame,
+// followed by the frame's ALPH and VP8/VP8L chunks.
+func firstWebPFrame(r io.Reader) ([]byte, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = errors.New("webp: animation has no frames")
+			}
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(hdr[4:])
+		padded := int64(size) + int64(size&1)
+		if string(hdr[:4]) != "ANMF" {
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size < 16 {
+			return nil, errors.New("webp: invalid ANMF chunk")
+		}
+		anmf := make([]byte, size)
+		if _, err := io.ReadFull(r, anmf); err != nil {
+			return nil, err
+		}
+		return stillWebP(anmf[6:12], anmf[16:]), nil
+	}
+}
+
+// stillWebP wraps frame chunks in a RIFF container with a VP8X chunk giving
+// the frame's width-1 and height-1 (3 bytes each, little endian).
+func stillWebP(dims, chunks []byte) []byte {
+	var flags byte
+	if bytes.HasPrefix(chunks, []byte("ALPH")) {
+		flags |= 1 << 4 // Alpha
+	}
+	vp8x := make([]byte, 0, 18)
+	vp8x = append(vp8x, "VP8X"...)
+	vp8x = binary.LittleEndian.AppendUint32(vp8x, 10)
+	vp8x = append(vp8x, flags, 0, 0, 0)
+	vp8x = append(vp8x, dims...)
+
+	out := make([]byte, 0, 12+len(vp8x)+len(chunks))
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(vp8x)+len(chunks)))
+	out = append(out, "WEBP"...)
+	out = append(out, vp8x...)
+	return append(out, chunks...)
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// bitWriter writes the least significant bits first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (w.nbits % 8)
+		w.nbits++
+	}
+}
+
+// vp8lSolid encodes a w×h lossless WebP bitstream of one color: every
+// prefix code has a single symbol, so pixels take no bits.
+func vp8lSolid(w, h int, c color.NRGBA) []byte {
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(1, 1) // Alpha used
+	bw.write(0, 3) // Version
+	bw.write(0, 1) // No transform
+	bw.write(0, 1) // No color cache
+	bw.write(0, 1) // No meta prefix codes
+	for _, sym := range []uint8{c.G, c.R, c.B, c.A, 0} {
+		bw.write(1, 1) // Simple code
+		bw.write(0, 1) // One symbol
+		bw.write(1, 1) // Of 8 bits
+		bw.write(uint32(sym), 8)
+	}
+	return bw.buf
+}
+
+// riffChunk builds a RIFF chunk, padded to an even size.
+func riffChunk(fourCC string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// uint24 encodes v in 3 bytes, little endian.
+func uint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// animatedWebP builds an animated WebP whose frames are w×h and of the given
+// colors, each frame offset by (2, 2) on a larger canvas.
+func animatedWebP(w, h int, colors ...color.NRGBA) []byte {
+	vp8x := append([]byte{webpAnimationFlag | 1<<4, 0, 0, 0}, uint24(w+1)...)
+	vp8x = append(vp8x, uint24(h+1)...)
+	chunks := [][]byte{riffChunk("VP8X", vp8x), riffChunk("ANIM", make([]byte, 6))}
+	for _, c := range colors {
+		hdr := bytes.Join([][]byte{uint24(1), uint24(1), uint24(w - 1), uint24(h - 1), uint24(100), {0}}, nil)
+		chunks = append(chunks, riffChunk("ANMF", hdr, riffChunk("VP8L", vp8lSolid(w, h, c))))
+	}
+	body := bytes.Join(chunks, nil)
+	file := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	return append(append(file, "WEBP"...), body...)
+}
+
+func TestDecodeWebP(t *testing.T) {
+	red := color.NRGBA{200, 10, 20, 255}
+	blue := color.NRGBA{10, 20, 200, 128}
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		want color.NRGBA
+	}{
+		{"still", append([]byte("RIFF\x00\x00\x00\x00WEBP"), riffChunk("VP8L", vp8lSolid(5, 3, red))...), 5, 3, red},
+		{"animated takes the first frame", animatedWebP(4, 6, red, blue), 4, 6, red},
+		{"animated with a translucent first frame", animatedWebP(3, 3, blue, red), 3, 3, blue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary.LittleEndian.PutUint32(tt.data[4:], uint32(len(tt.data)-8))
+			img, err := decodeWebP(bytes.NewReader(tt.data), tt.data[:sniffLen])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Errorf("size %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+			if got := color.NRGBAModel.Convert(img.At(0, 0)); got != tt.want {
+				t.Errorf("color %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstWebPFrame(t *testing.T) {
+	vp8l := riffChunk("VP8L", vp8lSolid(4, 6, color.NRGBA{A: 255}))
+	alph := riffChunk("ALPH", []byte{0, 1, 2})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte // The still WebP rebuilt, nil when an error is expected
+		wantErr bool
+	}{
+		{
+			name: "first frame",
+			data: animatedWebP(4, 6, color.NRGBA{A: 255}, color.NRGBA{R: 255, A: 255}),
+			want: bytes.Join([][]byte{
+				[]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+18+len(vp8l))), []byte("WEBP"),
+				riffChunk("VP8X", []byte{0, 0, 0, 0}, uint24(3), uint24(5)),
+				vp8l,
+			}, nil),
+		},
+		{
+			name: "alpha chunk sets the alpha flag",
+			data: bytes.Join([][]byte{
+				[]byte("RIFF\x00\x00\x00\x00WEBP"),
+				riffChunk("ANMF", uint24(0), uint24(0), uint24(3), uint24(5), uint24(0), []byte{0}, alph, vp8l),
+			}, nil),
+			want: bytes.Join([][]byte{
+				[]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+18+len(alph)+len(vp8l))), []byte("WEBP"),
+				riffChunk("VP8X", []byte{1 << 4, 0, 0, 0}, uint24(3), uint24(5)),
+				alph, vp8l,
+			}, nil),
+		},
+		{
+			name:    "no frames",
+			data:    append([]byte("RIFF\x00\x00\x00\x00WEBP"), riffChunk("ANIM", make([]byte, 6))...),
+			wantErr: true,
+		},
+		{
+			name:    "frame header cut short",
+			data:    append([]byte("RIFF\x00\x00\x00\x00WEBP"), riffChunk("ANMF", make([]byte, 10))...),
+			wantErr: true,
+		},
+		{
+			name:    "truncated frame",
+			data:    animatedWebP(4, 6, color.NRGBA{A: 255})[:60],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := firstWebPFrame(bytes.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got  % x\nwant % x", got, tt.want)
+			}
+		})
+	}
+}
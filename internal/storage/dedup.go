@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrLinkUnsupported is returned by Link when the backend cannot hardlink
+	// (S3, memory) or the two files are on different filesystems.
+	ErrLinkUnsupported = errors.New("hardlinks are not supported here")
+	// ErrContentDiffers is returned by Link when the files are not identical.
+	ErrContentDiffers = errors.New("files differ")
+)
+
+// CanLink reports whether the backend supports deduplicating with Link.
+func (s *Storage) CanLink() bool {
+	_, ok := s.backend.(linker)
+	return ok
+}
+
+// Link replaces dupPath with a hardlink to keepPath so both share one copy on
+// disk. The files are compared byte for byte first; ErrContentDiffers is
+// returned if they are not identical. Already linked files are left alone.
+// Writes through Storage always replace a file rather than modify it, so a
+// later upload to either path separates them again.
+func (s *Storage) Link(keepPath, dupPath string) error {
+	lk, ok := s.backend.(linker)
+	if !ok {
+		return ErrLinkUnsupported
+	}
+
+	keep, err := s.resolvePath(keepPath)
+	if err != nil {
+		return err
+	}
+	dup, err := s.resolvePath(dupPath)
+	if err != nil {
+		return err
+	}
+	if keep == dup {
+		return fmt.Errorf("cannot link a file to itself")
+	}
+
+	if same, err := lk.SameFile(keep, dup); err != nil {
+		return err
+	} else if same {
+		return nil
+	}
+
+	hash, err := s.compare(keep, dup)
+	if err != nil {
+		return err
+	}
+	if err := lk.Link(keep, dup); err != nil {
+		return err
+	}
+
+	s.notify(Change{Op: ChangeWrite, Path: dup, Hash: hash})
+	return nil
+}
+
+// SameFile reports whether two paths share one copy on disk (hardlinks).
+func (s *Storage) SameFile(a, b string) bool {
+	lk, ok := s.backend.(linker)
+	if !ok {
+		return false
+	}
+	pa, err := s.resolvePath(a)
+	if err != nil {
+		return false
+	}
+	pb, err := s.resolvePath(b)
+	if err != nil {
+		return false
+	}
+	same, err := lk.SameFile(pa, pb)
+	return err == nil && same
+}
+
+// compare checks that two files (clean backend paths) have identical content
+// and returns its hex SHA-256.
+func (s *Storage) compare(a, b string) (string, error) {
+	infoA, err := s.backend.Stat(a)
+	if err != nil {
+		return "", err
+	}
+	infoB, err := s.backend.Stat(b)
+	if err != nil {
+		return "", err
+	}
+	if infoA.IsDir || infoB.IsDir {
+		return "", errIsDir
+	}
+	if infoA.Size != infoB.Size {
+		return "", ErrContentDiffers
+	}
+
+	fa, err := s.backend.Open(a)
+	if err != nil {
+		return "", err
+	}
+	defer fa.Close()
+	fb, err := s.backend.Open(b)
+	if err != nil {
+		return "", err
+	}
+	defer fb.Close()
+
+	h := sha256.New()
+	bufA := make([]byte, 64<<10)
+	bufB := make([]byte, 64<<10)
+	for {
+		n, errA := io.ReadFull(fa, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return "", errA
+		}
+		if _, err := io.ReadFull(fb, bufB[:n]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return "", ErrContentDiffers
+			}
+			return "", err
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			return "", ErrContentDiffers
+		}
+		h.Write(bufA[:n])
+
+		if errA != nil {
+			break
+		}
+	}
+
+	// b must end where a does
+	if n, _ := fb.Read(bufB[:1]); n > 0 {
+		return "", ErrContentDiffers
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import "sync"
+
+// ChangeOp says what happened to a path.
+type ChangeOp string
+
+const (
+	ChangeWrite  ChangeOp = "write"  // File or directory created or replaced
+	ChangeRemove ChangeOp = "remove" // Removed from the tree (deleted or trashed)
+	ChangeRename ChangeOp = "rename" // Moved from OldPath to Path
+)
+
+// Change describes a modification of the tree. Paths are relative to root,
+// slash-separated, without a leading slash.
+type Change struct {
+	Op      ChangeOp
+	Path    string
+	OldPath string // Only for ChangeRename
+	Hash    string // Hex SHA-256 of the content, when the writer computed it (ChangeWrite)
+}
+
+// listeners holds change subscribers.
+type listeners struct {
+	mu  sync.RWMutex
+	fns []func(Change)
+}
+
+// Subscribe registers fn to be called after every successful modification made
+// through Storage. fn runs on the caller's goroutine and must not block;
+// queue slow work (hashing, thumbnails) elsewhere.
+func (s *Storage) Subscribe(fn func(Change)) {
+	s.listeners.mu.Lock()
+	defer s.listeners.mu.Unlock()
+	s.listeners.fns = append(s.listeners.fns, fn)
+}
+
+// Notify delivers a change observed outside Storage (e.g. by the filesystem
+// watcher) to subscribers.
+func (s *Storage) Notify(c Change) {
+	s.notify(c)
+}
+
+// notify delivers a change to all subscribers.
+func (s *Storage) notify(c Change) {
+	s.listeners.mu.RLock()
+	defer s.listeners.mu.RUnlock()
+	for _, fn := range s.listeners.fns {
+		fn(c)
+	}
+}
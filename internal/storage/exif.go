@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exifReadLimit is how much of a file is read looking for EXIF data. The APP1
+// segment sits near the start of a JPEG and is capped at 64KB; TIFF-based
+// files keep IFD0 and the EXIF IFD near the start as well.
+const exifReadLimit = 256 << 10
+
+// errNoExif is returned when a file carries no readable EXIF block.
+var errNoExif = errors.New("no EXIF data")
+
+// EXIF tags read by readExif.
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// exifInfo holds the EXIF fields the app uses.
+type exifInfo struct {
+	Taken       time.Time // DateTimeOriginal, else DateTime (zero if absent)
+	Orientation int       // 1-8 (see orientImage), 0 if absent
+}
+
+// hasExif reports whether files with this extension are worth probing for EXIF.
+func hasExif(ext string) bool {
+	switch ext {
+	case ".jpg", ".jpeg", ".tif", ".tiff":
+		return true
+	}
+	return isRawExt(ext)
+}
+
+// readExif extracts EXIF fields from a JPEG, TIFF or CR3 stream. Only the
+// start of the stream is read (see exifReadLimit).
+func readExif(r io.Reader) (exifInfo, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, exifReadLimit))
+	if err != nil {
+		return exifInfo{}, err
+	}
+	if isCR3(buf) {
+		return readCR3Exif(buf)
+	}
+	tiff := findTIFF(buf)
+	if tiff == nil {
+		return exifInfo{}, errNoExif
+	}
+	return parseTIFF(tiff)
+}
+
+// findTIFF returns the TIFF structure holding EXIF data: the whole buffer for
+// TIFF files, the payload of the "Exif" APP1 segment for JPEGs.
+func findTIFF(buf []byte) []byte {
+	if isTIFFHeader(buf) {
+		return buf
+	}
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(buf); {
+		if buf[i] != 0xFF {
+			return nil
+		}
+		marker := buf[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF before it
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(buf[i+2:]))
+		if size < 2 || i+2+size > len(buf) {
+			return nil
+		}
+		seg := buf[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+		i += 2 + size
+	}
+	return nil
+}
+
+// isTIFFHeader reports whether b starts with a TIFF byte-order mark and magic.
+func isTIFFHeader(b []byte) bool {
+	return len(b) >= 8 && (bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*")))
+}
+
+// parseTIFF walks IFD0 and the EXIF sub-IFD of a TIFF structure.
+func parseTIFF(b []byte) (exifInfo, error) {
+	var info exifInfo
+	if !isTIFFHeader(b) {
+		return info, errNoExif
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if b[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	var dateTime, original string
+	var exifOffset uint32
+	walkIFD(b, order, order.Uint32(b[4:]), func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagOrientation:
+			if typ == 3 && count == 1 { // SHORT
+				if o := int(order.Uint16(value)); o >= 1 && o <= 8 {
+					info.Orientation = o
+				}
+			}
+		case tagDateTime:
+			dateTime = tiffString(b, order, typ, count, value)
+		case tagExifIFD:
+			exifOffset = order.Uint32(value)
+		}
+	})
+	if exifOffset != 0 {
+		walkIFD(b, order, exifOffset, func(tag, typ uint16, count uint32, value []byte) {
+			if tag == tagDateTimeOriginal {
+				original = tiffString(b, order, typ, count, value)
+			}
+		})
+	}
+
+	for _, s := range []string{original, dateTime} {
+		if t, err := parseExifTime(s); err == nil {
+			info.Taken = t
+			break
+		}
+	}
+	return info, nil
+}
+
+// walkIFD calls fn for every entry of the IFD at offset. value is the 4-byte
+// value/offset field of the entry.
+func walkIFD(b []byte, order binary.ByteOrder, offset uint32, fn func(tag, typ uint16, count uint32, value []byte)) {
+	if int64(offset)+2 > int64(len(b)) {
+		return
+	}
+	n := int(order.Uint16(b[offset:]))
+	start := int(offset) + 2
+	for i := 0; i < n; i++ {
+		e := start + i*12
+		if e+12 > len(b) {
+			return
+		}
+		fn(order.Uint16(b[e:]), order.Uint16(b[e+2:]), order.Uint32(b[e+4:]), b[e+8:e+12])
+	}
+}
+
+// tiffString returns an ASCII (type 2) value, stored inline when it fits in
+// four bytes and at an offset otherwise.
+func tiffString(b []byte, order binary.ByteOrder, typ uint16, count uint32, value []byte) string {
+	if typ != 2 || count == 0 {
+		return ""
+	}
+	var data []byte
+	if count > 4 {
+		off := order.Uint32(value)
+		if int64(off)+int64(count) > int64(len(b)) {
+			return ""
+		}
+		data = b[off : off+count]
+	} else {
+		data = value[:count]
+	}
+	return strings.TrimRight(string(data), "\x00 ")
+}
+
+// parseExifTime parses the EXIF "2006:01:02 15:04:05" format (placeholders
+// such as "0000:00:00 00:00:00" fail). EXIF carries no zone, so the time is
+// taken as local, like the camera clock.
+func parseExifTime(s string) (time.Time, error) {
+	return time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+}
+
+// maxExifCache bounds the number of files whose EXIF fields are kept in memory.
+const maxExifCache = 50000
+
+// exifCache remembers EXIF fields so sorting a large folder by date, or
+// serving its thumbnails, reads each file once. Entries are keyed by path and
+// checked against size and mtime, so replaced files are read again.
+type exifCache struct {
+	mu      sync.Mutex
+	entries map[string]exifEntry
+}
+
+type exifEntry struct {
+	size    int64
+	modTime time.Time
+	info    exifInfo
+}
+
+func (c *exifCache) get(p string, f FileInfo) (exifInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[p]
+	if !ok || e.size != f.Size || !e.modTime.Equal(f.ModTime) {
+		return exifInfo{}, false
+	}
+	return e.info, true
+}
+
+func (c *exifCache) put(p string, f FileInfo, info exifInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= maxExifCache {
+		c.entries = make(map[string]exifEntry)
+	}
+	c.entries[p] = exifEntry{size: f.Size, modTime: f.ModTime, info: info}
+}
+
+// exifFor returns the EXIF fields of p (a backend path) as described by f,
+// reading the file only when the cache has nothing current. Files without
+// readable EXIF give zero fields.
+func (s *Storage) exifFor(p string, f FileInfo) exifInfo {
+	if info, ok := s.exif.get(p, f); ok {
+		return info
+	}
+	var info exifInfo
+	if r, err := s.backend.Open(p); err == nil {
+		info, _ = readExif(r)
+		r.Close()
+	}
+	s.exif.put(p, f, info)
+	return info
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// tiffOrder is the byte order of a TIFF fixture.
+type tiffOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffField is one IFD entry for the TIFF fixtures built by buildTIFF: a
+// SHORT or LONG value stored inline, or an ASCII string stored after the IFDs.
+type tiffField struct {
+	tag   uint16
+	typ   uint16
+	value uint32
+	str   string
+}
+
+// buildTIFF lays out a TIFF header followed by ifds, chained in order, with
+// their strings after them. A LONG field whose value is subIFD+i points at
+// ifds[i] (for the EXIF IFD and SubIFDs). extra is appended last and its
+// offset returned, for image data.
+func buildTIFF(order tiffOrder, ifds [][]tiffField, extra []byte) ([]byte, uint32) {
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = pos
+		pos += uint32(2 + 12*len(ifd) + 4)
+	}
+
+	buf := make([]byte, 8, 256)
+	if order.String() == binary.LittleEndian.String() {
+		copy(buf, "II*\x00")
+	} else {
+		copy(buf, "MM\x00*")
+	}
+	order.PutUint32(buf[4:], offsets[0])
+
+	var strs []byte
+	for i, ifd := range ifds {
+		buf = order.AppendUint16(buf, uint16(len(ifd)))
+		for _, f := range ifd {
+			var value [4]byte
+			count := uint32(1)
+			switch {
+			case f.typ == 2:
+				count = uint32(len(f.str) + 1)
+				if count <= 4 {
+					copy(value[:], f.str)
+				} else {
+					order.PutUint32(value[:], pos+uint32(len(strs)))
+					strs = append(append(strs, f.str...), 0)
+				}
+			case f.typ == 3:
+				order.PutUint16(value[:], uint16(f.value))
+			case f.value >= subIFD:
+				order.PutUint32(value[:], offsets[f.value-subIFD])
+			default:
+				order.PutUint32(value[:], f.value)
+			}
+			buf = order.AppendUint16(buf, f.tag)
+			buf = order.AppendUint16(buf, f.typ)
+			buf = order.AppendUint32(buf, count)
+			buf = append(buf, value[:]...)
+		}
+		next := uint32(0)
+		if i+1 < len(ifds) && !isSubIFD(ifds, i+1) {
+			next = offsets[i+1]
+		}
+		buf = order.AppendUint32(buf, next)
+	}
+	buf = append(buf, strs...)
+	extraOff := uint32(len(buf))
+	return append(buf, extra...), extraOff
+}
+
+// subIFD marks a LONG field value as a reference to another fixture IFD.
+const subIFD = 1 << 30
+
+// isSubIFD reports whether some field points at ifds[i], which then is not
+// part of the IFD chain.
+func isSubIFD(ifds [][]tiffField, i int) bool {
+	for _, ifd := range ifds {
+		for _, f := range ifd {
+			if f.typ == 4 && f.value == subIFD+uint32(i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exifTIFF builds the TIFF structure of an EXIF block. Zero values leave the
+// field out.
+func exifTIFF(order tiffOrder, orientation int, dateTime, original string) []byte {
+	var ifd0 []tiffField
+	if orientation != 0 {
+		ifd0 = append(ifd0, tiffField{tag: tagOrientation, typ: 3, value: uint32(orientation)})
+	}
+	if dateTime != "" {
+		ifd0 = append(ifd0, tiffField{tag: tagDateTime, typ: 2, str: dateTime})
+	}
+	ifds := [][]tiffField{ifd0}
+	if original != "" {
+		ifd0 = append(ifd0, tiffField{tag: tagExifIFD, typ: 4, value: subIFD + 1})
+		ifds = [][]tiffField{ifd0, {{tag: tagDateTimeOriginal, typ: 2, str: original}}}
+	}
+	b, _ := buildTIFF(order, ifds, nil)
+	return b
+}
+
+// jpegWithExif returns a small JPEG carrying tiff in an "Exif" APP1 segment.
+func jpegWithExif(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	plain := testJPEG(t, 16, 16)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+6+len(tiff)))
+	app1 = append(append(app1, "Exif\x00\x00"...), tiff...)
+	return append(append(append([]byte{}, plain[:2]...), app1...), plain[2:]...)
+}
+
+// testJPEG encodes a w×h baseline JPEG.
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadExif(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	taken := time.Date(2024, 5, 17, 14, 3, 9, 0, time.Local)
+	modified := time.Date(2024, 6, 1, 8, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+		taken       time.Time
+		err         error
+	}{
+		{
+			name:        "jpeg little endian",
+			data:        jpegWithExif(t, exifTIFF(le, 6, "2024:06:01 08:00:00", "2024:05:17 14:03:09")),
+			orientation: 6,
+			taken:       taken,
+		},
+		{
+			name:        "jpeg big endian",
+			data:        jpegWithExif(t, exifTIFF(be, 8, "", "2024:05:17 14:03:09")),
+			orientation: 8,
+			taken:       taken,
+		},
+		{
+			name:  "DateTime when DateTimeOriginal is missing",
+			data:  jpegWithExif(t, exifTIFF(le, 0, "2024:06:01 08:00:00", "")),
+			taken: modified,
+		},
+		{
+			name:        "DateTime when DateTimeOriginal is a placeholder",
+			data:        exifTIFF(be, 1, "2024:06:01 08:00:00", "0000:00:00 00:00:00"),
+			orientation: 1,
+			taken:       modified,
+		},
+		{
+			name: "orientation out of range",
+			data: jpegWithExif(t, exifTIFF(le, 9, "", "")),
+		},
+		{
+			name: "jpeg without exif",
+			data: testJPEG(t, 16, 16),
+			err:  errNoExif,
+		},
+		{
+			name: "not an image",
+			data: []byte("hello, world"),
+			err:  errNoExif,
+		},
+		{
+			name:        "cr3",
+			data:        testCR3(exifTIFF(le, 3, "2024:06:01 08:00:00", ""), nil, nil, nil),
+			orientation: 3,
+			taken:       modified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := readExif(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if info.Orientation != tt.orientation {
+				t.Errorf("Orientation = %d, want %d", info.Orientation, tt.orientation)
+			}
+			if !info.Taken.Equal(tt.taken) {
+				t.Errorf("Taken = %v, want %v", info.Taken, tt.taken)
+			}
+		})
+	}
+}
+
+func TestReadExifCR3DateTimeOriginal(t *testing.T) {
+	le := binary.LittleEndian
+	cmt2, _ := buildTIFF(le, [][]tiffField{{{tag: tagDateTimeOriginal, typ: 2, str: "2023:12:24 18:30:00"}}}, nil)
+	data := testCR3(exifTIFF(le, 6, "2024:01:02 00:00:00", ""), cmt2, nil, nil)
+
+	info, err := readExif(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2023, 12, 24, 18, 30, 0, 0, time.Local)
+	if info.Orientation != 6 || !info.Taken.Equal(want) {
+		t.Errorf("got orientation %d, taken %v; want 6, %v", info.Orientation, info.Taken, want)
+	}
+}
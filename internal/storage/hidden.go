@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HidePolicy controls which names are kept out of listings, ZIPs and shares.
+type HidePolicy string
+
+const (
+	HideInternal HidePolicy = "internal" // Only the app's own files (thumbnail cache, upload temp files)
+	HideJunk     HidePolicy = "junk"     // Also OS clutter such as .DS_Store and Thumbs.db
+	HideDotfiles HidePolicy = "dotfiles" // Also every name starting with a dot
+)
+
+// ParseHidePolicy converts a config value to a HidePolicy, defaulting to HideJunk.
+func ParseHidePolicy(v string) HidePolicy {
+	switch HidePolicy(strings.ToLower(v)) {
+	case HideInternal:
+		return HideInternal
+	case HideDotfiles:
+		return HideDotfiles
+	}
+	return HideJunk
+}
+
+// ErrHidden is returned for paths the hide policy keeps out of view.
+var ErrHidden = errors.New("path is hidden")
+
+// junkNames are files and folders operating systems and NAS firmware leave
+// behind, matched case-insensitively.
+var junkNames = map[string]bool{
+	".ds_store":                 true,
+	".appledouble":              true,
+	".localized":                true,
+	".spotlight-v100":           true,
+	".trashes":                  true,
+	".fseventsd":                true,
+	".temporaryitems":           true,
+	".apdisk":                   true,
+	"thumbs.db":                 true,
+	"ehthumbs.db":               true,
+	"desktop.ini":               true,
+	"$recycle.bin":              true,
+	"system volume information": true,
+	"@eadir":                    true, // Synology
+	"#recycle":                  true, // Synology
+}
+
+// SetHidePolicy sets which names are hidden (HideJunk by default).
+func (s *Storage) SetHidePolicy(p HidePolicy) {
+	s.hide = p
+}
+
+// Reserve takes relPath and everything beneath it out of the tree: app data
+// kept under ROOT, such as the recycle bin with the default DB_PATH. Reserved
+// paths are hidden whatever the policy and refused by every operation, writes
+// included. Call before the storage is used.
+func (s *Storage) Reserve(relPath string) error {
+	p, err := normalizePath(relPath)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return fmt.Errorf("cannot reserve the root directory")
+	}
+	s.reserved = append(s.reserved, p)
+	return nil
+}
+
+// isReserved reports whether p (a clean backend path) is reserved or lies
+// beneath a reserved path.
+func (s *Storage) isReserved(p string) bool {
+	for _, r := range s.reserved {
+		if p == r || strings.HasPrefix(p, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Hidden reports whether relPath, or any folder above it, is hidden by the
+// policy or reserved. Hidden paths are left out of List and ListPage and
+// refused by Open, CreateZip and GenerateThumbnail, so neither /files nor a
+// share reveals them.
+func (s *Storage) Hidden(relPath string) bool {
+	p, err := normalizePath(relPath)
+	if err != nil || p == "" {
+		return false
+	}
+	if IsInternal(p) || s.isReserved(p) {
+		return true
+	}
+	for _, name := range strings.Split(p, "/") {
+		if s.hiddenName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenName reports whether a single name is hidden by the policy.
+func (s *Storage) hiddenName(name string) bool {
+	if name == thumbCacheDir || isUploadTemp(name) {
+		return true
+	}
+	switch s.hide {
+	case HideInternal:
+		return false
+	case HideDotfiles:
+		if strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	// AppleDouble "._name" companions count as junk like .DS_Store
+	return junkNames[strings.ToLower(name)] || strings.HasPrefix(name, "._")
+}
+
+// visiblePath is resolvePath for reads on behalf of users: hidden paths give
+// ErrHidden.
+func (s *Storage) visiblePath(relPath string) (string, error) {
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if s.Hidden(p) {
+		return "", ErrHidden
+	}
+	return p, nil
+}
+
+// visible drops hidden and reserved entries from a listing of dir, in place.
+func (s *Storage) visible(dir string, files []FileInfo) []FileInfo {
+	kept := files[:0]
+	for _, f := range files {
+		if !s.hiddenName(f.Name) && !s.isReserved(path.Join(dir, f.Name)) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,563 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// errJPEGUnsupported is returned by decodeJPEGScaled for JPEGs it leaves to
+// image/jpeg: progressive, lossless, 12-bit, CMYK, RGB and unusual sampling.
+var errJPEGUnsupported = errors.New("jpeg: not supported by the reduced-size decoder")
+
+// jpegScale returns the largest reduction (8, 4 or 2) at which a w×h JPEG
+// still gives a thumbnail of maxSize without upscaling, or 1 if none does.
+func jpegScale(w, h, maxSize int) int {
+	long := max(w, h)
+	for _, scale := range []int{8, 4, 2} {
+		if long/scale >= maxSize {
+			return scale
+		}
+	}
+	return 1
+}
+
+// decodeJPEGScaled decodes a baseline JPEG at 1/scale of its size (scale 2,
+// 4 or 8). Each 8×8 block is turned into 8/scale pixels per side straight
+// from its lowest DCT coefficients, so the full-size image is never held in
+// memory: a 24-megapixel photo decoded at 1/8 takes under 1MB.
+func decodeJPEGScaled(r io.Reader, scale int) (image.Image, error) {
+	d := &scaledJPEG{r: bufio.NewReader(r), n: 8 / scale}
+	if err := d.readHeaders(); err != nil {
+		return nil, err
+	}
+	return d.decodeScan()
+}
+
+// jpegZigzag maps the zigzag order of coefficients in the stream to their
+// natural (row-major) position in the 8×8 block.
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegIDCTCos holds, per output size n (1, 2 or 4), C(u)·cos((2x+1)uπ/2n)
+// for output pixel x and frequency u, with C(0) = 1/√2.
+var jpegIDCTCos = func() (t [5][4][4]float32) {
+	for _, n := range []int{1, 2, 4} {
+		for x := 0; x < n; x++ {
+			for u := 0; u < n; u++ {
+				c := math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+				if u == 0 {
+					c /= math.Sqrt2
+				}
+				t[n][x][u] = float32(c)
+			}
+		}
+	}
+	return t
+}()
+
+type jpegComponent struct {
+	id     byte
+	h, v   int // Sampling factors
+	tq     int // Quantization table
+	td, ta int // DC and AC Huffman tables
+	pred   int32
+}
+
+// jpegHuffman is a Huffman table decoded the canonical way (JPEG spec
+// F.2.2.3), with a lookup for codes of up to 8 bits.
+type jpegHuffman struct {
+	maxCode [17]int32 // Largest code of each length, -1 if none
+	minCode [17]int32
+	valPtr  [17]int32
+	vals    []byte
+	lookup  [256]uint16 // length<<8 | value for 8-bit prefixes, 0 if longer
+}
+
+type scaledJPEG struct {
+	r *bufio.Reader
+	n int // Output pixels per block side
+
+	width, height int
+	comps         []jpegComponent
+	quant         [4][64]int32 // Zigzag order
+	dc, ac        [4]*jpegHuffman
+	restart       int
+	adobeRGB      bool
+
+	bits       uint32 // Entropy-coded bits, most significant first
+	nbits      int
+	marker     bool // A marker ended the entropy-coded data
+	markerByte byte
+}
+
+// readHeaders reads markers up to the start of the scan.
+func (d *scaledJPEG) readHeaders() error {
+	var soi [2]byte
+	if _, err := io.ReadFull(d.r, soi[:]); err != nil {
+		return err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return errors.New("jpeg: missing SOI marker")
+	}
+	for {
+		m, err := d.nextMarker()
+		if err != nil {
+			return err
+		}
+		if m == 0xD8 || (m >= 0xD0 && m <= 0xD7) || m == 0x01 { // No length
+			continue
+		}
+		var lb [2]byte
+		if _, err := io.ReadFull(d.r, lb[:]); err != nil {
+			return err
+		}
+		size := int(binary.BigEndian.Uint16(lb[:])) - 2
+		if size < 0 {
+			return errors.New("jpeg: bad segment length")
+		}
+		seg := make([]byte, size)
+		if _, err := io.ReadFull(d.r, seg); err != nil {
+			return err
+		}
+
+		switch {
+		case m == 0xC0 || m == 0xC1: // Baseline, extended sequential (Huffman)
+			err = d.readFrame(seg)
+		case m >= 0xC2 && m <= 0xCF && m != 0xC4 && m != 0xC8 && m != 0xCC:
+			return errJPEGUnsupported // Progressive, lossless, arithmetic
+		case m == 0xC4:
+			err = d.readHuffman(seg)
+		case m == 0xDB:
+			err = d.readQuant(seg)
+		case m == 0xDD:
+			if len(seg) < 2 {
+				return errors.New("jpeg: bad DRI segment")
+			}
+			d.restart = int(binary.BigEndian.Uint16(seg))
+		case m == 0xEE: // Adobe: transform 0 means RGB or CMYK, not YCbCr
+			if len(seg) >= 12 && string(seg[:5]) == "Adobe" && seg[11] == 0 {
+				d.adobeRGB = true
+			}
+		case m == 0xDA:
+			return d.readScanHeader(seg)
+		case m == 0xD9:
+			return errors.New("jpeg: no image data")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextMarker skips to the next marker and returns its code.
+func (d *scaledJPEG) nextMarker() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errors.New("jpeg: expected a marker")
+	}
+	for b == 0xFF { // Fill bytes
+		if b, err = d.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+func (d *scaledJPEG) readFrame(seg []byte) error {
+	if len(seg) < 6 {
+		return errors.New("jpeg: bad SOF segment")
+	}
+	if seg[0] != 8 {
+		return errJPEGUnsupported // 12-bit
+	}
+	d.height = int(binary.BigEndian.Uint16(seg[1:]))
+	d.width = int(binary.BigEndian.Uint16(seg[3:]))
+	nc := int(seg[5])
+	if d.width == 0 || d.height == 0 || (nc != 1 && nc != 3) {
+		return errJPEGUnsupported // Height from DNL, CMYK
+	}
+	if len(seg) < 6+3*nc {
+		return errors.New("jpeg: bad SOF segment")
+	}
+	d.comps = make([]jpegComponent, nc)
+	for i := range d.comps {
+		c := seg[6+3*i:]
+		d.comps[i] = jpegComponent{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 15), tq: int(c[2] & 3)}
+		if d.comps[i].h < 1 || d.comps[i].h > 4 || d.comps[i].v < 1 || d.comps[i].v > 4 {
+			return errors.New("jpeg: bad sampling factors")
+		}
+	}
+	return nil
+}
+
+func (d *scaledJPEG) readHuffman(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errors.New("jpeg: bad DHT segment")
+		}
+		class, id := seg[0]>>4, int(seg[0]&15)
+		if class > 1 || id > 3 {
+			return errors.New("jpeg: bad DHT segment")
+		}
+		var counts [17]int
+		total := 0
+		for l := 1; l <= 16; l++ {
+			counts[l] = int(seg[l])
+			total += counts[l]
+		}
+		if len(seg) < 17+total {
+			return errors.New("jpeg: bad DHT segment")
+		}
+		h := &jpegHuffman{vals: append([]byte(nil), seg[17:17+total]...)}
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			h.valPtr[l], h.minCode[l] = k, code
+			h.maxCode[l] = -1
+			if counts[l] > 0 {
+				h.maxCode[l] = code + int32(counts[l]) - 1
+			}
+			if code+int32(counts[l]) > 1<<l {
+				return errors.New("jpeg: bad Huffman table")
+			}
+			if l <= 8 {
+				for c := code; c <= h.maxCode[l]; c++ {
+					v := uint16(l)<<8 | uint16(h.vals[k+c-code])
+					for ext := int32(0); ext < 1<<(8-l); ext++ {
+						h.lookup[c<<(8-l)|ext] = v
+					}
+				}
+			}
+			code += int32(counts[l])
+			k += int32(counts[l])
+			code <<= 1
+		}
+		if class == 0 {
+			d.dc[id] = h
+		} else {
+			d.ac[id] = h
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (d *scaledJPEG) readQuant(seg []byte) error {
+	for len(seg) > 0 {
+		precision, id := seg[0]>>4, int(seg[0]&15)
+		if id > 3 || precision > 1 || len(seg) < 1+64*(1+int(precision)) {
+			return errors.New("jpeg: bad DQT segment")
+		}
+		for k := 0; k < 64; k++ {
+			if precision == 0 {
+				d.quant[id][k] = int32(seg[1+k])
+			} else {
+				d.quant[id][k] = int32(binary.BigEndian.Uint16(seg[1+2*k:]))
+			}
+		}
+		seg = seg[1+64*(1+int(precision)):]
+	}
+	return nil
+}
+
+// readScanHeader checks the scan covers every component in one sequential
+// pass and records each component's Huffman tables.
+func (d *scaledJPEG) readScanHeader(seg []byte) error {
+	if d.comps == nil {
+		return errors.New("jpeg: scan before frame header")
+	}
+	if len(seg) < 1 || len(seg) < 1+2*int(seg[0])+3 {
+		return errors.New("jpeg: bad SOS segment")
+	}
+	if int(seg[0]) != len(d.comps) {
+		return errJPEGUnsupported // One scan per component
+	}
+	if len(d.comps) == 3 && (d.adobeRGB || (d.comps[0].id == 'R' && d.comps[1].id == 'G' && d.comps[2].id == 'B')) {
+		return errJPEGUnsupported
+	}
+	for i := range d.comps {
+		s := seg[1+2*i:]
+		if s[0] != d.comps[i].id {
+			return errJPEGUnsupported
+		}
+		d.comps[i].td, d.comps[i].ta = int(s[1]>>4), int(s[1]&15)
+		if d.comps[i].td > 3 || d.comps[i].ta > 3 || d.dc[d.comps[i].td] == nil || d.ac[d.comps[i].ta] == nil {
+			return errors.New("jpeg: missing Huffman table")
+		}
+	}
+	return nil
+}
+
+// decodeScan decodes the entropy-coded data into a reduced-size image.
+func (d *scaledJPEG) decodeScan() (image.Image, error) {
+	n := d.n
+	hmax, vmax := 1, 1
+	if len(d.comps) > 1 { // A single component is never interleaved
+		for _, c := range d.comps {
+			hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+		}
+	} else {
+		d.comps[0].h, d.comps[0].v = 1, 1
+	}
+	mcusX := (d.width + 8*hmax - 1) / (8 * hmax)
+	mcusY := (d.height + 8*vmax - 1) / (8 * vmax)
+	full := image.Rect(0, 0, mcusX*hmax*n, mcusY*vmax*n)
+
+	var img image.Image
+	var planes [3][]byte
+	var strides [3]int
+	if len(d.comps) == 1 {
+		g := image.NewGray(full)
+		img, planes[0], strides[0] = g, g.Pix, g.Stride
+	} else {
+		ratio, ok := jpegSubsampleRatio(d.comps)
+		if !ok {
+			return nil, errJPEGUnsupported
+		}
+		y := image.NewYCbCr(full, ratio)
+		img = y
+		planes = [3][]byte{y.Y, y.Cb, y.Cr}
+		strides = [3]int{y.YStride, y.CStride, y.CStride}
+	}
+
+	var coef [64]int32
+	mcu := 0
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if d.restart > 0 && mcu > 0 && mcu%d.restart == 0 {
+				if err := d.readRestart(); err != nil {
+					return nil, err
+				}
+			}
+			mcu++
+			for ci := range d.comps {
+				c := &d.comps[ci]
+				for by := 0; by < c.v; by++ {
+					for bx := 0; bx < c.h; bx++ {
+						if err := d.decodeBlock(c, &coef); err != nil {
+							return nil, err
+						}
+						x, y := (mx*c.h+bx)*n, (my*c.v+by)*n
+						jpegIDCTScaled(&coef, n, planes[ci][y*strides[ci]+x:], strides[ci])
+					}
+				}
+			}
+		}
+	}
+
+	// Crop the padding of partial MCUs
+	w, h := (d.width*n+7)/8, (d.height*n+7)/8
+	return img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(0, 0, w, h)), nil
+}
+
+// jpegSubsampleRatio maps the sampling factors of a YCbCr JPEG to a ratio.
+func jpegSubsampleRatio(comps []jpegComponent) (image.YCbCrSubsampleRatio, bool) {
+	if comps[1].h != 1 || comps[1].v != 1 || comps[2].h != 1 || comps[2].v != 1 {
+		return 0, false
+	}
+	switch [2]int{comps[0].h, comps[0].v} {
+	case [2]int{1, 1}:
+		return image.YCbCrSubsampleRatio444, true
+	case [2]int{2, 1}:
+		return image.YCbCrSubsampleRatio422, true
+	case [2]int{2, 2}:
+		return image.YCbCrSubsampleRatio420, true
+	case [2]int{1, 2}:
+		return image.YCbCrSubsampleRatio440, true
+	case [2]int{4, 1}:
+		return image.YCbCrSubsampleRatio411, true
+	case [2]int{4, 2}:
+		return image.YCbCrSubsampleRatio410, true
+	}
+	return 0, false
+}
+
+// decodeBlock reads one block's coefficients, keeping (dequantized) only the
+// lowest n×n that the reduced-size IDCT uses.
+func (d *scaledJPEG) decodeBlock(c *jpegComponent, coef *[64]int32) error {
+	n := d.n
+	for v := 0; v < n; v++ {
+		for u := 0; u < n; u++ {
+			coef[v*8+u] = 0
+		}
+	}
+	q := &d.quant[c.tq]
+
+	t, err := d.decodeHuffman(d.dc[c.td])
+	if err != nil {
+		return err
+	}
+	if t > 11 {
+		return errors.New("jpeg: bad DC coefficient")
+	}
+	diff, err := d.receiveExtend(t)
+	if err != nil {
+		return err
+	}
+	c.pred += diff
+	coef[0] = c.pred * q[0]
+
+	ac := d.ac[c.ta]
+	for k := 1; k < 64; {
+		rs, err := d.decodeHuffman(ac)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), rs&15
+		if s == 0 {
+			if r != 15 {
+				break // End of block
+			}
+			k += 16
+			continue
+		}
+		k += r
+		if k > 63 {
+			return errors.New("jpeg: bad AC coefficient")
+		}
+		v, err := d.receiveExtend(s)
+		if err != nil {
+			return err
+		}
+		if z := jpegZigzag[k]; z/8 < n && z%8 < n {
+			coef[z] = v * q[k]
+		}
+		k++
+	}
+	return nil
+}
+
+// jpegIDCTScaled writes the n×n pixels of a block from its lowest n×n
+// coefficients. Scaling the 8-point inverse DCT down to n points keeps the
+// 1/4 factor of the full transform, so pixels come out as block averages.
+func jpegIDCTScaled(coef *[64]int32, n int, dst []byte, stride int) {
+	cos := &jpegIDCTCos[n]
+	var tmp [4][4]float32 // [v][x]
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			var s float32
+			for u := 0; u < n; u++ {
+				s += float32(coef[v*8+u]) * cos[x][u]
+			}
+			tmp[v][x] = s
+		}
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			var s float32
+			for v := 0; v < n; v++ {
+				s += cos[y][v] * tmp[v][x]
+			}
+			p := int(s/4 + 128.5)
+			dst[y*stride+x] = uint8(min(max(p, 0), 255))
+		}
+	}
+}
+
+// fill tops up the bit buffer to more than 24 bits. Once a marker ends the
+// data, zeros are fed, as decoders do for truncated streams.
+func (d *scaledJPEG) fill() error {
+	for d.nbits <= 24 {
+		var c byte
+		if !d.marker {
+			b, err := d.r.ReadByte()
+			if err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			if b == 0xFF {
+				m, err := d.r.ReadByte()
+				for err == nil && m == 0xFF {
+					m, err = d.r.ReadByte()
+				}
+				if err != nil {
+					return io.ErrUnexpectedEOF
+				}
+				if m == 0 { // Stuffed byte
+					c = 0xFF
+				} else {
+					d.marker, d.markerByte = true, m
+				}
+			} else {
+				c = b
+			}
+		}
+		d.bits |= uint32(c) << (24 - d.nbits)
+		d.nbits += 8
+	}
+	return nil
+}
+
+func (d *scaledJPEG) decodeHuffman(h *jpegHuffman) (byte, error) {
+	if d.nbits < 16 {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	if e := h.lookup[d.bits>>24]; e != 0 {
+		d.bits <<= e >> 8
+		d.nbits -= int(e >> 8)
+		return byte(e), nil
+	}
+	for l := 9; l <= 16; l++ {
+		code := int32(d.bits >> (32 - l))
+		if code <= h.maxCode[l] {
+			d.bits <<= l
+			d.nbits -= l
+			return h.vals[h.valPtr[l]+code-h.minCode[l]], nil
+		}
+	}
+	return 0, errors.New("jpeg: bad Huffman code")
+}
+
+// receiveExtend reads an s-bit coefficient and sign-extends it (JPEG spec
+// F.2.2.1).
+func (d *scaledJPEG) receiveExtend(s byte) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+	if d.nbits < int(s) {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	v := int32(d.bits >> (32 - s))
+	d.bits <<= s
+	d.nbits -= int(s)
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, nil
+}
+
+// readRestart consumes an RSTn marker and resets the decoder state.
+func (d *scaledJPEG) readRestart() error {
+	d.bits, d.nbits = 0, 0
+	if !d.marker {
+		m, err := d.nextMarker()
+		if err != nil {
+			return err
+		}
+		d.markerByte = m
+	}
+	d.marker = false
+	if d.markerByte < 0xD0 || d.markerByte > 0xD7 {
+		return fmt.Errorf("jpeg: expected a restart marker, found %#x", d.markerByte)
+	}
+	for i := range d.comps {
+		d.comps[i].pred = 0
+	}
+	return nil
+}
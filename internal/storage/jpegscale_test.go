@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestJPEGScale(t *testing.T) {
+	tests := []struct {
+		w, h, maxSize int
+		want          int
+	}{
+		{6000, 4000, 320, 8},
+		{6000, 4000, 750, 8},
+		{6000, 4000, 751, 4},
+		{4000, 6000, 1600, 2},
+		{6000, 4000, 3001, 1},
+		{200, 100, 320, 1},
+	}
+	for _, tt := range tests {
+		if got := jpegScale(tt.w, tt.h, tt.maxSize); got != tt.want {
+			t.Errorf("jpegScale(%d, %d, %d) = %d, want %d", tt.w, tt.h, tt.maxSize, got, tt.want)
+		}
+	}
+}
+
+// smoothImage is a w×h picture of gradients, the kind of content JPEG keeps
+// well, so decoders can be compared pixel by pixel.
+func smoothImage(w, h int, gray bool) image.Image {
+	if gray {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.SetGray(x, y, color.Gray{uint8((x*255/w + y*255/h) / 2)})
+			}
+		}
+		return img
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8(255 - (x+y)*255/(w+h)), 255})
+		}
+	}
+	return img
+}
+
+// boxDownscale averages scale×scale blocks of img, the partial blocks at the
+// right and bottom edges over the pixels they have.
+func boxDownscale(img image.Image, scale int) *image.RGBA {
+	b := img.Bounds()
+	w, h := (b.Dx()+scale-1)/scale, (b.Dy()+scale-1)/scale
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [3]int
+			n := 0
+			for sy := y * scale; sy < min((y+1)*scale, b.Dy()); sy++ {
+				for sx := x * scale; sx < min((x+1)*scale, b.Dx()); sx++ {
+					c := color.RGBAModel.Convert(img.At(b.Min.X+sx, b.Min.Y+sy)).(color.RGBA)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					n++
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 255})
+		}
+	}
+	return out
+}
+
+func TestDecodeJPEGScaled(t *testing.T) {
+	tests := []struct {
+		name string
+		w, h int
+		gray bool
+		mean float64 // Largest mean difference per channel allowed
+	}{
+		// Color gets more room: at 1/8 a 4:2:0 chroma block gives one
+		// value for 2×2 output pixels, where the reference has four
+		{"color 4:2:0", 256, 192, false, 6},
+		{"color partial blocks", 203, 117, false, 6},
+		{"gray", 200, 120, true, 2},
+		{"gray partial blocks", 67, 45, true, 2},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, smoothImage(tt.w, tt.h, tt.gray), &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		full, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, scale := range []int{2, 4, 8} {
+			got, err := decodeJPEGScaled(bytes.NewReader(buf.Bytes()), scale)
+			if err != nil {
+				t.Fatalf("%s 1/%d: %v", tt.name, scale, err)
+			}
+			want := boxDownscale(full, scale)
+			if got.Bounds().Size() != want.Bounds().Size() {
+				t.Errorf("%s 1/%d: size %v, want %v", tt.name, scale, got.Bounds().Size(), want.Bounds().Size())
+				continue
+			}
+
+			// Averaging 8×8 blocks in the DCT domain matches averaging
+			// decoded pixels up to rounding and chroma sampling
+			var total, worst int
+			gb := got.Bounds()
+			for y := 0; y < gb.Dy(); y++ {
+				for x := 0; x < gb.Dx(); x++ {
+					g := color.RGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.RGBA)
+					w := want.RGBAAt(x, y)
+					for _, d := range []int{absDiff(g.R, w.R), absDiff(g.G, w.G), absDiff(g.B, w.B)} {
+						total += d
+						worst = max(worst, d)
+					}
+				}
+			}
+			mean := float64(total) / float64(3*gb.Dx()*gb.Dy())
+			if mean > tt.mean || worst > 24 {
+				t.Errorf("%s 1/%d: mean difference %.2f, worst %d", tt.name, scale, mean, worst)
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestDecodeJPEGScaledUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, smoothImage(32, 32, false), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Mark the frame progressive (SOF2), which is left to image/jpeg
+	data := buf.Bytes()
+	i := bytes.Index(data, []byte{0xFF, 0xC0})
+	if i < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	data[i+1] = 0xC2
+
+	if _, err := decodeJPEGScaled(bytes.NewReader(data), 8); !errors.Is(err, errJPEGUnsupported) {
+		t.Errorf("err = %v, want errJPEGUnsupported", err)
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SortKey selects the order of a listing.
+type SortKey string
+
+const (
+	SortName    SortKey = "name"  // Natural order: "IMG_2" before "IMG_10"
+	SortSize    SortKey = "size"  // File size
+	SortModTime SortKey = "mtime" // Last modification
+	SortTaken   SortKey = "taken" // EXIF capture date, else modification time
+)
+
+// ParseSortKey returns the sort key named s, or SortName for unknown values.
+func ParseSortKey(s string) SortKey {
+	switch k := SortKey(s); k {
+	case SortSize, SortModTime, SortTaken:
+		return k
+	}
+	return SortName
+}
+
+// ErrBadCursor is returned by ListPage for a cursor it did not issue.
+var ErrBadCursor = errors.New("invalid page cursor")
+
+// ListOptions controls sorting, filtering and paging in ListPage.
+// Folders always come before files, whatever the order.
+type ListOptions struct {
+	Sort     SortKey
+	Desc     bool
+	Exts     []string // Keep only files with these extensions (".jpg"; folders are kept)
+	Contains string   // Keep only entries whose name contains this, ignoring case
+	After    string   // Cursor from a previous page's Next ("" = first page)
+	Limit    int      // Entries per page (0 = all)
+}
+
+// ListPage is one page of a sorted, filtered directory listing.
+type ListPage struct {
+	Files  []FileInfo
+	Total  int    // Entries matching the filters, over all pages
+	Offset int    // Position of Files[0] among them
+	Next   string // Cursor for the following page ("" = last page)
+}
+
+// ListPage returns a sorted, filtered page of a directory, without hidden
+// entries (see Hidden). Cursors record the sort key of the last entry shown
+// rather than a position, so a page stays in place when entries before it are
+// added or removed.
+func (s *Storage) ListPage(relPath string, opts ListOptions) (ListPage, error) {
+	p, err := s.visiblePath(relPath)
+	if err != nil {
+		return ListPage{}, err
+	}
+	all, err := s.backend.List(p)
+	if err != nil {
+		return ListPage{}, err
+	}
+
+	files := filterFiles(s.visible(p, all), opts)
+	if opts.Sort == SortTaken {
+		s.fillTaken(p, files)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return compareFiles(files[i], files[j], opts.Sort, opts.Desc) < 0
+	})
+
+	page := ListPage{Total: len(files)}
+	if opts.After != "" {
+		after, err := decodeCursor(opts.After)
+		if err != nil {
+			return ListPage{}, err
+		}
+		page.Offset = sort.Search(len(files), func(i int) bool {
+			return compareFiles(after, files[i], opts.Sort, opts.Desc) < 0
+		})
+	}
+	end := len(files)
+	if opts.Limit > 0 && page.Offset+opts.Limit < end {
+		end = page.Offset + opts.Limit
+		page.Next = encodeCursor(files[end-1])
+	}
+	page.Files = files[page.Offset:end]
+	return page, nil
+}
+
+// filterFiles returns the entries of all that pass the name and extension filters.
+func filterFiles(all []FileInfo, opts ListOptions) []FileInfo {
+	contains := strings.ToLower(opts.Contains)
+	files := make([]FileInfo, 0, len(all))
+	for _, f := range all {
+		if contains != "" && !strings.Contains(strings.ToLower(f.Name), contains) {
+			continue
+		}
+		if len(opts.Exts) > 0 && !f.IsDir && !hasExt(opts.Exts, f.Name) {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// hasExt reports whether name ends in one of exts, ignoring case.
+func hasExt(exts []string, name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// compareFiles orders a before (<0) or after (>0) b: folders first, then by
+// key, then by name so the order is total and cursors are unambiguous.
+func compareFiles(a, b FileInfo, key SortKey, desc bool) int {
+	if a.IsDir != b.IsDir {
+		if a.IsDir {
+			return -1
+		}
+		return 1
+	}
+	c := 0
+	switch key {
+	case SortSize:
+		if !a.IsDir {
+			c = compareInt(a.Size, b.Size)
+		}
+	case SortModTime:
+		c = compareInt(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+	case SortTaken:
+		c = compareInt(takenOrModTime(a).UnixNano(), takenOrModTime(b).UnixNano())
+	}
+	if c == 0 {
+		c = NaturalCompare(a.Name, b.Name)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// takenOrModTime is the date a file sorts by under SortTaken.
+func takenOrModTime(f FileInfo) time.Time {
+	if !f.TakenAt.IsZero() {
+		return f.TakenAt
+	}
+	return f.ModTime
+}
+
+// NaturalCompare compares names the way people read them: case-insensitively,
+// with runs of digits compared by value ("IMG_9" < "IMG_10" < "img_011").
+func NaturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if isDigit(ra) && isDigit(rb) {
+			da, db := digitRun(a), digitRun(b)
+			if c := compareDigits(da, db); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if la, lb := unicode.ToLower(ra), unicode.ToLower(rb); la != lb {
+			if la < lb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+// digitRun returns the leading ASCII digits of s.
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// compareDigits compares two digit runs by value, without overflowing on long
+// runs; equal values with more leading zeros sort later.
+func compareDigits(a, b string) int {
+	ta, tb := strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if c := compareInt(int64(len(ta)), int64(len(tb))); c != 0 {
+		return c
+	}
+	if c := strings.Compare(ta, tb); c != 0 {
+		return c
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+// encodeCursor records the sort keys of f in an opaque, URL-safe token.
+func encodeCursor(f FileInfo) string {
+	dir := "f"
+	if f.IsDir {
+		dir = "d"
+	}
+	raw := fmt.Sprintf("%s|%d|%d|%d|%s", dir, f.Size, f.ModTime.UnixNano(), takenNano(f), f.Name)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func takenNano(f FileInfo) int64 {
+	if f.TakenAt.IsZero() {
+		return 0
+	}
+	return f.TakenAt.UnixNano()
+}
+
+// decodeCursor turns a cursor back into the sort keys it was made from.
+func decodeCursor(cursor string) (FileInfo, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return FileInfo{}, ErrBadCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 5)
+	if len(parts) != 5 || (parts[0] != "d" && parts[0] != "f") {
+		return FileInfo{}, ErrBadCursor
+	}
+	var nums [3]int64
+	for i := range nums {
+		if nums[i], err = strconv.ParseInt(parts[i+1], 10, 64); err != nil {
+			return FileInfo{}, ErrBadCursor
+		}
+	}
+	f := FileInfo{
+		Name:    parts[4],
+		IsDir:   parts[0] == "d",
+		Size:    nums[0],
+		ModTime: time.Unix(0, nums[1]),
+	}
+	if nums[2] != 0 {
+		f.TakenAt = time.Unix(0, nums[2])
+	}
+	return f, nil
+}
+
+// fillTaken sets TakenAt on the files of dir (a backend path) that carry an
+// EXIF capture date.
+func (s *Storage) fillTaken(dir string, files []FileInfo) {
+	for i, f := range files {
+		if f.IsDir || !hasExif(strings.ToLower(path.Ext(f.Name))) {
+			continue
+		}
+		files[i].TakenAt = s.exifFor(path.Join(dir, f.Name), f).Taken
+	}
+}
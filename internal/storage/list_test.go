@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTree returns storage on a memory backend holding dir/ with two folders
+// and twelve files, file IMG_n being n bytes long.
+func testTree(t *testing.T) *Storage {
+	t.Helper()
+	s := NewWithBackend(NewMemoryBackend())
+	for _, d := range []string{"dir/Zeta", "dir/alpha"} {
+		if err := s.Mkdir(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 12; i++ {
+		ext := ".jpg"
+		if i%3 == 0 {
+			ext = ".png"
+		}
+		if err := s.Write(fmt.Sprintf("dir/IMG_%d%s", i, ext), []byte(strings.Repeat("x", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// pageNames lists the names of all pages of dir, following Next cursors.
+func pageNames(t *testing.T, s *Storage, dir string, opts ListOptions) []string {
+	t.Helper()
+	var names []string
+	for i := 0; ; i++ {
+		page, err := s.ListPage(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Limit > 0 && len(page.Files) > opts.Limit {
+			t.Fatalf("page of %d entries, limit %d", len(page.Files), opts.Limit)
+		}
+		for _, f := range page.Files {
+			names = append(names, f.Name)
+		}
+		if page.Next == "" {
+			return names
+		}
+		if i > 20 {
+			t.Fatal("cursor does not advance")
+		}
+		opts.After = page.Next
+	}
+}
+
+func TestListPage(t *testing.T) {
+	s := testTree(t)
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{
+			name: "natural order, folders first",
+			opts: ListOptions{Limit: 5},
+			want: "alpha Zeta IMG_1.jpg IMG_2.jpg IMG_3.png IMG_4.jpg IMG_5.jpg IMG_6.png IMG_7.jpg IMG_8.jpg IMG_9.png IMG_10.jpg IMG_11.jpg IMG_12.png",
+		},
+		{
+			name: "descending, folders still first",
+			opts: ListOptions{Desc: true, Limit: 4},
+			want: "Zeta alpha IMG_12.png IMG_11.jpg IMG_10.jpg IMG_9.png IMG_8.jpg IMG_7.jpg IMG_6.png IMG_5.jpg IMG_4.jpg IMG_3.png IMG_2.jpg IMG_1.jpg",
+		},
+		{
+			name: "by size",
+			opts: ListOptions{Sort: SortSize, Desc: true, Limit: 3, Exts: []string{".png"}},
+			want: "Zeta alpha IMG_12.png IMG_9.png IMG_6.png IMG_3.png",
+		},
+		{
+			name: "name filter",
+			opts: ListOptions{Contains: "img_1", Limit: 2},
+			want: "IMG_1.jpg IMG_10.jpg IMG_11.jpg IMG_12.png",
+		},
+		{
+			name: "one page",
+			opts: ListOptions{Exts: []string{".png"}},
+			want: "alpha Zeta IMG_3.png IMG_6.png IMG_9.png IMG_12.png",
+		},
+		{
+			name: "limit equal to the entries",
+			opts: ListOptions{Contains: "zeta", Limit: 1},
+			want: "Zeta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(pageNames(t, s, "dir", tt.opts), " ")
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListPageCursorStaysInPlace(t *testing.T) {
+	s := testTree(t)
+	first, err := s.ListPage("dir", ListOptions{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.ListPage("dir", ListOptions{Limit: 5, After: first.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries before the cursor come and go; the next page does not move
+	if err := s.Delete("dir/IMG_2.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("dir/IMG_0.jpg", nil); err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.ListPage("dir", ListOptions{Limit: 5, After: first.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fileNames(again.Files), fileNames(second.Files)) {
+		t.Errorf("second page moved from %v to %v", fileNames(second.Files), fileNames(again.Files))
+	}
+	if again.Total != 14 || again.Offset != 5 {
+		t.Errorf("Total, Offset = %d, %d, want 14, 5", again.Total, again.Offset)
+	}
+
+	// The entry the cursor names may go too
+	if err := s.Delete("dir/" + first.Files[4].Name); err != nil {
+		t.Fatal(err)
+	}
+	again, err = s.ListPage("dir", ListOptions{Limit: 5, After: first.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fileNames(again.Files), fileNames(second.Files)) {
+		t.Errorf("second page moved from %v to %v", fileNames(second.Files), fileNames(again.Files))
+	}
+}
+
+func TestListPageBadCursor(t *testing.T) {
+	s := testTree(t)
+	cursors := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("f|1|2|name")),   // Too few fields
+		base64.RawURLEncoding.EncodeToString([]byte("x|1|2|3|name")), // Neither file nor folder
+		base64.RawURLEncoding.EncodeToString([]byte("f|a|2|3|name")), // Size not a number
+	}
+	for _, cursor := range cursors {
+		if _, err := s.ListPage("dir", ListOptions{After: cursor}); !errors.Is(err, ErrBadCursor) {
+			t.Errorf("cursor %q: err = %v, want ErrBadCursor", cursor, err)
+		}
+	}
+}
+
+func fileNames(files []FileInfo) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -0,0 +1,467 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LocalBackend stores files on the local filesystem under a root directory.
+type LocalBackend struct {
+	root     string
+	puid     int
+	pgid     int
+	symlinks SymlinkPolicy // How symlinks under root are treated
+}
+
+// SymlinkPolicy controls whether paths may traverse symlinks.
+type SymlinkPolicy string
+
+const (
+	SymlinkDeny   SymlinkPolicy = "deny"   // Any symlink in a path is rejected
+	SymlinkInside SymlinkPolicy = "inside" // Symlinks are allowed if their target stays inside root
+	SymlinkFollow SymlinkPolicy = "follow" // Symlinks are followed wherever they point
+)
+
+// ParseSymlinkPolicy converts a config value to a SymlinkPolicy, defaulting to SymlinkInside.
+func ParseSymlinkPolicy(v string) SymlinkPolicy {
+	switch SymlinkPolicy(strings.ToLower(v)) {
+	case SymlinkDeny:
+		return SymlinkDeny
+	case SymlinkFollow:
+		return SymlinkFollow
+	}
+	return SymlinkInside
+}
+
+// NewLocalBackend creates a filesystem backend rooted at root.
+// Root itself may be a symlink (e.g. /data -> /mnt/usb); it is resolved once here
+// so confinement checks compare real paths.
+func NewLocalBackend(root string, puid, pgid int) *LocalBackend {
+	root = filepath.Clean(root)
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return &LocalBackend{
+		root:     root,
+		puid:     puid,
+		pgid:     pgid,
+		symlinks: SymlinkInside,
+	}
+}
+
+// Root returns the resolved root directory.
+func (b *LocalBackend) Root() string {
+	return b.root
+}
+
+// SetSymlinkPolicy sets how symlinks under root are treated.
+func (b *LocalBackend) SetSymlinkPolicy(p SymlinkPolicy) {
+	b.symlinks = p
+}
+
+// abs resolves a cleaned backend path to an absolute path within root.
+// Symlinks along the way are handled per the symlink policy (see confine).
+func (b *LocalBackend) abs(path string) (string, error) {
+	if path == "" {
+		return b.root, nil
+	}
+
+	// Verify the result is within root on a separator boundary
+	// (so ROOT=/data does not accept /data2)
+	absPath := filepath.Join(b.root, filepath.FromSlash(path))
+	if !isWithin(absPath, b.root) {
+		return "", ErrOutsideRoot
+	}
+
+	return b.confine(path)
+}
+
+// confine walks path (relative to root) one component at a time and applies
+// the symlink policy to every symlink it meets. Intermediate symlinks are
+// replaced by their targets; a symlink in the final component is checked but
+// returned as-is, so Delete and Rename act on the link rather than its target.
+// Components that do not exist yet (e.g. a new upload) end the walk.
+func (b *LocalBackend) confine(path string) (string, error) {
+	parts := strings.Split(path, "/")
+	current := b.root
+
+	for i, part := range parts {
+		next := filepath.Join(current, part)
+
+		info, err := os.Lstat(next)
+		if err != nil {
+			// Nothing below a missing component can be a symlink
+			return filepath.Join(append([]string{next}, parts[i+1:]...)...), nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		target, err := b.checkSymlink(next)
+		if err != nil {
+			return "", err
+		}
+		if i == len(parts)-1 {
+			return next, nil
+		}
+		current = target
+	}
+
+	return current, nil
+}
+
+// checkSymlink applies the symlink policy to the link at path and returns its
+// fully resolved target.
+func (b *LocalBackend) checkSymlink(path string) (string, error) {
+	if b.symlinks == SymlinkDeny {
+		return "", ErrSymlink
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("resolve symlink: %w", err)
+	}
+
+	if b.symlinks == SymlinkInside && !isWithin(target, b.root) {
+		return "", ErrOutsideRoot
+	}
+	return target, nil
+}
+
+// RealPath resolves path like abs and also follows a final symlink.
+func (b *LocalBackend) RealPath(path string) (string, error) {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(absPath); err == nil {
+		return real, nil
+	}
+	return absPath, nil
+}
+
+// List returns the contents of a directory.
+func (b *LocalBackend) List(path string) ([]FileInfo, error) {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// Hide symlinks the policy would refuse; show allowed ones as their target
+		if entry.Type()&fs.ModeSymlink != 0 {
+			linkPath := filepath.Join(absPath, entry.Name())
+			if _, err := b.checkSymlink(linkPath); err != nil {
+				continue
+			}
+			if info, err = os.Stat(linkPath); err != nil {
+				continue
+			}
+		}
+
+		// Extract file extension (lowercase)
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+
+		files = append(files, FileInfo{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+			Ext:     ext,
+		})
+	}
+
+	return files, nil
+}
+
+// Open opens a file for streaming reads.
+func (b *LocalBackend) Open(path string) (File, error) {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Create writes to a temp file in the target directory; Close fsyncs it,
+// applies PUID/PGID and renames it into place, so readers never see a partial file.
+func (b *LocalBackend) Create(path string) (Writer, error) {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure parent directory exists
+	dir := filepath.Dir(absPath)
+	if err := b.mkdirAll(dir); err != nil {
+		return nil, err
+	}
+
+	// Temp file in the same directory so the final rename is atomic
+	tmp, err := os.CreateTemp(dir, ".upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &localWriter{File: tmp, path: absPath, puid: b.puid, pgid: b.pgid}, nil
+}
+
+// localWriter is the Writer returned by LocalBackend.Create.
+type localWriter struct {
+	*os.File
+	path string
+	puid int
+	pgid int
+	done bool
+}
+
+// Close fsyncs the temp file, applies ownership and renames it into place.
+func (w *localWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	tmpPath := w.File.Name()
+
+	if err := w.File.Chmod(0644); err != nil {
+		w.File.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.File.Sync(); err != nil {
+		w.File.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := w.File.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Apply PUID/PGID if configured (Docker use case); may not have permissions
+	_ = chown(tmpPath, w.puid, w.pgid)
+
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Abort discards the temp file.
+func (w *localWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
+
+// Stat returns file info for a path.
+func (b *LocalBackend) Stat(path string) (*FileInfo, error) {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := newFileInfo(path, info.Size(), info.ModTime(), info.IsDir())
+	return &fi, nil
+}
+
+// Delete removes a file or directory.
+func (b *LocalBackend) Delete(path string) error {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return err
+	}
+	if absPath == b.root {
+		return fmt.Errorf("cannot delete root directory")
+	}
+
+	return os.RemoveAll(absPath)
+}
+
+// Rename moves a file or directory, falling back to copy + delete across
+// filesystems (EXDEV). PUID/PGID is applied to the moved item.
+func (b *LocalBackend) Rename(oldPath, newPath string) error {
+	oldAbsPath, err := b.abs(oldPath)
+	if err != nil {
+		return err
+	}
+	newAbsPath, err := b.abs(newPath)
+	if err != nil {
+		return err
+	}
+
+	if err := b.mkdirAll(filepath.Dir(newAbsPath)); err != nil {
+		return err
+	}
+	if err := b.moveAcross(oldAbsPath, newAbsPath); err != nil {
+		return err
+	}
+	_ = chown(newAbsPath, b.puid, b.pgid)
+	return nil
+}
+
+// Mkdir creates a directory.
+func (b *LocalBackend) Mkdir(path string) error {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return err
+	}
+
+	return b.mkdirAll(absPath)
+}
+
+// Copy copies a file or directory tree on disk, preserving mtimes.
+func (b *LocalBackend) Copy(srcPath, dstPath string) error {
+	srcAbsPath, err := b.abs(srcPath)
+	if err != nil {
+		return err
+	}
+	dstAbsPath, err := b.abs(dstPath)
+	if err != nil {
+		return err
+	}
+
+	if err := b.mkdirAll(filepath.Dir(dstAbsPath)); err != nil {
+		return err
+	}
+	_, existed := os.Lstat(dstAbsPath)
+	if err := b.copyTree(srcAbsPath, dstAbsPath); err != nil {
+		if existed != nil {
+			os.RemoveAll(dstAbsPath) // Only what the copy created
+		}
+		return err
+	}
+	return nil
+}
+
+// Import moves a local file (e.g. a finished resumable upload) to path,
+// renaming when possible and copying across filesystems.
+func (b *LocalBackend) Import(path, srcPath string) error {
+	absPath, err := b.abs(path)
+	if err != nil {
+		return err
+	}
+
+	if err := b.mkdirAll(filepath.Dir(absPath)); err != nil {
+		return err
+	}
+	if err := b.moveAcross(srcPath, absPath); err != nil {
+		return err
+	}
+	_ = os.Chmod(absPath, 0644)
+	_ = chown(absPath, b.puid, b.pgid)
+	return nil
+}
+
+// Link replaces path with a hardlink to target. The link is made under a temp
+// name in the same directory and renamed over path, so path never disappears.
+// Returns ErrLinkUnsupported when the two are on different filesystems.
+func (b *LocalBackend) Link(target, path string) error {
+	targetAbsPath, err := b.abs(target)
+	if err != nil {
+		return err
+	}
+	absPath, err := b.abs(path)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(absPath), fmt.Sprintf(".upload-link-%d.tmp", time.Now().UnixNano()))
+	if err := os.Link(targetAbsPath, tmpPath); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return ErrLinkUnsupported
+		}
+		return err
+	}
+	if err := os.Rename(tmpPath, absPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// SameFile reports whether both paths are the same file on disk (hardlinks).
+func (b *LocalBackend) SameFile(path1, path2 string) (bool, error) {
+	absPath1, err := b.abs(path1)
+	if err != nil {
+		return false, err
+	}
+	absPath2, err := b.abs(path2)
+	if err != nil {
+		return false, err
+	}
+
+	info1, err := os.Stat(absPath1)
+	if err != nil {
+		return false, err
+	}
+	info2, err := os.Stat(absPath2)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(info1, info2), nil
+}
+
+// mkdirAll creates a directory and parents, applying PUID/PGID if configured
+// to every directory it creates (and to absPath itself).
+func (b *LocalBackend) mkdirAll(absPath string) error {
+	// Find the missing directories, deepest last
+	var missing []string
+	for dir := absPath; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+	}
+
+	if err := os.MkdirAll(absPath, 0755); err != nil {
+		return err
+	}
+
+	// Apply PUID/PGID if configured; may not have permissions
+	for _, dir := range missing {
+		_ = chown(dir, b.puid, b.pgid)
+	}
+	_ = chown(absPath, b.puid, b.pgid)
+	return nil
+}
+
+// chown is a helper to apply ownership (Windows-safe).
+func chown(path string, uid, gid int) error {
+	if uid <= 0 && gid <= 0 {
+		return nil
+	}
+	return syscall.Chown(path, uid, gid)
+}
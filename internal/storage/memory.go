@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps files in memory. Intended for tests and demos; contents
+// are lost on restart.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	entries map[string]*memEntry // Keyed by backend path; "" is the root dir
+}
+
+// memEntry is a file or directory in a MemoryBackend.
+type memEntry struct {
+	data    []byte
+	modTime time.Time
+	isDir   bool
+}
+
+// NewMemoryBackend creates an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		entries: map[string]*memEntry{
+			"": {isDir: true, modTime: time.Now()},
+		},
+	}
+}
+
+// List returns the contents of a directory.
+func (b *MemoryBackend) List(p string) ([]FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	dir, ok := b.entries[p]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	if !dir.isDir {
+		return nil, fs.ErrInvalid
+	}
+
+	var files []FileInfo
+	for key, e := range b.entries {
+		if key == "" || path.Dir(key) != dirKey(p) {
+			continue
+		}
+		files = append(files, newFileInfo(key, int64(len(e.data)), e.modTime, e.isDir))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Open returns a reader over a snapshot of the file's contents.
+func (b *MemoryBackend) Open(p string) (File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	e, ok := b.entries[p]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	if e.isDir {
+		return nil, errIsDir
+	}
+	return &memFile{
+		Reader: bytes.NewReader(e.data),
+		info:   newFileInfo(p, int64(len(e.data)), e.modTime, false),
+	}, nil
+}
+
+// Create buffers writes and stores the file on Close.
+func (b *MemoryBackend) Create(p string) (Writer, error) {
+	return &memWriter{backend: b, path: p}, nil
+}
+
+// Stat returns file info for a path.
+func (b *MemoryBackend) Stat(p string) (*FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	e, ok := b.entries[p]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	info := newFileInfo(p, int64(len(e.data)), e.modTime, e.isDir)
+	return &info, nil
+}
+
+// Delete removes a file or directory and everything beneath it.
+func (b *MemoryBackend) Delete(p string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.entries[p]; !ok {
+		return nil
+	}
+	for key := range b.entries {
+		if key == p || strings.HasPrefix(key, p+"/") {
+			delete(b.entries, key)
+		}
+	}
+	return nil
+}
+
+// Rename moves a file or directory (and its children) to newPath.
+func (b *MemoryBackend) Rename(oldPath, newPath string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.entries[oldPath]; !ok {
+		return fs.ErrNotExist
+	}
+	b.mkdirAll(path.Dir(newPath))
+
+	moved := make(map[string]*memEntry)
+	for key, e := range b.entries {
+		if key == oldPath || strings.HasPrefix(key, oldPath+"/") {
+			moved[newPath+strings.TrimPrefix(key, oldPath)] = e
+			delete(b.entries, key)
+		}
+	}
+	for key, e := range moved {
+		b.entries[key] = e
+	}
+	return nil
+}
+
+// Mkdir creates a directory and any missing parents.
+func (b *MemoryBackend) Mkdir(p string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.mkdirAll(p)
+	return nil
+}
+
+// mkdirAll creates p and its parents. Caller holds b.mu.
+func (b *MemoryBackend) mkdirAll(p string) {
+	for p != "." && p != "" && p != "/" {
+		if _, ok := b.entries[p]; ok {
+			return
+		}
+		b.entries[p] = &memEntry{isDir: true, modTime: time.Now()}
+		p = path.Dir(p)
+	}
+}
+
+// dirKey maps a backend directory path to the value path.Dir returns for its children.
+func dirKey(p string) string {
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// memFile is the File returned by MemoryBackend.Open.
+type memFile struct {
+	*bytes.Reader
+	info FileInfo
+}
+
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Stat() (fs.FileInfo, error) { return fileStat{f.info}, nil }
+
+// memWriter is the Writer returned by MemoryBackend.Create.
+type memWriter struct {
+	backend *MemoryBackend
+	path    string
+	buf     bytes.Buffer
+	done    bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+// Close stores the buffered data at the target path.
+func (w *memWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+
+	w.backend.mkdirAll(path.Dir(w.path))
+	w.backend.entries[w.path] = &memEntry{data: w.buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+// Abort discards the buffered data.
+func (w *memWriter) Abort() error {
+	w.done = true
+	w.buf.Reset()
+	return nil
+}
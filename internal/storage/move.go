@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Conflict decides what Move and Copy do when the destination name is taken.
+type Conflict string
+
+const (
+	ConflictFail      Conflict = "fail"      // Return ErrExists
+	ConflictOverwrite Conflict = "overwrite" // Replace the existing item
+	ConflictRename    Conflict = "rename"    // Pick "name (1).ext", "name (2).ext", ...
+)
+
+// ParseConflict converts a form value to a Conflict, defaulting to ConflictFail
+// (uploads call it "skip").
+func ParseConflict(v string) Conflict {
+	switch Conflict(v) {
+	case ConflictOverwrite, ConflictRename:
+		return Conflict(v)
+	}
+	return ConflictFail
+}
+
+// Move moves a file or directory into dstDir, keeping its name.
+// The local driver falls back to copy + delete when ROOT spans filesystems (EXDEV).
+// Returns the new path relative to root.
+func (s *Storage) Move(srcPath, dstDir string, conflict Conflict) (string, error) {
+	return s.transfer(srcPath, dstDir, conflict, false)
+}
+
+// Copy copies a file or directory (recursively) into dstDir, keeping its name.
+// Returns the new path relative to root.
+func (s *Storage) Copy(srcPath, dstDir string, conflict Conflict) (string, error) {
+	return s.transfer(srcPath, dstDir, conflict, true)
+}
+
+// transfer implements Move and Copy.
+func (s *Storage) transfer(srcPath, dstDir string, conflict Conflict, keepSource bool) (string, error) {
+	src, err := s.resolvePath(srcPath)
+	if err != nil {
+		return "", err
+	}
+	if src == "" {
+		return "", fmt.Errorf("cannot move or copy root directory")
+	}
+	srcInfo, err := s.backend.Stat(src)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := s.resolvePath(dstDir)
+	if err != nil {
+		return "", err
+	}
+	if info, err := s.backend.Stat(dir); err != nil || !info.IsDir {
+		return "", fmt.Errorf("destination is not a directory")
+	}
+
+	// A directory cannot go inside itself, also when reached through a symlink
+	if srcInfo.IsDir && (dir == src || strings.HasPrefix(dir, src+"/") || s.Within(dir, src)) {
+		return "", fmt.Errorf("cannot move or copy a folder into itself")
+	}
+
+	name := path.Base(src)
+	dst := path.Join(dir, name)
+	if dst == src || s.sameDir(dir, path.Dir(src)) {
+		if !keepSource {
+			return "/" + src, nil // already there
+		}
+		// Copy onto itself always needs a new name
+		conflict = ConflictRename
+	}
+
+	var version *VersionEntry
+	var replaced *TrashEntry
+	if dstInfo, err := s.backend.Stat(dst); err == nil {
+		switch conflict {
+		case ConflictOverwrite:
+			// A file replaced by a file is kept as a version and stays in
+			// place until the new one lands on it; anything else goes to the
+			// trash first
+			if !srcInfo.IsDir && !dstInfo.IsDir {
+				if version, err = s.stashVersion(dst); err != nil {
+					return "", err
+				}
+			}
+			if version == nil {
+				if replaced, err = s.stashReplaced(dst); err != nil {
+					return "", err
+				}
+			}
+		case ConflictRename:
+			dst = s.uniquePath(dir, name)
+		default:
+			return "", ErrExists
+		}
+	}
+
+	if keepSource {
+		if c, ok := s.backend.(copier); ok {
+			err = c.Copy(src, dst)
+		} else {
+			err = copyBetween(s.backend, src, s.backend, dst)
+		}
+	} else {
+		err = s.backend.Rename(src, dst)
+	}
+	s.commitVersion(version, err)
+	s.commitReplaced(replaced, err)
+	if err != nil {
+		return "", err
+	}
+
+	if keepSource {
+		s.notify(Change{Op: ChangeWrite, Path: dst})
+	} else {
+		s.notify(Change{Op: ChangeRename, Path: dst, OldPath: src})
+	}
+	return "/" + dst, nil
+}
+
+// FreeName returns relPath (sanitized, see CleanPath) if nothing exists there
+// yet, or the first free "base (N).ext" variant in the same directory.
+func (s *Storage) FreeName(relPath string) (string, error) {
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("invalid name")
+	}
+	if p, _, err = s.cleanNames(p); err != nil {
+		return "", err
+	}
+	return s.uniquePath(path.Dir(p), path.Base(p)), nil
+}
+
+// uniquePath returns dir/name, or the first free "base (N).ext" variant.
+func (s *Storage) uniquePath(dir, name string) string {
+	candidate := path.Join(dir, name)
+	if _, err := s.backend.Stat(candidate); err != nil {
+		return candidate
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate = path.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		if _, err := s.backend.Stat(candidate); err != nil {
+			return candidate
+		}
+	}
+}
+
+// sameDir reports whether two directories (clean backend paths) are the same
+// once symlinks are resolved.
+func (s *Storage) sameDir(a, b string) bool {
+	if a == b {
+		return true
+	}
+	rp, ok := s.backend.(realPather)
+	if !ok {
+		return false
+	}
+	ra, errA := rp.RealPath(a)
+	rb, errB := rp.RealPath(b)
+	return errA == nil && errB == nil && ra == rb
+}
+
+// isWithin reports whether path equals dir or lies beneath it (absolute OS paths).
+func isWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxNameBytes is the longest file or folder name written, in UTF-8
+// bytes (the limit of ext4, XFS and most other filesystems).
+const DefaultMaxNameBytes = 255
+
+// ErrInvalidName is returned for names that are empty once sanitized.
+var ErrInvalidName = errors.New("invalid file name")
+
+// NameChange records a name that was changed by sanitizing.
+type NameChange struct {
+	Path     string // Sanitized path relative to root, as written
+	Original string // The name as given
+}
+
+// SetMaxNameBytes sets the longest name written, in UTF-8 bytes (0 = DefaultMaxNameBytes).
+func (s *Storage) SetMaxNameBytes(n int) {
+	s.maxNameBytes = n
+}
+
+// OnNameChange registers fn to be called after a write, mkdir or rename
+// stored a name different from the one given, so the original can be kept.
+func (s *Storage) OnNameChange(fn func(NameChange)) {
+	s.onNameChange = fn
+}
+
+// windowsReserved are device names Windows (and SMB clients) cannot open,
+// with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName makes one file or folder name safe for every client that
+// reads the tree (browsers, ZIP tools, Windows and macOS over SMB):
+//   - Unicode is normalized to NFC (macOS sends NFD) and invalid UTF-8 replaced;
+//   - control characters and bidi overrides are removed;
+//   - characters Windows forbids (<>:"/\|?*) become "_";
+//   - leading spaces and trailing dots and spaces are trimmed;
+//   - reserved device names (CON, NUL, COM1, ...) get a "_" prefix;
+//   - the name is cut to maxBytes (0 = DefaultMaxNameBytes), keeping the extension.
+//
+// Emoji and other printable characters are kept. Returns ErrInvalidName if
+// nothing usable is left.
+func SanitizeName(name string, maxBytes int) (string, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxNameBytes
+	}
+
+	name = norm.NFC.String(strings.ToValidUTF8(name, "_"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, " ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "", ErrInvalidName
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		base = "_" + base
+	}
+
+	// Keep the extension unless it alone is too long
+	if len(ext) > maxBytes/2 {
+		base, ext = base+ext, ""
+	}
+	if len(base)+len(ext) > maxBytes {
+		base = truncateUTF8(base, maxBytes-len(ext))
+		base = strings.TrimRight(base, ". ")
+		if base == "" {
+			return "", ErrInvalidName
+		}
+	}
+	return base + ext, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// CleanPath returns the path a write or mkdir to relPath uses: names that
+// already exist are kept as they are, the rest are sanitized (see SanitizeName).
+func (s *Storage) CleanPath(relPath string) (string, error) {
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return "", err
+	}
+	p, _, err = s.cleanNames(p)
+	return p, err
+}
+
+// cleanNames sanitizes the components of p (a clean backend path) from the
+// first one that does not exist yet, returning the new path and what changed.
+func (s *Storage) cleanNames(p string) (string, []NameChange, error) {
+	if p == "" {
+		return p, nil, nil
+	}
+
+	parts := strings.Split(p, "/")
+	i := 0
+	for ; i < len(parts); i++ {
+		if _, err := s.backend.Stat(strings.Join(parts[:i+1], "/")); err != nil {
+			break
+		}
+	}
+
+	var changes []NameChange
+	for ; i < len(parts); i++ {
+		clean, err := SanitizeName(parts[i], s.maxNameBytes)
+		if err != nil {
+			return "", nil, err
+		}
+		if clean != parts[i] {
+			changes = append(changes, NameChange{Path: strings.Join(append(parts[:i:i], clean), "/"), Original: parts[i]})
+			parts[i] = clean
+		}
+	}
+	return strings.Join(parts, "/"), changes, nil
+}
+
+// nameChanged reports sanitized names once they have been written.
+func (s *Storage) nameChanged(changes []NameChange) {
+	if s.onNameChange == nil {
+		return
+	}
+	for _, c := range changes {
+		s.onNameChange(c)
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		maxBytes int
+		want     string
+		err      error
+	}{
+		{"unchanged", "IMG_0001.JPG", 0, "IMG_0001.JPG", nil},
+		{"emoji kept", "🎉 party.jpg", 0, "🎉 party.jpg", nil},
+		{"NFD to NFC", "Cafe\u0301.jpg", 0, "Caf\u00e9.jpg", nil},
+		{"invalid UTF-8", "a\xffb.txt", 0, "a_b.txt", nil},
+		{"control characters removed", "bad\x00name\x1f.txt", 0, "badname.txt", nil},
+		{"bidi override removed", "\u202egpj.exe", 0, "gpj.exe", nil},
+		{"windows characters replaced", `a<b>:c"d|e?f*.txt`, 0, "a_b__c_d_e_f_.txt", nil},
+		{"slashes replaced", `x/y\z`, 0, "x_y_z", nil},
+		{"leading spaces trimmed", "  lead.txt", 0, "lead.txt", nil},
+		{"trailing dots and spaces trimmed", "trail. . ", 0, "trail", nil},
+		{"reserved name", "CON", 0, "_CON", nil},
+		{"reserved name with extension", "nul.txt", 0, "_nul.txt", nil},
+		{"reserved name with digit", "com1.log", 0, "_com1.log", nil},
+		{"reserved prefix only", "CONSOLE.txt", 0, "CONSOLE.txt", nil},
+		{"cut keeping extension", strings.Repeat("a", 300) + ".jpg", 0, strings.Repeat("a", 251) + ".jpg", nil},
+		{"cut between characters", strings.Repeat("é", 10) + ".txt", 13, "éééé.txt", nil},
+		{"long extension cut with the name", "a." + strings.Repeat("x", 10), 8, "a.xxxxxx", nil},
+		{"empty", "", 0, "", ErrInvalidName},
+		{"only dots", "...", 0, "", ErrInvalidName},
+		{"only spaces", "   ", 0, "", ErrInvalidName},
+		{"only control characters", "\x01\x02", 0, "", ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeName(tt.in, tt.maxBytes)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("SanitizeName(%q) err = %v, want %v", tt.in, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("SanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
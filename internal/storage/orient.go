@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// EXIF Orientation values: how the stored pixels must be transformed to show
+// the picture upright.
+const (
+	orientNormal     = 1
+	orientFlipH      = 2 // Mirror left-right
+	orientRotate180  = 3
+	orientFlipV      = 4 // Mirror top-bottom
+	orientTranspose  = 5 // Mirror about the top-left to bottom-right diagonal
+	orientRotate90   = 6 // Rotate 90° clockwise
+	orientTransverse = 7 // Mirror about the top-right to bottom-left diagonal
+	orientRotate270  = 8 // Rotate 90° counter-clockwise
+)
+
+// orientImage returns img turned upright according to an EXIF orientation.
+// Orientation 1 and unknown values return img unchanged.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= orientNormal || orientation > orientRotate270 {
+		return img
+	}
+
+	// Work on RGBA pixels so each pixel is a 4-byte copy
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+		b = src.Bounds()
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= orientTranspose { // Width and height swap
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		row := src.PixOffset(b.Min.X, b.Min.Y+y)
+		for x := 0; x < w; x++ {
+			dx, dy := orientPoint(orientation, x, y, w, h)
+			i := dst.PixOffset(dx, dy)
+			copy(dst.Pix[i:i+4], src.Pix[row+x*4:row+x*4+4])
+		}
+	}
+	return dst
+}
+
+// orientPoint maps pixel (x, y) of a w×h stored image to its position in the
+// upright image.
+func orientPoint(orientation, x, y, w, h int) (int, int) {
+	switch orientation {
+	case orientFlipH:
+		return w - 1 - x, y
+	case orientRotate180:
+		return w - 1 - x, h - 1 - y
+	case orientFlipV:
+		return x, h - 1 - y
+	case orientTranspose:
+		return y, x
+	case orientRotate90:
+		return h - 1 - y, x
+	case orientTransverse:
+		return h - 1 - y, w - 1 - x
+	case orientRotate270:
+		return y, w - 1 - x
+	}
+	return x, y
+}
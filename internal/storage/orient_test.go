@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrientPoint(t *testing.T) {
+	// Corners of a 3×2 stored image and where each lands upright
+	const w, h = 3, 2
+	corners := [4][2]int{{0, 0}, {w - 1, 0}, {0, h - 1}, {w - 1, h - 1}}
+	tests := []struct {
+		orientation int
+		want        [4][2]int // Top-left, top-right, bottom-left, bottom-right
+	}{
+		{orientNormal, [4][2]int{{0, 0}, {2, 0}, {0, 1}, {2, 1}}},
+		{orientFlipH, [4][2]int{{2, 0}, {0, 0}, {2, 1}, {0, 1}}},
+		{orientRotate180, [4][2]int{{2, 1}, {0, 1}, {2, 0}, {0, 0}}},
+		{orientFlipV, [4][2]int{{0, 1}, {2, 1}, {0, 0}, {2, 0}}},
+		{orientTranspose, [4][2]int{{0, 0}, {0, 2}, {1, 0}, {1, 2}}},
+		{orientRotate90, [4][2]int{{1, 0}, {1, 2}, {0, 0}, {0, 2}}},
+		{orientTransverse, [4][2]int{{1, 2}, {1, 0}, {0, 2}, {0, 0}}},
+		{orientRotate270, [4][2]int{{0, 2}, {0, 0}, {1, 2}, {1, 0}}},
+	}
+	for _, tt := range tests {
+		for i, c := range corners {
+			x, y := orientPoint(tt.orientation, c[0], c[1], w, h)
+			if [2]int{x, y} != tt.want[i] {
+				t.Errorf("orientation %d: (%d,%d) -> (%d,%d), want %v", tt.orientation, c[0], c[1], x, y, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// A 3×2 image with a marked top-left pixel
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	mark := color.RGBA{255, 0, 0, 255}
+	src.Set(0, 0, mark)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		markX       int
+		markY       int
+	}{
+		{0, 3, 2, 0, 0}, // Absent
+		{orientNormal, 3, 2, 0, 0},
+		{orientFlipH, 3, 2, 2, 0},
+		{orientRotate180, 3, 2, 2, 1},
+		{orientFlipV, 3, 2, 0, 1},
+		{orientTranspose, 2, 3, 0, 0},
+		{orientRotate90, 2, 3, 1, 0},
+		{orientTransverse, 2, 3, 1, 2},
+		{orientRotate270, 2, 3, 0, 2},
+		{9, 3, 2, 0, 0}, // Unknown
+	}
+	for _, tt := range tests {
+		got := orientImage(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.markX, tt.markY)); c != mark {
+			t.Errorf("orientation %d: pixel (%d,%d) = %v, want the mark", tt.orientation, tt.markX, tt.markY, c)
+		}
+	}
+}
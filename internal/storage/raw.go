@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// errNoRawPreview is returned for RAW files without a usable embedded preview.
+var errNoRawPreview = errors.New("raw: no embedded JPEG preview")
+
+// rawExts are the camera RAW formats thumbnails are made for, from the JPEG
+// preview the camera embeds rather than from the sensor data.
+var rawExts = map[string]bool{
+	".cr2": true, // Canon (TIFF)
+	".cr3": true, // Canon (ISO base media)
+	".nef": true, // Nikon (TIFF)
+	".arw": true, // Sony (TIFF)
+	".dng": true, // Adobe DNG (TIFF)
+}
+
+// isRawExt reports whether ext (lowercase, with dot) is a camera RAW format.
+func isRawExt(ext string) bool {
+	return rawExts[ext]
+}
+
+// TIFF tags that point at embedded images.
+const (
+	tagCompression      = 0x0103
+	tagStripOffsets     = 0x0111
+	tagStripByteCounts  = 0x0117
+	tagSubIFDs          = 0x014A
+	tagJPEGOffset       = 0x0201 // JPEGInterchangeFormat
+	tagJPEGLength       = 0x0202 // JPEGInterchangeFormatLength
+	tiffCompressionJPEG = 7
+	tiffCompressionOJPG = 6 // Old-style JPEG, used by CR2
+)
+
+// maxRawIFDs bounds how many IFDs are followed in one file, so a crafted
+// chain of offsets cannot keep the walk going.
+const maxRawIFDs = 64
+
+// rawJPEG is a JPEG stream embedded in a RAW file.
+type rawJPEG struct {
+	off, n int64
+	w, h   int
+}
+
+// better reports whether j is a better source than o for a thumbnail of
+// maxSize: big enough beats too small; among big enough ones the smaller is
+// cheaper to decode, among too small ones the larger is sharper. For full
+// size (maxSize 0) the largest wins.
+func (j rawJPEG) better(o rawJPEG, maxSize int) bool {
+	jl, ol := max(j.w, j.h), max(o.w, o.h)
+	if maxSize <= 0 {
+		return jl > ol
+	}
+	if (jl >= maxSize) != (ol >= maxSize) {
+		return jl >= maxSize
+	}
+	if jl >= maxSize {
+		return jl < ol
+	}
+	return jl > ol
+}
+
+// rawPreview returns the embedded JPEG of a RAW file best suited to a
+// thumbnail of maxSize, as a section of r. TIFF-based files (CR2, NEF, ARW,
+// DNG) list their previews in IFDs; CR3 keeps them in PRVW and THMB boxes.
+// A TIFF-based file without a JPEG preview is returned whole, for the TIFF
+// decoder to try its first image (DNG thumbnails are often uncompressed).
+func rawPreview(r io.ReadSeeker, maxSize int) (io.ReadSeeker, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	ra := &seekReaderAt{r: r}
+	head := make([]byte, 12)
+	if _, err := ra.ReadAt(head, 0); err != nil {
+		return nil, errNoRawPreview
+	}
+
+	var found []rawJPEG
+	isTIFF := isTIFFHeader(head)
+	switch {
+	case isTIFF:
+		found = tiffJPEGs(ra, size)
+	case string(head[4:8]) == "ftyp":
+		found = cr3JPEGs(ra, size)
+	}
+
+	var best *rawJPEG
+	for i := range found {
+		j := &found[i]
+		if !jpegDims(ra, j) {
+			continue // Lossless raw data, or not a JPEG at all
+		}
+		if best == nil || j.better(*best, maxSize) {
+			best = j
+		}
+	}
+	if best == nil {
+		if isTIFF {
+			_, err := r.Seek(0, io.SeekStart)
+			return r, err
+		}
+		return nil, errNoRawPreview
+	}
+	return io.NewSectionReader(ra, best.off, best.n), nil
+}
+
+// seekReaderAt reads at offsets of a stream by seeking. Not safe for
+// concurrent use, which a single thumbnail decode does not need.
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+// ifdEntry is one 12-byte IFD entry; value holds the value or its offset.
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// tiffJPEGs walks every IFD of a TIFF-based RAW file (the IFD chain and
+// SubIFDs) and lists the JPEG streams they point at: JPEGInterchangeFormat
+// blocks, and JPEG-compressed images stored as a single strip.
+func tiffJPEGs(ra io.ReaderAt, size int64) []rawJPEG {
+	hdr := make([]byte, 8)
+	if _, err := ra.ReadAt(hdr, 0); err != nil {
+		return nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if hdr[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	var found []rawJPEG
+	seen := make(map[uint32]bool)
+	queue := []uint32{order.Uint32(hdr[4:])}
+	for len(queue) > 0 && len(seen) < maxRawIFDs {
+		off := queue[0]
+		queue = queue[1:]
+		if off == 0 || int64(off) >= size || seen[off] {
+			continue
+		}
+		seen[off] = true
+		entries, next := readIFD(ra, order, off)
+		queue = append(queue, next)
+
+		var jpegOff, jpegLen, compression uint32
+		var strips, counts []uint32
+		for _, e := range entries {
+			switch e.tag {
+			case tagJPEGOffset:
+				jpegOff = firstValue(ra, order, e)
+			case tagJPEGLength:
+				jpegLen = firstValue(ra, order, e)
+			case tagCompression:
+				compression = firstValue(ra, order, e)
+			case tagStripOffsets:
+				strips = ifdValues(ra, order, e)
+			case tagStripByteCounts:
+				counts = ifdValues(ra, order, e)
+			case tagSubIFDs:
+				queue = append(queue, ifdValues(ra, order, e)...)
+			}
+		}
+		if jpegOff > 0 && jpegLen > 0 {
+			found = append(found, rawJPEG{off: int64(jpegOff), n: int64(jpegLen)})
+		}
+		if (compression == tiffCompressionJPEG || compression == tiffCompressionOJPG) && len(strips) == 1 && len(counts) == 1 {
+			found = append(found, rawJPEG{off: int64(strips[0]), n: int64(counts[0])})
+		}
+	}
+	return found
+}
+
+// readIFD reads the entries of the IFD at off and the offset of the next one.
+func readIFD(ra io.ReaderAt, order binary.ByteOrder, off uint32) ([]ifdEntry, uint32) {
+	nb := make([]byte, 2)
+	if _, err := ra.ReadAt(nb, int64(off)); err != nil {
+		return nil, 0
+	}
+	n := int(order.Uint16(nb))
+	if n == 0 || n > 1000 {
+		return nil, 0
+	}
+	buf := make([]byte, n*12+4)
+	if _, err := ra.ReadAt(buf, int64(off)+2); err != nil {
+		return nil, 0
+	}
+	entries := make([]ifdEntry, n)
+	for i := range entries {
+		e := buf[i*12:]
+		entries[i] = ifdEntry{order.Uint16(e), order.Uint16(e[2:]), order.Uint32(e[4:]), e[8:12]}
+	}
+	return entries, order.Uint32(buf[n*12:])
+}
+
+// maxIFDValues bounds the values read from one entry (strips, SubIFDs).
+const maxIFDValues = 16
+
+// ifdValues returns the SHORT, LONG or IFD values of an entry, read from
+// its offset when they do not fit inline. Entries with more values than
+// maxIFDValues give none.
+func ifdValues(ra io.ReaderAt, order binary.ByteOrder, e ifdEntry) []uint32 {
+	width := 4
+	switch e.typ {
+	case 3: // SHORT
+		width = 2
+	case 4, 13: // LONG, IFD
+	default:
+		return nil
+	}
+	if e.count == 0 || e.count > maxIFDValues {
+		return nil
+	}
+	data := e.value
+	if need := int(e.count) * width; need > 4 {
+		data = make([]byte, need)
+		if _, err := ra.ReadAt(data, int64(order.Uint32(e.value))); err != nil {
+			return nil
+		}
+	}
+	vals := make([]uint32, e.count)
+	for i := range vals {
+		if width == 2 {
+			vals[i] = uint32(order.Uint16(data[i*2:]))
+		} else {
+			vals[i] = order.Uint32(data[i*4:])
+		}
+	}
+	return vals
+}
+
+func firstValue(ra io.ReaderAt, order binary.ByteOrder, e ifdEntry) uint32 {
+	if vals := ifdValues(ra, order, e); len(vals) > 0 {
+		return vals[0]
+	}
+	return 0
+}
+
+// jpegDims checks that j starts a JPEG a thumbnail can be made from (baseline,
+// extended or progressive, not the lossless JPEG RAW data uses) and fills in
+// its dimensions from the frame header.
+func jpegDims(ra io.ReaderAt, j *rawJPEG) bool {
+	b := make([]byte, 9)
+	if _, err := ra.ReadAt(b[:2], j.off); err != nil || b[0] != 0xFF || b[1] != 0xD8 {
+		return false
+	}
+	pos, end := j.off+2, j.off+j.n
+	for i := 0; i < 64 && pos+4 <= end; i++ {
+		if _, err := ra.ReadAt(b[:4], pos); err != nil || b[0] != 0xFF {
+			return false
+		}
+		switch m := b[1]; {
+		case m == 0xFF: // Fill byte
+			pos++
+			continue
+		case m == 0xC0 || m == 0xC1 || m == 0xC2:
+			if _, err := ra.ReadAt(b, pos+4); err != nil {
+				return false
+			}
+			j.h, j.w = int(binary.BigEndian.Uint16(b[1:])), int(binary.BigEndian.Uint16(b[3:]))
+			return j.w > 0 && j.h > 0
+		case m >= 0xC3 && m <= 0xCF && m != 0xC4 && m != 0xC8 && m != 0xCC, m == 0xDA, m == 0xD9:
+			return false // Lossless or arithmetic frame, or no frame header
+		}
+		pos += 2 + int64(binary.BigEndian.Uint16(b[2:]))
+	}
+	return false
+}
+
+// Canon CR3 boxes: the moov box holds a uuid box with the metadata (CMT1-4)
+// and a 160×120 thumbnail (THMB); a top-level uuid box holds the larger
+// preview (PRVW, about 1620×1080).
+var (
+	cr3MetaUUID    = []byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+	cr3PreviewUUID = []byte{0xea, 0xf4, 0x2b, 0x5e, 0x1c, 0x98, 0x4b, 0x88, 0xb9, 0xfb, 0xb7, 0xdc, 0x40, 0x6e, 0x4d, 0x16}
+)
+
+// bmffBox is an ISO base media box; start and end bound its payload (after
+// the UUID for uuid boxes).
+type bmffBox struct {
+	typ        string
+	uuid       []byte
+	start, end int64
+}
+
+// bmffBoxes lists the boxes between start and end.
+func bmffBoxes(ra io.ReaderAt, start, end int64) []bmffBox {
+	var boxes []bmffBox
+	hdr := make([]byte, 16)
+	for pos := start; pos+8 <= end && len(boxes) < 256; {
+		if _, err := ra.ReadAt(hdr[:8], pos); err != nil {
+			break
+		}
+		size, typ, hlen := int64(binary.BigEndian.Uint32(hdr)), string(hdr[4:8]), int64(8)
+		switch size {
+		case 0: // Up to the end
+			size = end - pos
+		case 1: // 64-bit size follows
+			if _, err := ra.ReadAt(hdr[8:16], pos+8); err != nil {
+				return boxes
+			}
+			size, hlen = int64(binary.BigEndian.Uint64(hdr[8:16])), 16
+		}
+		if size < hlen || pos+size > end {
+			break
+		}
+		box := bmffBox{typ: typ, start: pos + hlen, end: pos + size}
+		if typ == "uuid" && box.start+16 <= box.end {
+			box.uuid = make([]byte, 16)
+			if _, err := ra.ReadAt(box.uuid, box.start); err != nil {
+				break
+			}
+			box.start += 16
+		}
+		boxes = append(boxes, box)
+		pos += size
+	}
+	return boxes
+}
+
+// findBox returns the first box of type typ (and uuid, for uuid boxes).
+func findBox(boxes []bmffBox, typ string, uuid []byte) (bmffBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ && (uuid == nil || bytes.Equal(b.uuid, uuid)) {
+			return b, true
+		}
+	}
+	return bmffBox{}, false
+}
+
+// isCR3 reports whether head starts a Canon CR3 file (ftyp brand "crx ").
+func isCR3(head []byte) bool {
+	return len(head) >= 12 && string(head[4:8]) == "ftyp" && string(head[8:12]) == "crx "
+}
+
+// cr3JPEGs lists the PRVW and THMB JPEGs of a CR3 file.
+func cr3JPEGs(ra io.ReaderAt, size int64) []rawJPEG {
+	var found []rawJPEG
+	top := bmffBoxes(ra, 0, size)
+	if moov, ok := findBox(top, "moov", nil); ok {
+		if meta, ok := findBox(bmffBoxes(ra, moov.start, moov.end), "uuid", cr3MetaUUID); ok {
+			if thmb, ok := findBox(bmffBoxes(ra, meta.start, meta.end), "THMB", nil); ok {
+				found = appendBoxJPEG(found, ra, thmb)
+			}
+		}
+	}
+	if prev, ok := findBox(top, "uuid", cr3PreviewUUID); ok {
+		// The PRVW box follows 8 bytes of header in the uuid payload
+		if prvw, ok := findBox(bmffBoxes(ra, prev.start+8, prev.end), "PRVW", nil); ok {
+			found = appendBoxJPEG(found, ra, prvw)
+		}
+	}
+	return found
+}
+
+// appendBoxJPEG adds the JPEG that follows a few header fields (size,
+// dimensions) in a THMB or PRVW box and runs to the box's end.
+func appendBoxJPEG(found []rawJPEG, ra io.ReaderAt, box bmffBox) []rawJPEG {
+	buf := make([]byte, min(32, box.end-box.start))
+	if _, err := ra.ReadAt(buf, box.start); err != nil {
+		return found
+	}
+	if i := bytes.Index(buf, []byte{0xFF, 0xD8, 0xFF}); i >= 0 {
+		found = append(found, rawJPEG{off: box.start + int64(i), n: box.end - box.start - int64(i)})
+	}
+	return found
+}
+
+// readCR3Exif reads the EXIF fields of a CR3 file, whose metadata is split
+// over two TIFF structures in the moov box: CMT1 holds IFD0 (orientation,
+// DateTime) and CMT2 the EXIF IFD (DateTimeOriginal).
+func readCR3Exif(buf []byte) (exifInfo, error) {
+	ra := bytes.NewReader(buf)
+	moov, ok := findBox(bmffBoxes(ra, 0, int64(len(buf))), "moov", nil)
+	if !ok {
+		return exifInfo{}, errNoExif
+	}
+	meta, ok := findBox(bmffBoxes(ra, moov.start, moov.end), "uuid", cr3MetaUUID)
+	if !ok {
+		return exifInfo{}, errNoExif
+	}
+	boxes := bmffBoxes(ra, meta.start, meta.end)
+	cmt1, ok := findBox(boxes, "CMT1", nil)
+	if !ok {
+		return exifInfo{}, errNoExif
+	}
+	info, err := parseTIFF(buf[cmt1.start:cmt1.end])
+	if err != nil {
+		return info, err
+	}
+
+	if cmt2, ok := findBox(boxes, "CMT2", nil); ok {
+		b := buf[cmt2.start:cmt2.end]
+		if isTIFFHeader(b) {
+			var order binary.ByteOrder = binary.LittleEndian
+			if b[0] == 'M' {
+				order = binary.BigEndian
+			}
+			walkIFD(b, order, order.Uint32(b[4:]), func(tag, typ uint16, count uint32, value []byte) {
+				if tag != tagDateTimeOriginal {
+					return
+				}
+				if t, err := parseExifTime(tiffString(b, order, typ, count, value)); err == nil {
+					info.Taken = t
+				}
+			})
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// bmff builds an ISO base media box.
+func bmff(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, typ...), body...)
+}
+
+// testCR3 builds a Canon CR3 file with the given metadata boxes and
+// previews. nil leaves a box out.
+func testCR3(cmt1, cmt2, thmb, prvw []byte) []byte {
+	var meta [][]byte
+	meta = append(meta, cr3MetaUUID)
+	if cmt1 != nil {
+		meta = append(meta, bmff("CMT1", cmt1))
+	}
+	if cmt2 != nil {
+		meta = append(meta, bmff("CMT2", cmt2))
+	}
+	if thmb != nil {
+		meta = append(meta, bmff("THMB", make([]byte, 16), thmb)) // Version, size, dimensions
+	}
+	file := bmff("ftyp", []byte("crx \x00\x00\x00\x01crx isom"))
+	file = append(file, bmff("moov", bmff("uuid", meta...))...)
+	if prvw != nil {
+		file = append(file, bmff("uuid", cr3PreviewUUID, make([]byte, 8), bmff("PRVW", make([]byte, 16), prvw))...)
+	}
+	return append(file, bmff("mdat", make([]byte, 64))...)
+}
+
+// testTIFFRaw builds a TIFF-based RAW file with a small JPEG referenced by
+// JPEGInterchangeFormat in IFD0 and a large one stored as the single strip of
+// a JPEG-compressed SubIFD, as in CR2, NEF and ARW files.
+func testTIFFRaw(order tiffOrder, small, large []byte) []byte {
+	// Image data follows the IFDs, so lay them out once to learn where
+	ifds := func(smallOff, largeOff uint32) [][]tiffField {
+		return [][]tiffField{
+			{
+				{tag: tagSubIFDs, typ: 4, value: subIFD + 1},
+				{tag: tagJPEGOffset, typ: 4, value: smallOff},
+				{tag: tagJPEGLength, typ: 4, value: uint32(len(small))},
+			},
+			{
+				{tag: tagCompression, typ: 3, value: tiffCompressionJPEG},
+				{tag: tagStripOffsets, typ: 4, value: largeOff},
+				{tag: tagStripByteCounts, typ: 4, value: uint32(len(large))},
+			},
+		}
+	}
+	data := append(append([]byte{}, small...), large...)
+	_, off := buildTIFF(order, ifds(0, 0), data)
+	b, _ := buildTIFF(order, ifds(off, off+uint32(len(small))), data)
+	return b
+}
+
+func TestRawPreview(t *testing.T) {
+	small, large := testJPEG(t, 160, 120), testJPEG(t, 640, 480)
+	tiffLE := testTIFFRaw(binary.LittleEndian, small, large)
+	tiffBE := testTIFFRaw(binary.BigEndian, small, large)
+	cr3 := testCR3(exifTIFF(binary.LittleEndian, 1, "", ""), nil, small, large)
+	thmbOnly := testCR3(nil, nil, small, nil)
+	noJPEG, _ := buildTIFF(binary.LittleEndian, [][]tiffField{{{tag: tagCompression, typ: 3, value: 1}}}, nil)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		maxSize int
+		width   int // Width of the preview picked, 0 for the whole file
+		err     error
+	}{
+		{"tiff smallest big enough", tiffLE, 120, 160, nil},
+		{"tiff big endian", tiffBE, 120, 160, nil},
+		{"tiff larger when small is too small", tiffLE, 320, 640, nil},
+		{"tiff largest when none is big enough", tiffLE, 2000, 640, nil},
+		{"tiff full size", tiffLE, 0, 640, nil},
+		{"cr3 thumbnail", cr3, 100, 160, nil},
+		{"cr3 preview", cr3, 400, 640, nil},
+		{"cr3 without preview", thmbOnly, 400, 160, nil},
+		{"tiff without jpeg is returned whole", noJPEG, 200, 0, nil},
+		{"not a raw file", []byte("not a raw file at all"), 200, 0, errNoRawPreview},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := rawPreview(bytes.NewReader(tt.data), tt.maxSize)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.width == 0 {
+				if !bytes.Equal(got, tt.data) {
+					t.Errorf("got %d bytes, want the whole %d-byte file", len(got), len(tt.data))
+				}
+				return
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("preview is not a JPEG: %v", err)
+			}
+			if cfg.Width != tt.width {
+				t.Errorf("picked the %d px preview, want %d px", cfg.Width, tt.width)
+			}
+		})
+	}
+}
+
+func TestJPEGDimsRejectsLossless(t *testing.T) {
+	// A lossless (SOF3) frame, as CR2 and DNG store the sensor data
+	lossless := []byte{0xFF, 0xD8, 0xFF, 0xC3, 0x00, 0x0B, 8, 0x01, 0x00, 0x01, 0x00, 1, 1, 0x11, 0}
+	j := rawJPEG{off: 0, n: int64(len(lossless))}
+	if jpegDims(bytes.NewReader(lossless), &j) {
+		t.Error("lossless JPEG accepted as a preview")
+	}
+}
@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3Backend.
+type S3Config struct {
+	Endpoint  string // Base URL, e.g. http://minio.lan:9000
+	Region    string // Signing region (MinIO accepts us-east-1)
+	Bucket    string
+	Prefix    string // Key prefix acting as the root ("" = whole bucket)
+	AccessKey string
+	SecretKey string
+}
+
+// S3Backend stores files in an S3-compatible bucket using path-style requests
+// signed with AWS Signature V4. Directories are key prefixes; Mkdir writes an
+// empty "dir/" marker so empty folders survive.
+type S3Backend struct {
+	cfg      S3Config
+	endpoint *url.URL
+	prefix   string // Normalized: "" or ends with "/"
+	client   *http.Client
+}
+
+// emptySHA256 is the hex SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3Backend creates an S3 backend.
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	b := &S3Backend{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{},
+	}
+	b.prefix = normalizePrefix(cfg.Prefix)
+	return b, nil
+}
+
+// WithPrefix returns a backend on the same bucket rooted at another key prefix
+// (e.g. a trash area next to the browsable tree).
+func (b *S3Backend) WithPrefix(prefix string) *S3Backend {
+	sub := *b
+	sub.cfg.Prefix = prefix
+	sub.prefix = normalizePrefix(prefix)
+	return &sub
+}
+
+// normalizePrefix turns "a/b" or "/a/b/" into "a/b/" and "" or "/" into "".
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// key maps a backend path to an object key.
+func (b *S3Backend) key(p string) string {
+	return b.prefix + p
+}
+
+// dirPrefix maps a backend directory path to the key prefix of its children.
+func (b *S3Backend) dirPrefix(p string) string {
+	if p == "" {
+		return b.prefix
+	}
+	return b.prefix + p + "/"
+}
+
+// List returns the contents of a directory.
+func (b *S3Backend) List(p string) ([]FileInfo, error) {
+	prefix := b.dirPrefix(p)
+	var files []FileInfo
+	found := p == ""
+
+	err := b.listObjects(prefix, "/", func(obj s3Object) {
+		found = true
+		if obj.Key == prefix {
+			return // Directory marker
+		}
+		files = append(files, newFileInfo(strings.TrimPrefix(obj.Key, b.prefix), obj.Size, obj.LastModified, false))
+	}, func(dir string) {
+		found = true
+		files = append(files, newFileInfo(strings.TrimSuffix(strings.TrimPrefix(dir, b.prefix), "/"), 0, time.Time{}, true))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fs.ErrNotExist
+	}
+	return files, nil
+}
+
+// Open opens an object for reading; reads are ranged GETs so Seek is cheap.
+func (b *S3Backend) Open(p string) (File, error) {
+	info, err := b.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, errIsDir
+	}
+	return &s3File{backend: b, key: b.key(p), info: *info}, nil
+}
+
+// Create buffers data in a local temp file and uploads it on Close, so the
+// object appears all at once with a known Content-Length.
+func (b *S3Backend) Create(p string) (Writer, error) {
+	tmp, err := os.CreateTemp("", "s3-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{File: tmp, backend: b, key: b.key(p)}, nil
+}
+
+// Stat returns info for an object, or for a "directory" if keys exist beneath it.
+func (b *S3Backend) Stat(p string) (*FileInfo, error) {
+	if p == "" {
+		info := newFileInfo(p, 0, time.Time{}, true)
+		return &info, nil
+	}
+
+	resp, err := b.do(http.MethodHead, b.key(p), nil, nil, nil, 0)
+	if err == nil {
+		resp.Body.Close()
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		info := newFileInfo(p, size, modTime, false)
+		return &info, nil
+	}
+	if err != fs.ErrNotExist {
+		return nil, err
+	}
+
+	// No object: it is a directory if anything lives under the prefix
+	query := url.Values{"list-type": {"2"}, "prefix": {b.dirPrefix(p)}, "max-keys": {"1"}}
+	var result s3ListResult
+	if err := b.doXML(http.MethodGet, "", query, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	info := newFileInfo(p, 0, time.Time{}, true)
+	return &info, nil
+}
+
+// Delete removes an object and every key beneath it.
+func (b *S3Backend) Delete(p string) error {
+	if p != "" {
+		if err := b.deleteObject(b.key(p)); err != nil {
+			return err
+		}
+	}
+
+	var keys []string
+	if err := b.listObjects(b.dirPrefix(p), "", func(obj s3Object) {
+		keys = append(keys, obj.Key)
+	}, nil); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.deleteObject(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies an object (or every key under a prefix) server-side and deletes the source.
+func (b *S3Backend) Rename(oldPath, newPath string) error {
+	if err := b.Copy(oldPath, newPath); err != nil {
+		return err
+	}
+	return b.Delete(oldPath)
+}
+
+// Copy copies an object, or every key under a prefix, server-side.
+func (b *S3Backend) Copy(srcPath, dstPath string) error {
+	info, err := b.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return b.copyObject(b.key(srcPath), b.key(dstPath))
+	}
+
+	srcPrefix, dstPrefix := b.dirPrefix(srcPath), b.dirPrefix(dstPath)
+	var keys []string
+	if err := b.listObjects(srcPrefix, "", func(obj s3Object) {
+		keys = append(keys, obj.Key)
+	}, nil); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return b.Mkdir(dstPath)
+	}
+	for _, key := range keys {
+		if err := b.copyObject(key, dstPrefix+strings.TrimPrefix(key, srcPrefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mkdir writes an empty "dir/" marker object.
+func (b *S3Backend) Mkdir(p string) error {
+	if p == "" {
+		return nil
+	}
+	resp, err := b.do(http.MethodPut, b.dirPrefix(p), nil, nil, strings.NewReader(""), 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Object is one entry of a ListObjectsV2 response.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// s3ListResult is a ListObjectsV2 response.
+type s3ListResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listObjects pages through ListObjectsV2. With delimiter "/" only direct
+// children are returned and sub-"directories" go to onPrefix.
+func (b *S3Backend) listObjects(prefix, delimiter string, onObject func(s3Object), onPrefix func(string)) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		var result s3ListResult
+		if err := b.doXML(http.MethodGet, "", query, &result); err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			onObject(obj)
+		}
+		if onPrefix != nil {
+			for _, cp := range result.CommonPrefixes {
+				onPrefix(cp.Prefix)
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// deleteObject deletes a single key (missing keys are not an error in S3).
+func (b *S3Backend) deleteObject(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, nil, nil, 0)
+	if err == fs.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// copyObject copies srcKey to dstKey within the bucket.
+func (b *S3Backend) copyObject(srcKey, dstKey string) error {
+	headers := map[string]string{
+		"x-amz-copy-source": "/" + b.cfg.Bucket + "/" + s3Escape(srcKey, false),
+	}
+	resp, err := b.do(http.MethodPut, dstKey, nil, headers, strings.NewReader(""), 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// doXML performs a bucket-level request and decodes the XML response.
+func (b *S3Backend) doXML(method, key string, query url.Values, out interface{}) error {
+	resp, err := b.do(method, key, query, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a signed request. A nil body sends no payload; a non-nil body is
+// sent unsigned (UNSIGNED-PAYLOAD) with the given length. 404 maps to fs.ErrNotExist.
+func (b *S3Backend) do(method, key string, query url.Values, headers map[string]string, body io.Reader, length int64) (*http.Response, error) {
+	uri := "/" + b.cfg.Bucket
+	if key != "" {
+		uri += "/" + s3Escape(key, false)
+	}
+	rawQuery := canonicalQuery(query)
+
+	reqURL := b.endpoint.Scheme + "://" + b.endpoint.Host + uri
+	if rawQuery != "" {
+		reqURL += "?" + rawQuery
+	}
+
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptySHA256
+	if body != nil {
+		req.ContentLength = length
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	b.sign(req, uri, rawQuery, payloadHash, headers, time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fs.ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature V4 headers to req, dated now.
+func (b *S3Backend) sign(req *http.Request, uri, rawQuery, payloadHash string, extra map[string]string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	for k, v := range extra {
+		signed[strings.ToLower(k)] = v
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(signed[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, uri, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + b.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.cfg.SecretKey), day)
+	key = hmacSHA256(key, b.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything except unreserved characters
+// (and "/" unless encodeSlash), per the SigV4 URI encoding rules.
+func s3Escape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3File is the File returned by S3Backend.Open. Each read continues a ranged
+// GET from the current offset; Seek drops the open body.
+type s3File struct {
+	backend *S3Backend
+	key     string
+	info    FileInfo
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-", f.offset)}
+		resp, err := f.backend.do(http.MethodGet, f.key, nil, headers, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.info.Size + offset
+	default:
+		return 0, fmt.Errorf("s3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("s3: negative position")
+	}
+	if abs != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = abs
+	return abs, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return fileStat{f.info}, nil }
+
+// s3Writer is the Writer returned by S3Backend.Create.
+type s3Writer struct {
+	*os.File
+	backend *S3Backend
+	key     string
+	done    bool
+}
+
+// Close uploads the buffered temp file with a single PUT.
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	defer os.Remove(w.File.Name())
+	defer w.File.Close()
+
+	size, err := w.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := w.backend.do(http.MethodPut, w.key, nil, nil, w.File, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Abort discards the temp file.
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
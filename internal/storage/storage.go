@@ -1,184 +1,375 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
-// Storage provides safe file operations within a root directory.
+// Storage provides safe file operations within a root, on top of a Backend driver.
+// It validates every path (no traversal) before the driver sees it.
 type Storage struct {
-	root string
-	puid int
-	pgid int
+	backend   Backend
+	trash     Backend // Trash area outside the browsable tree (nil = trash disabled)
+	versions  Backend // Previous versions of overwritten files (nil = versioning disabled)
+	onVersion func(VersionEntry)
+	onTrash   func(TrashEntry)
+	listeners listeners
+
+	maxNameBytes int // Longest name written (0 = DefaultMaxNameBytes)
+	onNameChange func(NameChange)
+
+	exif     exifCache  // EXIF fields for ListPage and thumbnails
+	hide     HidePolicy // Names kept out of view ("" = HideJunk)
+	reserved []string   // App data inside the tree, never shown or touched (see Reserve)
+
+	thumbs       *ThumbCache        // Generated thumbnails (nil = not cached)
+	thumbFlight  singleflight.Group // One decode per thumbnail, however many ask
+	decodeSlots  chan struct{}      // Limits concurrent decodes (nil = unlimited)
+	decodeBudget *memoryBudget      // Memory held by decodes at once (nil = unlimited)
+	maxPixels    int64              // Largest image decoded (0 = unlimited)
 }
 
+var (
+	// ErrOutsideRoot is returned for paths (or symlink targets) outside root.
+	ErrOutsideRoot = errors.New("path outside root directory")
+	// ErrSymlink is returned for symlinks when the policy is SymlinkDeny.
+	ErrSymlink = errors.New("symlinks are not allowed")
+)
+
 // FileInfo represents file metadata.
 type FileInfo struct {
 	Name    string
 	Size    int64
 	ModTime time.Time
 	IsDir   bool
-	Ext     string // File extension (e.g., ".jpg", ".pdf")
+	Ext     string    // File extension (e.g., ".jpg", ".pdf")
+	TakenAt time.Time // EXIF capture date (only set by ListPage when sorting by SortTaken)
+}
+
+// IsImage reports whether thumbnails can be made for the file.
+func (f FileInfo) IsImage() bool {
+	return !f.IsDir && isImageExt(f.Ext)
+}
+
+// IsRaw reports whether the file is a camera RAW file, whose thumbnails come
+// from the preview embedded in it.
+func (f FileInfo) IsRaw() bool {
+	return !f.IsDir && isRawExt(f.Ext)
 }
 
-// New creates a new Storage instance.
+// New creates a new Storage instance on the local filesystem.
 func New(root string, puid, pgid int) *Storage {
-	return &Storage{
-		root: filepath.Clean(root),
-		puid: puid,
-		pgid: pgid,
-	}
+	return NewWithBackend(NewLocalBackend(root, puid, pgid))
 }
 
-// resolvePath validates and resolves a path to an absolute path within root.
+// NewWithBackend creates a new Storage instance on the given driver.
+func NewWithBackend(b Backend) *Storage {
+	return &Storage{backend: b}
+}
+
+// Backend returns the underlying driver.
+func (s *Storage) Backend() Backend {
+	return s.backend
+}
+
+// resolvePath validates and cleans a path into the form backends expect:
+// slash-separated, relative to root, no leading slash ("" = root).
 // Accepts both relative paths and paths with leading slashes (treated as relative to root).
+// Reserved paths (see Reserve) give ErrHidden.
 func (s *Storage) resolvePath(relPath string) (string, error) {
+	p, err := normalizePath(relPath)
+	if err != nil {
+		return "", err
+	}
+	if s.isReserved(p) {
+		return "", ErrHidden
+	}
+	return p, nil
+}
+
+// normalizePath is resolvePath without the reserved check.
+func normalizePath(relPath string) (string, error) {
 	// Clean and normalize the path
-	cleaned := filepath.Clean(relPath)
-	
+	cleaned := filepath.ToSlash(filepath.Clean(relPath))
+
 	// Remove leading slash if present (treat as relative to root)
 	// This allows paths like "/customer" and "customer" to work identically
 	cleaned = strings.TrimPrefix(cleaned, "/")
 	cleaned = strings.TrimPrefix(cleaned, "\\") // Windows support
-	
+
 	// Handle empty path (root)
 	if cleaned == "." || cleaned == "" {
-		cleaned = ""
+		return "", nil
 	}
-	
-	// Check for path traversal attempts
-	if strings.Contains(cleaned, "..") {
-		return "", fmt.Errorf("path traversal not allowed")
+
+	// Check for path traversal attempts (".." components, not names like "a..b.jpg")
+	for _, part := range strings.Split(cleaned, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path traversal not allowed")
+		}
 	}
-	
-	// Join with root
-	absPath := filepath.Join(s.root, cleaned)
-	absPath = filepath.Clean(absPath)
-	
-	// Verify result is within root (prevent traversal)
-	rootClean := filepath.Clean(s.root)
-	if !strings.HasPrefix(absPath, rootClean) {
-		return "", fmt.Errorf("path outside root directory")
+
+	return cleaned, nil
+}
+
+// IsInternal reports whether relPath belongs to the app's own bookkeeping
+// (thumbnail cache, in-flight upload temp files) rather than user content.
+func IsInternal(relPath string) bool {
+	p := strings.Trim(filepath.ToSlash(relPath), "/")
+	if first, _, _ := strings.Cut(p, "/"); first == thumbCacheDir {
+		return true
 	}
-	
-	return absPath, nil
+	return isUploadTemp(path.Base(p))
 }
 
-// List returns the contents of a directory.
-func (s *Storage) List(relPath string) ([]FileInfo, error) {
-	absPath, err := s.resolvePath(relPath)
+// isUploadTemp reports whether name is a temp file of an in-flight write.
+func isUploadTemp(name string) bool {
+	return strings.HasPrefix(name, ".upload-") && strings.HasSuffix(name, ".tmp")
+}
+
+// Within reports whether relPath, with all symlinks resolved, lies inside dir
+// (also resolved). Used to keep share links inside the shared folder even when
+// the symlink policy allows links elsewhere under root.
+func (s *Storage) Within(relPath, dir string) bool {
+	p, err := s.resolvePath(relPath)
 	if err != nil {
-		return nil, err
+		return false
 	}
-
-	entries, err := os.ReadDir(absPath)
+	base, err := s.resolvePath(dir)
 	if err != nil {
-		return nil, err
+		return false
 	}
 
-	var files []FileInfo
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
+	if rp, ok := s.backend.(realPather); ok {
+		if p, err = rp.RealPath(p); err != nil {
+			return false
 		}
+		if base, err = rp.RealPath(base); err != nil {
+			return false
+		}
+		return isWithin(p, base)
+	}
+	return base == "" || p == base || strings.HasPrefix(p, base+"/")
+}
 
-		// Extract file extension (lowercase)
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-
-		files = append(files, FileInfo{
-			Name:    entry.Name(),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
-			IsDir:   entry.IsDir(),
-			Ext:     ext,
-		})
+// List returns the contents of a directory, without entries hidden by the
+// hide policy.
+func (s *Storage) List(relPath string) ([]FileInfo, error) {
+	p, err := s.visiblePath(relPath)
+	if err != nil {
+		return nil, err
 	}
 
-	return files, nil
+	files, err := s.backend.List(p)
+	if err != nil {
+		return nil, err
+	}
+	return s.visible(p, files), nil
 }
 
 // Read reads a file and returns its contents.
 func (s *Storage) Read(relPath string) ([]byte, error) {
-	absPath, err := s.resolvePath(relPath)
+	f, err := s.Open(relPath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	return os.ReadFile(absPath)
+	return io.ReadAll(f)
 }
 
+// Open opens a file for streaming reads. The caller must close it.
+// Hidden paths give ErrHidden.
+func (s *Storage) Open(relPath string) (File, error) {
+	p, err := s.visiblePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.backend.Open(p)
+}
+
+// ErrFileTooLarge is returned by WriteStream when the source exceeds maxBytes.
+var ErrFileTooLarge = errors.New("file exceeds maximum upload size")
+
 // Write writes data to a file, creating parent directories if needed.
 func (s *Storage) Write(relPath string, data []byte) error {
-	absPath, err := s.resolvePath(relPath)
+	_, err := s.WriteStream(relPath, bytes.NewReader(data), 0)
+	return err
+}
+
+// WriteStream copies r into a file without buffering it in memory.
+// The backend commits the file only once all data is written (the local driver
+// uses a temp file in the target directory, fsync, PUID/PGID and an atomic
+// rename), so readers never see a partial file. New file and folder names are
+// sanitized (see SanitizeName). The content's SHA-256 is
+// passed to subscribers with the change. With versioning enabled, a
+// file being replaced is kept as a previous version.
+// If maxBytes > 0 and r yields more than maxBytes, ErrFileTooLarge is returned
+// and nothing is written. Returns the number of bytes written.
+func (s *Storage) WriteStream(relPath string, r io.Reader, maxBytes int64) (int64, error) {
+	p, err := s.resolvePath(relPath)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if p == "" {
+		return 0, fmt.Errorf("cannot write to root directory")
+	}
+	p, renamed, err := s.cleanNames(p)
+	if err != nil {
+		return 0, err
 	}
 
-	// Ensure parent directory exists
-	dir := filepath.Dir(absPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	w, err := s.backend.Create(p)
+	if err != nil {
+		return 0, err
 	}
 
-	// Write file
-	if err := os.WriteFile(absPath, data, 0644); err != nil {
+	src := r
+	if maxBytes > 0 {
+		// Read one byte past the limit to detect oversized input
+		src = io.LimitReader(r, maxBytes+1)
+	}
+	// Hash while writing so the index does not have to read the file again
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), src)
+	if err != nil {
+		w.Abort()
+		return n, err
+	}
+	if maxBytes > 0 && n > maxBytes {
+		w.Abort()
+		return n, ErrFileTooLarge
+	}
+
+	version, err := s.stashVersion(p)
+	if err != nil {
+		w.Abort()
+		return n, err
+	}
+	err = w.Close()
+	s.commitVersion(version, err)
+	if err != nil {
+		return n, err
+	}
+	s.notify(Change{Op: ChangeWrite, Path: p, Hash: hex.EncodeToString(h.Sum(nil))})
+	s.nameChanged(renamed)
+	return n, nil
+}
+
+// ImportFile moves a local file from outside ROOT (e.g. a finished resumable
+// upload) to relPath. The local driver renames when source and ROOT share a
+// filesystem; other drivers stream a copy. The source is removed on success.
+func (s *Storage) ImportFile(relPath, srcPath string) error {
+	p, err := s.resolvePath(relPath)
+	if err != nil {
 		return err
 	}
 
-	// Apply PUID/PGID if configured (Docker use case)
-	if s.puid > 0 || s.pgid > 0 {
-		if err := os.Chown(absPath, s.puid, s.pgid); err != nil {
-			// Log but don't fail (may not have permissions)
+	if imp, ok := s.backend.(importer); ok {
+		p, renamed, err := s.cleanNames(p)
+		if err != nil {
+			return err
+		}
+		version, err := s.stashVersion(p)
+		if err != nil {
+			return err
 		}
+		err = imp.Import(p, srcPath)
+		s.commitVersion(version, err)
+		if err != nil {
+			return err
+		}
+		s.notify(Change{Op: ChangeWrite, Path: p})
+		s.nameChanged(renamed)
+		return nil
 	}
 
-	return nil
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := s.WriteStream(relPath, src, 0); err != nil {
+		return err
+	}
+	return os.Remove(srcPath)
+}
+
+// Validate reports whether relPath is an acceptable path within root.
+func (s *Storage) Validate(relPath string) error {
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return err
+	}
+	if rp, ok := s.backend.(realPather); ok {
+		_, err = rp.RealPath(p)
+	}
+	return err
 }
 
-// Delete removes a file or directory.
+// Delete permanently removes a file or directory.
+// Admin deletes go through Trash so they can be undone.
 func (s *Storage) Delete(relPath string) error {
-	absPath, err := s.resolvePath(relPath)
+	p, err := s.resolvePath(relPath)
 	if err != nil {
 		return err
 	}
+	if p == "" {
+		return fmt.Errorf("cannot delete root directory")
+	}
 
-	return os.RemoveAll(absPath)
+	if err := s.backend.Delete(p); err != nil {
+		return err
+	}
+	s.notify(Change{Op: ChangeRemove, Path: p})
+	return nil
 }
 
-// Mkdir creates a directory.
+// Mkdir creates a directory and any missing parents, sanitizing their names.
+// Subscribers are told about the topmost directory created, which covers the
+// ones beneath it.
 func (s *Storage) Mkdir(relPath string) error {
-	absPath, err := s.resolvePath(relPath)
+	p, err := s.resolvePath(relPath)
 	if err != nil {
 		return err
 	}
-
-	if err := os.MkdirAll(absPath, 0755); err != nil {
+	p, renamed, err := s.cleanNames(p)
+	if err != nil {
 		return err
 	}
 
-	// Apply PUID/PGID if configured
-	if s.puid > 0 || s.pgid > 0 {
-		if err := os.Chown(absPath, s.puid, s.pgid); err != nil {
-			// Log but don't fail
+	top := p
+	for dir := p; dir != "" && dir != "."; dir = path.Dir(dir) {
+		if _, err := s.backend.Stat(dir); err == nil {
+			break
 		}
+		top = dir
 	}
 
+	if err := s.backend.Mkdir(p); err != nil {
+		return err
+	}
+	s.notify(Change{Op: ChangeWrite, Path: top})
+	s.nameChanged(renamed)
 	return nil
 }
 
 // Exists checks if a path exists.
 func (s *Storage) Exists(relPath string) bool {
-	absPath, err := s.resolvePath(relPath)
-	if err != nil {
-		return false
-	}
-
-	_, err = os.Stat(absPath)
+	_, err := s.Stat(relPath)
 	return err == nil
 }
 
@@ -189,49 +380,67 @@ func (s *Storage) WriteMultipart(relPath string, data []byte) error {
 
 // Stat returns file info for a path.
 func (s *Storage) Stat(relPath string) (*FileInfo, error) {
-	absPath, err := s.resolvePath(relPath)
+	p, err := s.resolvePath(relPath)
 	if err != nil {
 		return nil, err
 	}
 
-	info, err := os.Stat(absPath)
+	return s.backend.Stat(p)
+}
+
+// Size returns the total size of a file, or of all files under a directory.
+func (s *Storage) Size(relPath string) (int64, error) {
+	p, err := s.resolvePath(relPath)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if _, err := s.backend.Stat(p); err != nil {
+		return 0, err
 	}
 
-	return &FileInfo{
-		Name:    info.Name(),
-		Size:    info.Size(),
-		ModTime: info.ModTime(),
-		IsDir:   info.IsDir(),
-	}, nil
+	return treeSize(s.backend, p), nil
 }
 
-// Rename renames a file or directory.
-func (s *Storage) Rename(oldPath, newName string) error {
+// Rename renames a file or directory, sanitizing the new name, and returns
+// the new path relative to root. It refuses to replace an existing item (ErrExists).
+func (s *Storage) Rename(oldPath, newName string) (string, error) {
 	// Validate old path
-	oldAbsPath, err := s.resolvePath(oldPath)
+	p, err := s.resolvePath(oldPath)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("cannot rename root directory")
 	}
 
 	// Validate new name (should not contain path separators)
 	if strings.Contains(newName, "/") || strings.Contains(newName, "\\") {
-		return fmt.Errorf("new name cannot contain path separators")
+		return "", fmt.Errorf("new name cannot contain path separators")
+	}
+	if newName == "." || newName == ".." {
+		return "", fmt.Errorf("invalid name")
+	}
+	cleanName, err := SanitizeName(newName, s.maxNameBytes)
+	if err != nil {
+		return "", err
 	}
 
 	// Construct new path (same directory, new name)
-	dir := filepath.Dir(oldAbsPath)
-	newAbsPath := filepath.Join(dir, newName)
+	newPath := path.Join(path.Dir(p), cleanName)
+	if newPath == p {
+		return newPath, nil
+	}
+	if _, err := s.backend.Stat(newPath); err == nil {
+		return "", ErrExists
+	}
 
 	// Rename
-	return os.Rename(oldAbsPath, newAbsPath)
-}
-
-// chown is a helper to apply ownership (Windows-safe).
-func chown(path string, uid, gid int) error {
-	if uid <= 0 && gid <= 0 {
-		return nil
+	if err := s.backend.Rename(p, newPath); err != nil {
+		return "", err
+	}
+	s.notify(Change{Op: ChangeRename, Path: newPath, OldPath: p})
+	if cleanName != newName {
+		s.nameChanged([]NameChange{{Path: newPath, Original: newName}})
 	}
-	return syscall.Chown(path, uid, gid)
+	return newPath, nil
 }
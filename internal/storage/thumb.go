@@ -1,104 +1,304 @@
 package storage
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
-	_ "image/png"
-	"os"
-	"path/filepath"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
 	"strings"
-	"time"
 
 	"golang.org/x/image/draw"
 )
 
-// GenerateThumbnail generates a thumbnail for an image file.
+// thumbCacheDir is where thumbnails used to be cached inside ROOT. It stays
+// hidden, and SweepThumbnails removes it (see ThumbCache for the cache now).
+const thumbCacheDir = ".thumbcache"
+
+// GenerateThumbnail generates a thumbnail for an image file, at most maxSize
+// pixels on its longer side. Larger sizes give web previews (see
+// PREVIEW_MAX_SIZE), cached alongside the thumbnails.
 // Returns the thumbnail data or an error if the file is not an image.
+// Concurrent calls for the same thumbnail share one decode, and decodes are
+// limited to the thumbnail concurrency (see SetThumbConcurrency).
 func (s *Storage) GenerateThumbnail(relPath string, maxSize int) ([]byte, error) {
-	// Resolve path
-	absPath, err := s.resolvePath(relPath)
+	return s.GenerateWatermarked(relPath, maxSize, nil)
+}
+
+// Watermark is stamped on generated images once they are resized and turned
+// upright (see GenerateWatermarked).
+type Watermark interface {
+	// Key identifies the watermark and its settings in cache names, in
+	// letters and digits only.
+	Key() string
+	// Draw stamps the watermark on img, failing rather than leave it off.
+	Draw(img *image.RGBA) error
+}
+
+// GenerateWatermarked is GenerateThumbnail with wm stamped on the result (nil
+// = none). maxSize 0 keeps the image's full size, for downloads; those are
+// cached like thumbnails, and as subject to eviction.
+func (s *Storage) GenerateWatermarked(relPath string, maxSize int, wm Watermark) ([]byte, error) {
+	t, err := s.thumbKey(relPath, maxSize, wm)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if it's an image by extension
-	ext := strings.ToLower(filepath.Ext(absPath))
-	if !isImageExt(ext) {
-		return nil, fmt.Errorf("not an image file")
+	// Check cache
+	if s.thumbs != nil {
+		if data, ok := s.thumbs.get(t.path, t.variant); ok {
+			return data, nil
+		}
 	}
 
-	// Get file info for cache key
-	info, err := os.Stat(absPath)
+	v, err, _ := s.thumbFlight.Do(t.path+"\x00"+t.variant, func() (interface{}, error) {
+		return s.makeThumbnail(t)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return v.([]byte), nil
+}
 
-	// Check cache
-	cacheKey := generateCacheKey(relPath, info.ModTime(), maxSize)
-	cacheDir := filepath.Join(s.root, ".thumbcache")
-	cachePath := filepath.Join(cacheDir, cacheKey+".jpg")
+// PregenerateThumbnail makes sure the thumbnail of relPath at maxSize (with
+// wm, if not nil) is cached, generating it if needed. It does not count as a
+// cache hit or miss.
+func (s *Storage) PregenerateThumbnail(relPath string, maxSize int, wm Watermark) error {
+	t, err := s.thumbKey(relPath, maxSize, wm)
+	if err != nil {
+		return err
+	}
+	if s.thumbs == nil || s.thumbs.has(t.path, t.variant) {
+		return nil
+	}
+	_, err, _ = s.thumbFlight.Do(t.path+"\x00"+t.variant, func() (interface{}, error) {
+		return s.makeThumbnail(t)
+	})
+	return err
+}
+
+// ErrCannotWatermark is returned for files that are images, by name or by
+// content, in a format watermarks cannot be drawn on (HEIC, SVG, ...).
+// Watermarked shares refuse them rather than hand them out clean.
+var ErrCannotWatermark = errors.New("image format cannot be watermarked")
+
+// NeedsWatermark reports whether a watermarked share must stamp relPath
+// rather than hand it out as stored. That is decided by content as well as
+// by name, so a .jfif or a misnamed photo is stamped too; image content that
+// cannot be decoded gives ErrCannotWatermark.
+func (s *Storage) NeedsWatermark(relPath string) (bool, error) {
+	p, err := s.visiblePath(relPath)
+	if err != nil {
+		return false, err
+	}
+	return s.needsWatermark(p)
+}
+
+// needsWatermark implements NeedsWatermark for a clean backend path.
+func (s *Storage) needsWatermark(p string) (bool, error) {
+	if IsImage(p) {
+		return true, nil
+	}
+
+	f, err := s.backend.Open(p)
+	if err != nil {
+		return false, err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	head = head[:n]
+
+	if sniffFormat(head) != "" {
+		return true, nil // Decoded by content, whatever the name says
+	}
+	if isImageBrand(head) ||
+		strings.HasPrefix(http.DetectContentType(head), "image/") ||
+		strings.HasPrefix(mime.TypeByExtension(strings.ToLower(path.Ext(p))), "image/") {
+		return false, ErrCannotWatermark
+	}
+	return false, nil
+}
+
+// isImageBrand reports whether head starts an ISO base media file branded
+// as a still image (HEIF/HEIC, AVIF) rather than a video.
+func isImageBrand(head []byte) bool {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	switch string(head[8:12]) {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1", "avif", "avis", "crx ":
+		return true
+	}
+	return false
+}
+
+// WatermarkedName is the name a watermarked download of name is saved under:
+// watermarked images are always JPEGs.
+func WatermarkedName(name string) string {
+	switch ext := path.Ext(name); strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return name
+	default:
+		return strings.TrimSuffix(name, ext) + ".jpg"
+	}
+}
+
+// thumbJob is a thumbnail to serve or make: the source, how to draw it and
+// the name it is cached under.
+type thumbJob struct {
+	path        string
+	maxSize     int
+	orientation int       // EXIF orientation of the source (1 = upright)
+	watermark   Watermark // nil = none
+	variant     string
+}
+
+// thumbKey resolves relPath to an image and names its thumbnail variant.
+func (s *Storage) thumbKey(relPath string, maxSize int, wm Watermark) (thumbJob, error) {
+	// Resolve path (no thumbnails of hidden files, or of the cache itself)
+	p, err := s.visiblePath(relPath)
+	if err != nil {
+		return thumbJob{}, err
+	}
+
+	// Check if it's an image by extension. Watermarked copies are also made
+	// of images under other names (see NeedsWatermark): decoding goes by content
+	if !IsImage(p) && wm == nil {
+		return thumbJob{}, fmt.Errorf("not an image file")
+	}
 
-	// If cache exists and is valid, return it
-	if data, err := os.ReadFile(cachePath); err == nil {
-		return data, nil
+	// Get file info for cache key
+	info, err := s.backend.Stat(p)
+	if err != nil {
+		return thumbJob{}, err
+	}
+
+	// Orientation is part of the key: thumbnails made before it was applied
+	// are not reused
+	orientation := orientNormal
+	if hasExif(strings.ToLower(path.Ext(p))) {
+		if o := s.exifFor(p, *info).Orientation; o != 0 {
+			orientation = o
+		}
+	}
+	var wmKey string
+	if wm != nil {
+		wmKey = wm.Key()
+	}
+	return thumbJob{
+		path:        p,
+		maxSize:     maxSize,
+		orientation: orientation,
+		watermark:   wm,
+		variant:     thumbVariant(info.ModTime, maxSize, orientation, wmKey),
+	}, nil
+}
+
+// makeThumbnail decodes and resizes t.path once a decode slot is free, and
+// caches the result.
+func (s *Storage) makeThumbnail(t thumbJob) ([]byte, error) {
+	if s.decodeSlots != nil {
+		s.decodeSlots <- struct{}{}
+		defer func() { <-s.decodeSlots }()
 	}
 
 	// Generate thumbnail
-	data, err := generateThumbnail(absPath, maxSize)
+	src, err := s.backend.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	var img io.ReadSeeker = src
+	if isRawExt(strings.ToLower(path.Ext(t.path))) {
+		// Camera RAW: thumbnail the JPEG preview embedded in the file
+		if img, err = rawPreview(src, t.maxSize); err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+	data, err := s.generateThumbnail(img, t.maxSize, t.orientation, t.watermark)
+	src.Close()
 	if err != nil {
 		return nil, err
 	}
 
 	// Save to cache
-	os.MkdirAll(cacheDir, 0755)
-	os.WriteFile(cachePath, data, 0644)
+	if s.thumbs != nil {
+		if err := s.thumbs.put(t.path, t.variant, data); err != nil {
+			log.Printf("thumbnail: failed to cache %q: %v", t.path, err)
+		}
+	}
 
 	return data, nil
 }
 
+// SetThumbConcurrency limits how many thumbnails are decoded at once, across
+// requests and background generation (0 = unlimited).
+func (s *Storage) SetThumbConcurrency(n int) {
+	if n > 0 {
+		s.decodeSlots = make(chan struct{}, n)
+	}
+}
+
+// IsImage reports whether name has an extension thumbnails can be made for.
+func IsImage(name string) bool {
+	return isImageExt(strings.ToLower(path.Ext(name)))
+}
+
 // isImageExt checks if a file extension is an image type.
 func isImageExt(ext string) bool {
 	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".tif", ".tiff":
 		return true
 	}
-	return false
-}
-
-// generateCacheKey creates a cache key from path, mtime, and size.
-func generateCacheKey(path string, modTime time.Time, maxSize int) string {
-	h := md5.New()
-	h.Write([]byte(path))
-	h.Write([]byte(modTime.String()))
-	h.Write([]byte(fmt.Sprintf("%d", maxSize)))
-	return hex.EncodeToString(h.Sum(nil))
+	return isRawExt(ext)
 }
 
-// generateThumbnail decodes, resizes, and encodes an image.
-func generateThumbnail(srcPath string, maxSize int) ([]byte, error) {
-	// Open source image
-	file, err := os.Open(srcPath)
+// InvalidateThumbnails drops cached thumbnails of a file, e.g. after it was
+// replaced or removed outside the app.
+func (s *Storage) InvalidateThumbnails(relPath string) error {
+	p, err := s.resolvePath(relPath)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if p == "" || IsInternal(p) || s.thumbs == nil {
+		return nil
 	}
-	defer file.Close()
+	return s.thumbs.invalidate(p)
+}
 
-	// Decode image
-	img, _, err := image.Decode(file)
+// generateThumbnail decodes, resizes, turns upright (EXIF orientation),
+// watermarks and encodes an image, within the decode limits (see
+// SetDecodeLimits). maxSize 0 keeps the full size.
+func (s *Storage) generateThumbnail(src io.ReadSeeker, maxSize, orientation int, wm Watermark) ([]byte, error) {
+	// Decode image (format from magic bytes, first frame of animations)
+	img, release, err := s.decodeBounded(src, maxSize)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	// Calculate new dimensions
+	// Calculate new dimensions, so the size limit applies to the upright shape
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
+	if orientation >= orientTranspose {
+		width, height = height, width
+	}
 
 	var newWidth, newHeight int
-	if width > height {
+	if maxSize <= 0 || width <= maxSize && height <= maxSize {
+		// Never enlarge: a 1600px preview of a 1000px photo stays 1000px
+		newWidth, newHeight = width, height
+	} else if width > height {
 		newWidth = maxSize
 		newHeight = height * maxSize / width
 	} else {
@@ -106,23 +306,24 @@ func generateThumbnail(srcPath string, maxSize int) ([]byte, error) {
 		newWidth = width * maxSize / height
 	}
 
-	// Resize image
+	// Resize image, then orient the small copy rather than the decoded one
+	if orientation >= orientTranspose {
+		newWidth, newHeight = newHeight, newWidth
+	}
 	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
 	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
-
-	// Encode as JPEG
-	tmpFile, err := os.CreateTemp("", "thumb-*.jpg")
-	if err != nil {
-		return nil, err
+	thumb := orientImage(dst, orientation)
+	if wm != nil {
+		// orientImage keeps RGBA images RGBA
+		if err := wm.Draw(thumb.(*image.RGBA)); err != nil {
+			return nil, err
+		}
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	if err := jpeg.Encode(tmpFile, dst, &jpeg.Options{Quality: 85}); err != nil {
+	// Encode as JPEG
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
 		return nil, err
 	}
-
-	// Read back the encoded data
-	tmpFile.Seek(0, 0)
-	return os.ReadFile(tmpFile.Name())
+	return buf.Bytes(), nil
 }
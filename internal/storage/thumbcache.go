@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// thumbSourceFile names the file in each cache entry directory that records
+// which source path the thumbnails belong to, so sweeps can find orphans.
+const thumbSourceFile = "source"
+
+// thumbTouchInterval limits how often a cache hit rewrites the entry's mtime,
+// which serves as its last-access time for eviction (atime is often disabled).
+const thumbTouchInterval = time.Hour
+
+// ThumbCache keeps generated thumbnails on local disk outside ROOT. Each
+// source path gets a directory sharded by the first bytes of its hash
+// (ab/cd/abcd…/), holding one file per variant (size, source mtime and EXIF
+// orientation) and a "source" file naming the path. When the cache grows past
+// maxBytes, the least recently used thumbnails are evicted.
+type ThumbCache struct {
+	dir      string
+	maxBytes int64 // 0 = unbounded
+
+	mu      sync.Mutex
+	bytes   int64 // Total size of cached thumbnails
+	entries int   // Number of cached thumbnails
+
+	hits, misses, evicted, orphans atomic.Int64
+	evicting                       atomic.Bool
+	lastSweep                      atomic.Int64 // Unix seconds
+}
+
+// ThumbCacheStats is a snapshot of cache usage for the admin page.
+type ThumbCacheStats struct {
+	Dir       string
+	Entries   int
+	Bytes     int64
+	MaxBytes  int64
+	Hits      int64
+	Misses    int64
+	Evicted   int64 // Thumbnails removed to stay under MaxBytes
+	Orphans   int64 // Thumbnails removed because their source changed or is gone
+	LastSweep time.Time
+}
+
+// HitRate returns the share of lookups served from the cache, in percent.
+func (st ThumbCacheStats) HitRate() int {
+	if total := st.Hits + st.Misses; total > 0 {
+		return int(st.Hits * 100 / total)
+	}
+	return 0
+}
+
+// NewThumbCache creates a cache in dir (created if missing) bounded to
+// maxBytes (0 = unbounded). Call Storage.SweepThumbnails at startup to count existing
+// entries and drop stale ones.
+func NewThumbCache(dir string, maxBytes int64) *ThumbCache {
+	return &ThumbCache{dir: dir, maxBytes: maxBytes}
+}
+
+// SetThumbCache sets where thumbnails are cached (nil = not cached).
+func (s *Storage) SetThumbCache(c *ThumbCache) {
+	s.thumbs = c
+}
+
+// ThumbCacheStats returns cache usage (zero if thumbnails are not cached).
+func (s *Storage) ThumbCacheStats() ThumbCacheStats {
+	if s.thumbs == nil {
+		return ThumbCacheStats{}
+	}
+	return s.thumbs.Stats()
+}
+
+// SweepThumbnails drops cached thumbnails whose source file was removed or
+// changed, then evicts down to the size bound. It also removes the cache the
+// app used to keep inside ROOT (.thumbcache).
+func (s *Storage) SweepThumbnails() error {
+	if _, err := s.backend.Stat(thumbCacheDir); err == nil {
+		if err := s.backend.Delete(thumbCacheDir); err != nil {
+			log.Printf("thumbnail: failed to remove old cache %q under ROOT: %v", thumbCacheDir, err)
+		}
+	}
+	if s.thumbs == nil {
+		return nil
+	}
+	return s.thumbs.sweep(func(p string) (*FileInfo, error) {
+		return s.backend.Stat(p)
+	})
+}
+
+// thumbVariant names a cached thumbnail of a source with this mtime and EXIF
+// orientation at maxSize, followed by the watermark's key if it has one.
+func thumbVariant(modTime time.Time, maxSize, orientation int, watermark string) string {
+	v := fmt.Sprintf("%d-%d-%d", maxSize, modTime.UnixNano(), orientation)
+	if watermark != "" {
+		v += "-" + watermark
+	}
+	return v
+}
+
+// variantModTime returns the source mtime recorded in a variant file name.
+// Names from before the orientation was recorded do not parse, so sweeps
+// drop those thumbnails.
+func variantModTime(name string) (int64, bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".jpg"), "-")
+	if len(parts) != 3 && len(parts) != 4 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(parts[1], 10, 64)
+	return n, err == nil
+}
+
+// sourceDir returns the entry directory of source path p.
+func (c *ThumbCache) sourceDir(p string) string {
+	h := sha256.Sum256([]byte(p))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(c.dir, name[:2], name[2:4], name)
+}
+
+// get returns a cached thumbnail and marks it as recently used.
+func (c *ThumbCache) get(p, variant string) ([]byte, bool) {
+	file := filepath.Join(c.sourceDir(p), variant+".jpg")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > thumbTouchInterval {
+		now := time.Now()
+		os.Chtimes(file, now, now)
+	}
+	return data, true
+}
+
+// has reports whether a thumbnail is cached, without counting a hit or miss.
+func (c *ThumbCache) has(p, variant string) bool {
+	_, err := os.Stat(filepath.Join(c.sourceDir(p), variant+".jpg"))
+	return err == nil
+}
+
+// put stores a thumbnail, evicting in the background when over the bound.
+func (c *ThumbCache) put(p, variant string, data []byte) error {
+	dir := c.sourceDir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	src := filepath.Join(dir, thumbSourceFile)
+	if _, err := os.Stat(src); err != nil {
+		if err := os.WriteFile(src, []byte(p), 0644); err != nil {
+			return err
+		}
+	}
+
+	file := filepath.Join(dir, variant+".jpg")
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	var replaced int64 = -1
+	if info, err := os.Stat(file); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	if replaced >= 0 {
+		c.bytes -= replaced
+	} else {
+		c.entries++
+	}
+	c.bytes += int64(len(data))
+	over := c.maxBytes > 0 && c.bytes > c.maxBytes
+	c.mu.Unlock()
+
+	if over && c.evicting.CompareAndSwap(false, true) {
+		go func() {
+			defer c.evicting.Store(false)
+			if err := c.evict(); err != nil {
+				log.Printf("thumbnail: eviction failed: %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// invalidate drops every cached thumbnail of source path p.
+func (c *ThumbCache) invalidate(p string) error {
+	dir := c.sourceDir(p)
+	variants, err := c.variants(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	c.forget(variants)
+	return nil
+}
+
+// cachedThumb is one thumbnail file, as seen by sweeps and eviction.
+type cachedThumb struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// variants returns the thumbnails in entry directory dir.
+func (c *ThumbCache) variants(dir string) ([]cachedThumb, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var thumbs []cachedThumb
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".jpg") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		thumbs = append(thumbs, cachedThumb{filepath.Join(dir, e.Name()), info.Size(), info.ModTime()})
+	}
+	return thumbs, nil
+}
+
+// forget subtracts removed thumbnails from the totals.
+func (c *ThumbCache) forget(thumbs []cachedThumb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range thumbs {
+		c.bytes -= t.size
+		c.entries--
+	}
+}
+
+// entryDirs returns every entry directory in the cache.
+func (c *ThumbCache) entryDirs() ([]string, error) {
+	return filepath.Glob(filepath.Join(c.dir, "*", "*", "*"))
+}
+
+// sweep removes entries whose source is gone (stat gives fs.ErrNotExist) or
+// whose thumbnails were made from an older version of the source, recounts
+// the totals and evicts if over the bound.
+func (c *ThumbCache) sweep(stat func(p string) (*FileInfo, error)) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	dirs, err := c.entryDirs()
+	if err != nil {
+		return err
+	}
+
+	var bytes int64
+	var entries int
+	for _, dir := range dirs {
+		thumbs, err := c.variants(dir)
+		if err != nil {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(dir, thumbSourceFile))
+		if err != nil {
+			os.RemoveAll(dir) // Unknown source: cannot be checked or invalidated
+			c.orphans.Add(int64(len(thumbs)))
+			continue
+		}
+		info, err := stat(string(src))
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir) {
+			os.RemoveAll(dir)
+			c.orphans.Add(int64(len(thumbs)))
+			continue
+		}
+
+		// A source that could not be checked (e.g. S3 unreachable) keeps its thumbnails
+		current := err == nil
+		kept := 0
+		for _, t := range thumbs {
+			if mt, ok := variantModTime(filepath.Base(t.path)); current && (!ok || mt != info.ModTime.UnixNano()) {
+				os.Remove(t.path)
+				c.orphans.Add(1)
+				continue
+			}
+			bytes += t.size
+			entries++
+			kept++
+		}
+		if kept == 0 {
+			os.RemoveAll(dir)
+		}
+	}
+
+	c.mu.Lock()
+	c.bytes, c.entries = bytes, entries
+	over := c.maxBytes > 0 && c.bytes > c.maxBytes
+	c.mu.Unlock()
+	c.lastSweep.Store(time.Now().Unix())
+
+	if over {
+		return c.evict()
+	}
+	return nil
+}
+
+// evict removes the least recently used thumbnails until the cache is below
+// 90% of its bound, leaving room before the next eviction.
+func (c *ThumbCache) evict() error {
+	dirs, err := c.entryDirs()
+	if err != nil {
+		return err
+	}
+	var all []cachedThumb
+	for _, dir := range dirs {
+		thumbs, err := c.variants(dir)
+		if err != nil {
+			continue
+		}
+		all = append(all, thumbs...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+
+	target := c.maxBytes / 10 * 9
+	for _, t := range all {
+		c.mu.Lock()
+		done := c.bytes <= target
+		c.mu.Unlock()
+		if done {
+			break
+		}
+		if err := os.Remove(t.path); err != nil {
+			continue
+		}
+		c.forget([]cachedThumb{t})
+		c.evicted.Add(1)
+		// Drop the entry directory once its last thumbnail is gone
+		if rest, _ := c.variants(filepath.Dir(t.path)); len(rest) == 0 {
+			os.RemoveAll(filepath.Dir(t.path))
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of cache usage.
+func (c *ThumbCache) Stats() ThumbCacheStats {
+	c.mu.Lock()
+	bytes, entries := c.bytes, c.entries
+	c.mu.Unlock()
+
+	st := ThumbCacheStats{
+		Dir:      c.dir,
+		Entries:  entries,
+		Bytes:    bytes,
+		MaxBytes: c.maxBytes,
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Evicted:  c.evicted.Load(),
+		Orphans:  c.orphans.Load(),
+	}
+	if t := c.lastSweep.Load(); t > 0 {
+		st.LastSweep = time.Unix(t, 0)
+	}
+	return st
+}
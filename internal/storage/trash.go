@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrExists is returned when an operation would replace an existing path.
+var ErrExists = errors.New("destination already exists")
+
+// TrashEntry describes an item moved into the trash area.
+type TrashEntry struct {
+	Name  string // Entry name inside the trash dir (unique)
+	Path  string // Path it was removed from, relative to root
+	Size  int64  // Total bytes (recursive for directories)
+	IsDir bool
+}
+
+// SetTrash enables the trash area. It must be outside the browsable tree so
+// trashed items never show up in listings or shares. For the local driver use
+// a LocalBackend on the same disk as ROOT so trashing is a cheap rename.
+func (s *Storage) SetTrash(b Backend) {
+	s.trash = b
+}
+
+// OnTrash registers fn to be called when a move or copy replaced an item and
+// put it in the trash, so it can be recorded. Without a callback replaced
+// items are deleted.
+func (s *Storage) OnTrash(fn func(TrashEntry)) {
+	s.onTrash = fn
+}
+
+// Trash moves a file or directory out of the browsable tree into the trash area.
+// Returns an error if no trash is set.
+func (s *Storage) Trash(relPath string) (*TrashEntry, error) {
+	if s.trash == nil {
+		return nil, fmt.Errorf("trash is not configured")
+	}
+
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return nil, fmt.Errorf("cannot delete root directory")
+	}
+
+	entry, err := s.trashPath(p)
+	if err != nil {
+		return nil, err
+	}
+	s.notify(Change{Op: ChangeRemove, Path: p})
+
+	return entry, nil
+}
+
+// trashPath moves p (a clean backend path) into the trash area.
+func (s *Storage) trashPath(p string) (*TrashEntry, error) {
+	info, err := s.backend.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &TrashEntry{
+		Name:  fmt.Sprintf("%d-%s", time.Now().UnixNano(), path.Base(p)),
+		Path:  p,
+		IsDir: info.IsDir,
+		Size:  treeSize(s.backend, p),
+	}
+	if err := moveBetween(s.backend, p, s.trash, entry.Name); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// stashReplaced moves p, about to be replaced by a move or copy, into the
+// trash. Without a trash or a callback to record it, p is deleted instead
+// and nil returned.
+func (s *Storage) stashReplaced(p string) (*TrashEntry, error) {
+	if s.trash == nil || s.onTrash == nil {
+		if err := s.backend.Delete(p); err != nil {
+			return nil, err
+		}
+		s.notify(Change{Op: ChangeRemove, Path: p})
+		return nil, nil
+	}
+	entry, err := s.trashPath(p)
+	if err != nil {
+		return nil, fmt.Errorf("move replaced item to trash: %w", err)
+	}
+	return entry, nil
+}
+
+// commitReplaced records a trashed replaced item once its replacement is in
+// place, or puts it back if the move or copy failed.
+func (s *Storage) commitReplaced(entry *TrashEntry, err error) {
+	if entry == nil {
+		return
+	}
+	if err != nil {
+		moveBetween(s.trash, entry.Name, s.backend, entry.Path)
+		return
+	}
+	s.notify(Change{Op: ChangeRemove, Path: entry.Path})
+	s.onTrash(*entry)
+}
+
+// RestoreTrash moves a trashed item back to relPath. It fails with ErrExists
+// rather than overwrite something that was created there in the meantime.
+func (s *Storage) RestoreTrash(name, relPath string) error {
+	if err := s.checkTrashName(name); err != nil {
+		return err
+	}
+
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return err
+	}
+	if _, err := s.backend.Stat(p); err == nil {
+		return ErrExists
+	}
+
+	if err := moveBetween(s.trash, name, s.backend, p); err != nil {
+		return err
+	}
+	s.notify(Change{Op: ChangeWrite, Path: p})
+	return nil
+}
+
+// PurgeTrash permanently removes a trashed item.
+func (s *Storage) PurgeTrash(name string) error {
+	if err := s.checkTrashName(name); err != nil {
+		return err
+	}
+	return s.trash.Delete(name)
+}
+
+// checkTrashName validates a trash entry name.
+func (s *Storage) checkTrashName(name string) error {
+	if s.trash == nil {
+		return fmt.Errorf("trash is not configured")
+	}
+	return checkAreaName(s.trash, name)
+}
+
+// checkAreaName validates an entry name in a flat side area (trash, versions).
+func checkAreaName(area Backend, name string) error {
+	if area == nil {
+		return fmt.Errorf("not configured")
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid entry name")
+	}
+	return nil
+}
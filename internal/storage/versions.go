@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// VersionEntry describes a previous file version moved into the versions area.
+type VersionEntry struct {
+	Name    string // Entry name inside the versions area (unique)
+	Path    string // Path of the file it was replaced at, relative to root
+	Size    int64
+	ModTime time.Time // Modification time of the replaced file
+}
+
+// SetVersions enables versioning: before a write replaces an existing file,
+// the old file is moved into b. Like the trash, b must be outside the
+// browsable tree; for the local driver use a LocalBackend on the same disk as ROOT.
+func (s *Storage) SetVersions(b Backend) {
+	s.versions = b
+}
+
+// OnVersion registers fn to be called after a write kept a previous version,
+// so it can be recorded. Without a callback no versions are kept.
+func (s *Storage) OnVersion(fn func(VersionEntry)) {
+	s.onVersion = fn
+}
+
+// stashVersion keeps a copy of the file at p (a clean backend path) in the
+// versions area if versioning is enabled and a file exists there. The file
+// itself stays in place until the new one is renamed over it, so p never goes
+// missing, even if the process dies in between. Returns nil when there was
+// nothing to keep.
+func (s *Storage) stashVersion(p string) (*VersionEntry, error) {
+	if s.versions == nil || s.onVersion == nil {
+		return nil, nil
+	}
+	info, err := s.backend.Stat(p)
+	if err != nil || info.IsDir {
+		return nil, nil
+	}
+
+	entry := &VersionEntry{
+		Name:    fmt.Sprintf("%d-%s", time.Now().UnixNano(), path.Base(p)),
+		Path:    p,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+	}
+	if err := keepBetween(s.backend, p, s.versions, entry.Name); err != nil {
+		return nil, fmt.Errorf("keep previous version: %w", err)
+	}
+	return entry, nil
+}
+
+// commitVersion records a stashed version once the new file is in place, or
+// drops the copy if the write failed and the old file is still current.
+func (s *Storage) commitVersion(entry *VersionEntry, writeErr error) {
+	if entry == nil {
+		return
+	}
+	if writeErr != nil {
+		s.versions.Delete(entry.Name)
+		return
+	}
+	s.onVersion(*entry)
+}
+
+// OpenVersion opens a kept version for reading. The caller must close it.
+func (s *Storage) OpenVersion(name string) (File, error) {
+	if err := checkAreaName(s.versions, name); err != nil {
+		return nil, err
+	}
+	return s.versions.Open(name)
+}
+
+// RestoreVersion puts a kept version back at relPath. The current file, if
+// any, is kept as a version itself, so a restore can be undone.
+func (s *Storage) RestoreVersion(name, relPath string) error {
+	if err := checkAreaName(s.versions, name); err != nil {
+		return err
+	}
+	p, err := s.resolvePath(relPath)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return fmt.Errorf("cannot write to root directory")
+	}
+
+	current, err := s.stashVersion(p)
+	if err != nil {
+		return err
+	}
+	err = moveBetween(s.versions, name, s.backend, p)
+	s.commitVersion(current, err)
+	if err != nil {
+		return err
+	}
+
+	s.notify(Change{Op: ChangeWrite, Path: p})
+	return nil
+}
+
+// PurgeVersion permanently removes a kept version.
+func (s *Storage) PurgeVersion(name string) error {
+	if err := checkAreaName(s.versions, name); err != nil {
+		return err
+	}
+	return s.versions.Delete(name)
+}
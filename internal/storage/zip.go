@@ -4,7 +4,6 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
-	"os"
 )
 
 // ZipLimits holds limits for ZIP creation.
@@ -17,6 +16,14 @@ type ZipLimits struct {
 // Paths are relative to the storage root and are validated.
 // Returns an error if limits are exceeded or if any file cannot be read.
 func (s *Storage) CreateZip(w io.Writer, paths []string, limits ZipLimits) error {
+	return s.CreateWatermarkedZip(w, paths, limits, nil)
+}
+
+// CreateWatermarkedZip is CreateZip with images replaced by full-size JPEGs
+// stamped with wm (see GenerateWatermarked and WatermarkedName). Images are
+// told by content as well as by name (see NeedsWatermark); one that cannot be
+// watermarked fails the archive rather than going in as is.
+func (s *Storage) CreateWatermarkedZip(w io.Writer, paths []string, limits ZipLimits, wm Watermark) error {
 	// Check file count limit
 	if len(paths) > limits.MaxFiles {
 		return fmt.Errorf("too many files: %d exceeds limit of %d", len(paths), limits.MaxFiles)
@@ -29,43 +36,54 @@ func (s *Storage) CreateZip(w io.Writer, paths []string, limits ZipLimits) error
 	var totalBytes int64
 
 	for _, relPath := range paths {
-		// Resolve and validate path
-		absPath, err := s.resolvePath(relPath)
+		// Resolve and validate path (hidden files are never zipped)
+		p, err := s.visiblePath(relPath)
 		if err != nil {
 			return fmt.Errorf("invalid path %s: %w", relPath, err)
 		}
 
 		// Get file info
-		info, err := os.Stat(absPath)
+		info, err := s.backend.Stat(p)
 		if err != nil {
 			return fmt.Errorf("stat %s: %w", relPath, err)
 		}
 
 		// Skip directories (could be enhanced to recursively add directory contents)
-		if info.IsDir() {
+		if info.IsDir {
 			continue
 		}
 
 		// Check size limit
-		if totalBytes+info.Size() > limits.MaxBytes {
+		if totalBytes+info.Size > limits.MaxBytes {
 			return fmt.Errorf("total size exceeds limit of %d bytes", limits.MaxBytes)
 		}
 
 		// Add file to ZIP
-		if err := s.addFileToZip(zw, absPath, relPath); err != nil {
+		stamp := false
+		if wm != nil {
+			if stamp, err = s.needsWatermark(p); err != nil {
+				return fmt.Errorf("add %s to zip: %w", relPath, err)
+			}
+		}
+		if stamp {
+			err = s.addWatermarkedToZip(zw, p, relPath, wm)
+		} else {
+			err = s.addFileToZip(zw, p, relPath)
+		}
+		if err != nil {
 			return fmt.Errorf("add %s to zip: %w", relPath, err)
 		}
 
-		totalBytes += info.Size()
+		totalBytes += info.Size
 	}
 
 	return nil
 }
 
 // addFileToZip adds a single file to the ZIP archive.
-func (s *Storage) addFileToZip(zw *zip.Writer, absPath, relPath string) error {
+func (s *Storage) addFileToZip(zw *zip.Writer, p, relPath string) error {
 	// Open source file
-	file, err := os.Open(absPath)
+	file, err := s.backend.Open(p)
 	if err != nil {
 		return err
 	}
@@ -81,3 +99,17 @@ func (s *Storage) addFileToZip(zw *zip.Writer, absPath, relPath string) error {
 	_, err = io.Copy(writer, file)
 	return err
 }
+
+// addWatermarkedToZip adds a watermarked copy of an image to the ZIP archive.
+func (s *Storage) addWatermarkedToZip(zw *zip.Writer, p, relPath string, wm Watermark) error {
+	data, err := s.GenerateWatermarked(p, 0, wm)
+	if err != nil {
+		return err
+	}
+	writer, err := zw.Create(WatermarkedName(relPath))
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
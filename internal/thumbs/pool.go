@@ -0,0 +1,169 @@
+// Package thumbs pre-generates thumbnails in the background so the first
+// visit to a folder or share does not decode every photo inside requests.
+package thumbs
+
+import (
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"nas-dop/internal/storage"
+)
+
+// queueSize bounds pending jobs; jobs past it are dropped and made on demand.
+const queueSize = 10000
+
+// Job is one thumbnail to make.
+type Job struct {
+	Path      string
+	MaxSize   int
+	Watermark storage.Watermark // Stamped on the thumbnail (nil = none)
+}
+
+// Stats is a snapshot of the pool for the admin page.
+type Stats struct {
+	Workers int
+	Pending int   // Jobs waiting in the queue
+	Active  []Job // Jobs being made now
+	Next    []Job // First jobs in line
+	Done    int64 // Made or found cached since startup
+	Failed  int64
+	Dropped int64 // Not queued because the queue was full
+	Last    time.Time
+}
+
+// Pool runs thumbnail jobs on a fixed number of workers. Jobs for the same
+// thumbnail are queued once; Storage shares decodes with concurrent requests.
+type Pool struct {
+	storage *storage.Storage
+	jobs    chan Job
+
+	mu      sync.Mutex
+	queued  map[Job]bool // Jobs in the channel, to skip duplicates
+	order   []Job        // Queued jobs in order, for Stats
+	active  map[int]Job  // Job per busy worker
+	workers int
+	done    int64
+	failed  int64
+	dropped int64
+	last    time.Time
+}
+
+// New starts a pool of workers (at least one) making thumbnails from st.
+func New(st *storage.Storage, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		storage: st,
+		jobs:    make(chan Job, queueSize),
+		queued:  make(map[Job]bool),
+		active:  make(map[int]Job),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go p.work(i)
+	}
+	return p
+}
+
+// Enqueue queues a thumbnail of relPath at maxSize, unless it is not an image,
+// already queued or the queue is full.
+func (p *Pool) Enqueue(relPath string, maxSize int) {
+	p.enqueue(relPath, maxSize, nil)
+}
+
+func (p *Pool) enqueue(relPath string, maxSize int, wm storage.Watermark) {
+	if !storage.IsImage(relPath) {
+		return
+	}
+	job := Job{Path: path.Clean("/" + relPath), MaxSize: maxSize, Watermark: wm}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queued[job] {
+		return
+	}
+	select {
+	case p.jobs <- job:
+		p.queued[job] = true
+		p.order = append(p.order, job)
+	default:
+		p.dropped++
+	}
+}
+
+// EnqueueDir queues thumbnails of the images directly in dir, or of dir
+// itself when it is a file, stamped with wm if it is not nil (a share's
+// watermark).
+func (p *Pool) EnqueueDir(dir string, maxSize int, wm storage.Watermark) {
+	info, err := p.storage.Stat(dir)
+	if err != nil {
+		return
+	}
+	if !info.IsDir {
+		p.enqueue(dir, maxSize, wm)
+		return
+	}
+	files, err := p.storage.List(dir)
+	if err != nil {
+		log.Printf("thumbs: failed to list %q: %v", dir, err)
+		return
+	}
+	for _, f := range files {
+		if !f.IsDir {
+			p.enqueue(path.Join(dir, f.Name), maxSize, wm)
+		}
+	}
+}
+
+func (p *Pool) work(id int) {
+	for job := range p.jobs {
+		p.mu.Lock()
+		delete(p.queued, job)
+		for i, j := range p.order {
+			if j == job {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+		p.active[id] = job
+		p.mu.Unlock()
+
+		err := p.storage.PregenerateThumbnail(job.Path, job.MaxSize, job.Watermark)
+		if err != nil {
+			log.Printf("thumbs: failed to make thumbnail of %q: %v", job.Path, err)
+		}
+
+		p.mu.Lock()
+		delete(p.active, id)
+		if err != nil {
+			p.failed++
+		} else {
+			p.done++
+		}
+		p.last = time.Now()
+		p.mu.Unlock()
+	}
+}
+
+// Stats returns the pool's current state, listing up to maxNext queued jobs.
+func (p *Pool) Stats(maxNext int) Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := Stats{
+		Workers: p.workers,
+		Pending: len(p.order),
+		Done:    p.done,
+		Failed:  p.failed,
+		Dropped: p.dropped,
+		Last:    p.last,
+	}
+	for _, job := range p.active {
+		st.Active = append(st.Active, job)
+	}
+	st.Next = append(st.Next, p.order[:min(maxNext, len(p.order))]...)
+	return st
+}
@@ -0,0 +1,201 @@
+package trash
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"nas-dop/internal/storage"
+)
+
+// Store manages trash records in SQLite; file moves go through storage.
+type Store struct {
+	db        *sql.DB
+	storage   *storage.Storage
+	retention time.Duration
+}
+
+// NewStore creates a new trash store and starts the retention purge loop.
+func NewStore(db *sql.DB, st *storage.Storage, retention time.Duration) *Store {
+	store := &Store{
+		db:        db,
+		storage:   st,
+		retention: retention,
+	}
+	st.OnTrash(store.record)
+	go store.purgeLoop()
+	return store
+}
+
+// Retention returns how long items stay in the trash.
+func (s *Store) Retention() time.Duration {
+	return s.retention
+}
+
+// Delete moves relPath into the trash and records who deleted it.
+func (s *Store) Delete(relPath, username string) (*Item, error) {
+	entry, err := s.storage.Trash(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &Item{
+		OriginalPath: relPath,
+		TrashName:    entry.Name,
+		IsDir:        entry.IsDir,
+		Size:         entry.Size,
+		DeletedBy:    username,
+		DeletedAt:    time.Now(),
+	}
+
+	if err := s.insert(item); err != nil {
+		// Put the item back so nothing is lost without a record
+		if restoreErr := s.storage.RestoreTrash(entry.Name, relPath); restoreErr != nil {
+			log.Printf("trash: failed to restore %q after insert error: %v", relPath, restoreErr)
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// record adds an item that storage trashed itself: one replaced by a move or
+// copy with overwrite. Who replaced it is not known there.
+func (s *Store) record(entry storage.TrashEntry) {
+	item := &Item{
+		OriginalPath: "/" + entry.Path,
+		TrashName:    entry.Name,
+		IsDir:        entry.IsDir,
+		Size:         entry.Size,
+		DeletedAt:    time.Now(),
+	}
+	if err := s.insert(item); err != nil {
+		log.Printf("trash: failed to record replaced %q: %v", entry.Path, err)
+	}
+}
+
+// insert stores item and sets its ID.
+func (s *Store) insert(item *Item) error {
+	result, err := s.db.Exec(
+		"INSERT INTO trash (original_path, trash_name, is_dir, size, deleted_by, deleted_at) VALUES (?, ?, ?, ?, ?, ?)",
+		item.OriginalPath, item.TrashName, item.IsDir, item.Size, item.DeletedBy, item.DeletedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert trash item: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	item.ID = int(id)
+	return nil
+}
+
+// Get retrieves a trash item by ID.
+func (s *Store) Get(id int) (*Item, error) {
+	var item Item
+	var deletedBy sql.NullString
+
+	err := s.db.QueryRow(
+		"SELECT id, original_path, trash_name, is_dir, size, deleted_by, deleted_at FROM trash WHERE id = ?",
+		id,
+	).Scan(&item.ID, &item.OriginalPath, &item.TrashName, &item.IsDir, &item.Size, &deletedBy, &item.DeletedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query trash item: %w", err)
+	}
+
+	item.DeletedBy = deletedBy.String
+	return &item, nil
+}
+
+// List returns all trash items, most recently deleted first.
+func (s *Store) List() ([]*Item, error) {
+	rows, err := s.db.Query(
+		"SELECT id, original_path, trash_name, is_dir, size, deleted_by, deleted_at FROM trash ORDER BY deleted_at DESC",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*Item
+	for rows.Next() {
+		var item Item
+		var deletedBy sql.NullString
+
+		if err := rows.Scan(&item.ID, &item.OriginalPath, &item.TrashName, &item.IsDir, &item.Size, &deletedBy, &item.DeletedAt); err != nil {
+			continue
+		}
+
+		item.DeletedBy = deletedBy.String
+		items = append(items, &item)
+	}
+
+	return items, nil
+}
+
+// Restore moves an item back to its original path and removes the record.
+// Returns storage.ErrExists if something now occupies that path.
+func (s *Store) Restore(id int) (*Item, error) {
+	item, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.RestoreTrash(item.TrashName, item.OriginalPath); err != nil {
+		return item, err
+	}
+
+	if _, err := s.db.Exec("DELETE FROM trash WHERE id = ?", id); err != nil {
+		return item, fmt.Errorf("delete trash record: %w", err)
+	}
+	return item, nil
+}
+
+// Purge permanently deletes an item and its record.
+func (s *Store) Purge(id int) error {
+	item, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.PurgeTrash(item.TrashName); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DELETE FROM trash WHERE id = ?", id)
+	return err
+}
+
+// purgeLoop removes items older than the retention period, at startup and hourly.
+func (s *Store) purgeLoop() {
+	s.purgeExpired()
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.purgeExpired()
+	}
+}
+
+// purgeExpired permanently deletes every item past its retention period.
+func (s *Store) purgeExpired() {
+	items, err := s.List()
+	if err != nil {
+		log.Printf("trash: purge query failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, item := range items {
+		if now.Before(item.PurgeAt(s.retention)) {
+			continue
+		}
+		if err := s.Purge(item.ID); err != nil {
+			log.Printf("trash: failed to purge %q: %v", item.OriginalPath, err)
+		}
+	}
+}
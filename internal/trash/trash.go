@@ -0,0 +1,27 @@
+// Package trash records items moved into the recycle bin and purges them
+// after the configured retention period.
+package trash
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned for unknown trash items.
+var ErrNotFound = errors.New("trash item not found")
+
+// Item represents a deleted file or directory waiting in the trash.
+type Item struct {
+	ID           int
+	OriginalPath string // Path relative to storage root before deletion
+	TrashName    string // Entry name inside the trash dir
+	IsDir        bool
+	Size         int64
+	DeletedBy    string
+	DeletedAt    time.Time
+}
+
+// PurgeAt returns when the item will be removed permanently.
+func (i *Item) PurgeAt(retention time.Duration) time.Time {
+	return i.DeletedAt.Add(retention)
+}
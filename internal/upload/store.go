@@ -0,0 +1,239 @@
+package upload
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store manages resumable upload state in SQLite and staged data in dir.
+type Store struct {
+	db     *sql.DB
+	dir    string
+	expiry time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewStore creates a new upload store and starts the expiry cleanup loop.
+func NewStore(db *sql.DB, dir string, expiry time.Duration) *Store {
+	store := &Store{
+		db:     db,
+		dir:    dir,
+		expiry: expiry,
+		locks:  make(map[string]*sync.Mutex),
+	}
+	go store.cleanupLoop()
+	return store
+}
+
+// DataPath returns the staging file path for an upload.
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+// Create registers a new upload and creates its empty staging file.
+func (s *Store) Create(path string, size int64, metadata, username string) (*Upload, error) {
+	now := time.Now()
+	u := &Upload{
+		ID:        GenerateID(),
+		Path:      path,
+		Size:      size,
+		Metadata:  metadata,
+		Username:  username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiry),
+	}
+
+	// Record first so the cleanup loop never mistakes the new file for an orphan
+	_, err := s.db.Exec(
+		"INSERT INTO uploads (id, path, size, offset, metadata, username, created_at, expires_at) VALUES (?, ?, ?, 0, ?, ?, ?, ?)",
+		u.ID, u.Path, u.Size, u.Metadata, u.Username, u.CreatedAt, u.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert upload: %w", err)
+	}
+
+	f, err := os.OpenFile(s.DataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		s.db.Exec("DELETE FROM uploads WHERE id = ?", u.ID)
+		return nil, fmt.Errorf("create staging file: %w", err)
+	}
+	f.Close()
+
+	return u, nil
+}
+
+// Get retrieves an upload by ID. Expired uploads are reported as ErrNotFound.
+func (s *Store) Get(id string) (*Upload, error) {
+	var u Upload
+	var metadata, username sql.NullString
+
+	err := s.db.QueryRow(
+		"SELECT id, path, size, offset, metadata, username, created_at, expires_at FROM uploads WHERE id = ?",
+		id,
+	).Scan(&u.ID, &u.Path, &u.Size, &u.Offset, &metadata, &username, &u.CreatedAt, &u.ExpiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query upload: %w", err)
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	u.Metadata = metadata.String
+	u.Username = username.String
+	return &u, nil
+}
+
+// Append writes a chunk from r starting at offset and records the new offset.
+// Bytes received before a read error (e.g. client disconnect) are kept, so the
+// client can resume from the returned upload's Offset. A chunk that goes past
+// the announced size is rejected whole with ErrTooLong.
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Upload, error) {
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return nil, ErrLocked
+	}
+	defer lock.Unlock()
+
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.DataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return u, fmt.Errorf("open staging file: %w", err)
+	}
+	defer f.Close()
+
+	// Drop any bytes written after the last recorded offset (e.g. crash mid-chunk)
+	if err := f.Truncate(u.Offset); err != nil {
+		return u, fmt.Errorf("truncate staging file: %w", err)
+	}
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		return u, fmt.Errorf("seek staging file: %w", err)
+	}
+
+	remaining := u.Size - u.Offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining))
+	if copyErr == nil && n == remaining {
+		var extra [1]byte
+		if m, _ := io.ReadFull(r, extra[:]); m > 0 {
+			if err := f.Truncate(u.Offset); err != nil {
+				return u, fmt.Errorf("truncate staging file: %w", err)
+			}
+			return u, ErrTooLong
+		}
+	}
+	if n > 0 {
+		if err := f.Sync(); err != nil {
+			return u, fmt.Errorf("sync staging file: %w", err)
+		}
+		u.Offset += n
+		u.ExpiresAt = time.Now().Add(s.expiry)
+		if _, err := s.db.Exec("UPDATE uploads SET offset = ?, expires_at = ? WHERE id = ?", u.Offset, u.ExpiresAt, id); err != nil {
+			return u, fmt.Errorf("update upload offset: %w", err)
+		}
+	}
+
+	return u, copyErr
+}
+
+// Delete removes an upload record and its staging file.
+func (s *Store) Delete(id string) error {
+	if _, err := s.db.Exec("DELETE FROM uploads WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.DataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// lock returns the per-upload mutex that serializes chunk writes.
+func (s *Store) lock(id string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	return l
+}
+
+// cleanupLoop removes expired uploads at startup and then periodically.
+func (s *Store) cleanupLoop() {
+	s.cleanup()
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.cleanup()
+	}
+}
+
+// cleanup deletes expired uploads and staging files that have no record.
+func (s *Store) cleanup() {
+	rows, err := s.db.Query("SELECT id, expires_at FROM uploads")
+	if err != nil {
+		log.Printf("uploads: cleanup query failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	known := make(map[string]bool)
+	var expired []string
+	for rows.Next() {
+		var id string
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &expiresAt); err != nil {
+			continue
+		}
+		known[id] = true
+		if now.After(expiresAt) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range expired {
+		if err := s.Delete(id); err != nil {
+			log.Printf("uploads: failed to delete expired upload %q: %v", id, err)
+		}
+	}
+
+	// Orphaned staging files (record deleted while the server was down)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok || known[id] {
+			continue
+		}
+		os.Remove(filepath.Join(s.dir, entry.Name()))
+	}
+}
@@ -0,0 +1,152 @@
+package upload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"nas-dop/internal/db"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "test.sqlite"), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.RunMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "uploads")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(database.DB(), tmp, time.Hour)
+}
+
+func TestAppend(t *testing.T) {
+	type chunk struct {
+		offset int64
+		body   io.Reader
+		err    error
+		after  int64 // Offset once the chunk is handled
+	}
+	tests := []struct {
+		name   string
+		size   int64
+		chunks []chunk
+		data   string // Staged bytes at the end
+	}{
+		{
+			name: "whole upload in one chunk",
+			size: 10,
+			chunks: []chunk{
+				{0, strings.NewReader("helloworld"), nil, 10},
+			},
+			data: "helloworld",
+		},
+		{
+			name: "several chunks",
+			size: 10,
+			chunks: []chunk{
+				{0, strings.NewReader("hel"), nil, 3},
+				{3, strings.NewReader("lowo"), nil, 7},
+				{7, strings.NewReader("rld"), nil, 10},
+			},
+			data: "helloworld",
+		},
+		{
+			name: "offset behind",
+			size: 10,
+			chunks: []chunk{
+				{0, strings.NewReader("hello"), nil, 5},
+				{3, strings.NewReader("loworld"), ErrOffsetMismatch, 5},
+			},
+			data: "hello",
+		},
+		{
+			name: "offset ahead",
+			size: 10,
+			chunks: []chunk{
+				{4, strings.NewReader("oworld"), ErrOffsetMismatch, 0},
+			},
+			data: "",
+		},
+		{
+			name: "chunk past the length is refused whole",
+			size: 10,
+			chunks: []chunk{
+				{0, strings.NewReader("hello"), nil, 5},
+				{5, strings.NewReader("world!"), ErrTooLong, 5},
+				{5, strings.NewReader("world"), nil, 10},
+			},
+			data: "helloworld",
+		},
+		{
+			name: "chunk on a complete upload",
+			size: 5,
+			chunks: []chunk{
+				{0, strings.NewReader("hello"), nil, 5},
+				{5, strings.NewReader("!"), ErrTooLong, 5},
+			},
+			data: "hello",
+		},
+		{
+			name: "interrupted chunk keeps what arrived",
+			size: 10,
+			chunks: []chunk{
+				{0, io.MultiReader(strings.NewReader("hell"), iotest.ErrReader(io.ErrUnexpectedEOF)), io.ErrUnexpectedEOF, 4},
+				{4, strings.NewReader("oworld"), nil, 10},
+			},
+			data: "helloworld",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			up, err := s.Create("dir/file.bin", tt.size, "", "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, c := range tt.chunks {
+				got, err := s.Append(up.ID, c.offset, c.body)
+				if !errors.Is(err, c.err) {
+					t.Fatalf("chunk %d: err = %v, want %v", i, err, c.err)
+				}
+				if got.Offset != c.after {
+					t.Errorf("chunk %d: offset %d, want %d", i, got.Offset, c.after)
+				}
+				stored, err := s.Get(up.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Offset != c.after {
+					t.Errorf("chunk %d: recorded offset %d, want %d", i, stored.Offset, c.after)
+				}
+			}
+
+			data, err := os.ReadFile(s.DataPath(up.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("staged %q, want %q", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestAppendUnknownUpload(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.Append("missing", 0, strings.NewReader("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package upload tracks resumable (tus 1.0) uploads in SQLite and stages their
+// data on disk until the last chunk arrives.
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// TusVersion is the tus protocol version implemented by the server.
+const TusVersion = "1.0.0"
+
+var (
+	// ErrNotFound is returned for unknown or expired uploads.
+	ErrNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch is returned when a chunk does not start at the current offset.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrLocked is returned when another request is already writing to the upload.
+	ErrLocked = errors.New("upload is locked by another request")
+	// ErrTooLong is returned when a chunk goes past the announced Upload-Length.
+	ErrTooLong = errors.New("chunk exceeds upload length")
+)
+
+// Upload represents a resumable upload in progress.
+type Upload struct {
+	ID        string
+	Path      string // Target path relative to storage root
+	Size      int64  // Total length announced by the client (Upload-Length)
+	Offset    int64  // Bytes received so far
+	Metadata  string // Raw Upload-Metadata header
+	Username  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Complete reports whether all bytes have been received.
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Size
+}
+
+// GenerateID generates a random upload ID (16 bytes, hex encoded).
+func GenerateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseMetadata decodes a tus Upload-Metadata header
+// ("key base64value,key2 base64value2") into a map. Invalid pairs are skipped.
+func ParseMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			continue
+		}
+		meta[key] = string(value)
+	}
+	return meta
+}
@@ -0,0 +1,283 @@
+package versions
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"nas-dop/internal/storage"
+)
+
+// underPath matches a path or anything under it; bind the path three times.
+const underPath = "(path = ? OR (path >= ? || '/' AND path < ? || '0'))"
+
+// Store manages version records in SQLite; file moves go through storage.
+type Store struct {
+	db          *sql.DB
+	storage     *storage.Storage
+	defaultKeep int
+}
+
+// NewStore creates a version store and registers it with storage, which then
+// keeps the previous file whenever a write replaces one. defaultKeep applies to
+// folders without their own limit.
+func NewStore(db *sql.DB, st *storage.Storage, defaultKeep int) *Store {
+	store := &Store{
+		db:          db,
+		storage:     st,
+		defaultKeep: defaultKeep,
+	}
+	st.OnVersion(store.record)
+	return store
+}
+
+// DefaultKeep returns how many versions are kept where no folder limit is set.
+func (s *Store) DefaultKeep() int {
+	return s.defaultKeep
+}
+
+// record stores a version kept by storage and prunes the oldest ones beyond the limit.
+func (s *Store) record(entry storage.VersionEntry) {
+	_, err := s.db.Exec(
+		"INSERT INTO versions (path, version_name, size, mod_time, created_at) VALUES (?, ?, ?, ?, ?)",
+		entry.Path, entry.Name, entry.Size, entry.ModTime, time.Now(),
+	)
+	if err != nil {
+		log.Printf("versions: failed to record %q: %v", entry.Path, err)
+		// Without a record nobody could find it again
+		if err := s.storage.PurgeVersion(entry.Name); err != nil {
+			log.Printf("versions: failed to remove unrecorded %q: %v", entry.Name, err)
+		}
+		return
+	}
+
+	s.prune(entry.Path)
+}
+
+// prune deletes the oldest versions of relPath beyond the applicable limit.
+func (s *Store) prune(relPath string) {
+	list, err := s.List(relPath)
+	if err != nil {
+		log.Printf("versions: failed to list %q for pruning: %v", relPath, err)
+		return
+	}
+
+	keep := s.Keep(relPath)
+	for i := keep; i < len(list); i++ {
+		if err := s.Purge(list[i].ID); err != nil {
+			log.Printf("versions: failed to prune %q: %v", list[i].Name, err)
+		}
+	}
+}
+
+const versionColumns = "id, path, version_name, size, mod_time, created_at"
+
+func scanVersion(row interface{ Scan(...any) error }) (*Version, error) {
+	var v Version
+	var modTime sql.NullTime
+	if err := row.Scan(&v.ID, &v.Path, &v.Name, &v.Size, &modTime, &v.CreatedAt); err != nil {
+		return nil, err
+	}
+	v.ModTime = modTime.Time
+	return &v, nil
+}
+
+// Get retrieves a version by ID.
+func (s *Store) Get(id int) (*Version, error) {
+	v, err := scanVersion(s.db.QueryRow("SELECT "+versionColumns+" FROM versions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query version: %w", err)
+	}
+	return v, nil
+}
+
+// List returns the versions of a file, newest first.
+func (s *Store) List(relPath string) ([]*Version, error) {
+	return s.query("SELECT "+versionColumns+" FROM versions WHERE path = ? ORDER BY created_at DESC, id DESC", cleanPath(relPath))
+}
+
+// Recent returns the most recently kept versions across all files.
+func (s *Store) Recent(limit int) ([]*Version, error) {
+	return s.query("SELECT "+versionColumns+" FROM versions ORDER BY created_at DESC, id DESC LIMIT ?", limit)
+}
+
+func (s *Store) query(q string, args ...any) ([]*Version, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// Counts returns how many versions each file directly inside dir has, by file name.
+func (s *Store) Counts(dir string) (map[string]int, error) {
+	dir = cleanPath(dir)
+	query := "SELECT path, COUNT(*) FROM versions"
+	var args []interface{}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+		query += " WHERE path >= ? || '/' AND path < ? || '0'"
+		args = append(args, dir, dir)
+	}
+
+	rows, err := s.db.Query(query+" GROUP BY path", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var p string
+		var n int
+		if err := rows.Scan(&p, &n); err != nil {
+			continue
+		}
+		name := strings.TrimPrefix(p, prefix)
+		if !strings.Contains(name, "/") {
+			counts[name] = n
+		}
+	}
+	return counts, rows.Err()
+}
+
+// Restore puts a version back in place of the current file. The current file
+// is kept as a new version, so the restore itself can be undone.
+func (s *Store) Restore(id int) (*Version, error) {
+	v, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.RestoreVersion(v.Name, v.Path); err != nil {
+		return v, err
+	}
+
+	if _, err := s.db.Exec("DELETE FROM versions WHERE id = ?", id); err != nil {
+		return v, fmt.Errorf("delete version record: %w", err)
+	}
+	return v, nil
+}
+
+// Purge permanently deletes a version and its record.
+func (s *Store) Purge(id int) error {
+	v, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.PurgeVersion(v.Name); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("DELETE FROM versions WHERE id = ?", id)
+	return err
+}
+
+// Moved keeps version records attached to files that were renamed or moved,
+// including everything under a moved folder.
+func (s *Store) Moved(oldPath, newPath string) {
+	oldPath, newPath = cleanPath(oldPath), cleanPath(newPath)
+	_, err := s.db.Exec(
+		"UPDATE versions SET path = ? || substr(path, length(?)+1) WHERE "+underPath,
+		newPath, oldPath, oldPath, oldPath, oldPath,
+	)
+	if err != nil {
+		log.Printf("versions: failed to move records %q -> %q: %v", oldPath, newPath, err)
+	}
+}
+
+// Keep returns how many versions are kept for a file: the limit of its nearest
+// ancestor folder that has one, else the default.
+func (s *Store) Keep(relPath string) int {
+	for dir := path.Dir("/" + cleanPath(relPath)); ; dir = path.Dir(dir) {
+		var keep int
+		err := s.db.QueryRow("SELECT keep FROM version_limits WHERE folder = ?", strings.Trim(dir, "/")).Scan(&keep)
+		if err == nil {
+			return keep
+		}
+		if dir == "/" {
+			return s.defaultKeep
+		}
+	}
+}
+
+// Limits returns all folder limits, ordered by folder.
+func (s *Store) Limits() ([]*Limit, error) {
+	rows, err := s.db.Query("SELECT folder, keep FROM version_limits ORDER BY folder")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []*Limit
+	for rows.Next() {
+		var l Limit
+		if err := rows.Scan(&l.Folder, &l.Keep); err != nil {
+			continue
+		}
+		limits = append(limits, &l)
+	}
+	return limits, rows.Err()
+}
+
+// SetLimit sets how many versions to keep per file under folder.
+func (s *Store) SetLimit(folder string, keep int) error {
+	if keep < 0 {
+		return fmt.Errorf("keep must not be negative")
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO version_limits (folder, keep) VALUES (?, ?) ON CONFLICT(folder) DO UPDATE SET keep = excluded.keep",
+		cleanPath(folder), keep,
+	)
+	if err != nil {
+		return err
+	}
+
+	s.pruneAll()
+	return nil
+}
+
+// RemoveLimit removes a folder limit; the parent's limit or the default applies again.
+func (s *Store) RemoveLimit(folder string) error {
+	_, err := s.db.Exec("DELETE FROM version_limits WHERE folder = ?", cleanPath(folder))
+	return err
+}
+
+// pruneAll applies the current limits to every file with versions.
+func (s *Store) pruneAll() {
+	rows, err := s.db.Query("SELECT DISTINCT path FROM versions")
+	if err != nil {
+		log.Printf("versions: prune query failed: %v", err)
+		return
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil {
+			paths = append(paths, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range paths {
+		s.prune(p)
+	}
+}
@@ -0,0 +1,34 @@
+// Package versions records previous versions of overwritten files and prunes
+// them to a per-folder limit.
+package versions
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for unknown versions.
+var ErrNotFound = errors.New("version not found")
+
+// Version is a previous copy of a file, kept when an upload replaced it.
+type Version struct {
+	ID        int
+	Path      string // Path relative to storage root the file was replaced at
+	Name      string // Entry name inside the versions dir
+	Size      int64
+	ModTime   time.Time // Modification time of the replaced file
+	CreatedAt time.Time // When it was replaced
+}
+
+// Limit caps how many versions are kept per file under a folder.
+type Limit struct {
+	Folder string // Path relative to storage root ("" = root)
+	Keep   int
+}
+
+// cleanPath normalizes a path the way storage does: slash-separated, no leading slash.
+func cleanPath(relPath string) string {
+	return strings.Trim(path.Clean("/"+relPath), "/")
+}
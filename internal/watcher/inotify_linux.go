@@ -0,0 +1,196 @@
+//go:build linux
+
+package watcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"nas-dop/internal/storage"
+)
+
+// watchMask selects the inotify events that change what the app shows.
+// File contents are reported on close, so a copy in progress is seen once, complete.
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotify watches every directory under root (inotify is not recursive).
+// All state is owned by the read loop goroutine.
+type inotify struct {
+	w    *Watcher
+	fd   int
+	root string
+	dirs map[int32]string // Watch descriptor -> absolute directory
+}
+
+// pendingMove is the first half of a rename, waiting for its MOVED_TO.
+type pendingMove struct {
+	abs   string
+	isDir bool
+}
+
+// startInotify adds watches for the whole tree and starts reading events.
+func (w *Watcher) startInotify(root string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+
+	in := &inotify{w: w, fd: fd, root: root, dirs: make(map[int32]string)}
+	if err := in.addTree(root); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go in.readLoop()
+	return nil
+}
+
+// addTree watches dir and every directory beneath it. Watching an already
+// watched directory returns its existing descriptor, which re-maps it to the
+// new path after a rename.
+func (in *inotify) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Vanished while walking
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != in.root && in.w.ignored(in.rel(p)) {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(in.fd, p, watchMask)
+		if errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("inotify watch limit reached at %s (raise fs.inotify.max_user_watches): %w", p, err)
+		}
+		if err != nil {
+			return nil
+		}
+		in.dirs[int32(wd)] = p
+		return nil
+	})
+}
+
+// removeTree drops watches for dir and everything beneath it (moved out of ROOT).
+func (in *inotify) removeTree(dir string) {
+	for wd, p := range in.dirs {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			syscall.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.dirs, wd)
+		}
+	}
+}
+
+// rel converts an absolute path under root to a slash-separated relative path.
+func (in *inotify) rel(abs string) string {
+	return filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(abs, in.root), string(filepath.Separator)))
+}
+
+// readLoop reads and dispatches events until the descriptor fails.
+func (in *inotify) readLoop() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := syscall.Read(in.fd, buf)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Printf("watcher: inotify read failed, relying on periodic rescan: %v", err)
+			syscall.Close(in.fd)
+			return
+		}
+
+		// Renames arrive as MOVED_FROM + MOVED_TO with a shared cookie,
+		// normally in the same read
+		moves := make(map[uint32]pendingMove)
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			cookie := binary.NativeEndian.Uint32(buf[offset+8:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			if nameStart+nameLen > n {
+				break
+			}
+			name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			in.handle(wd, mask, cookie, name, moves)
+		}
+
+		// A MOVED_FROM without its MOVED_TO left ROOT
+		for _, m := range moves {
+			if m.isDir {
+				in.removeTree(m.abs)
+			}
+			in.w.emit(storage.Change{Op: storage.ChangeRemove, Path: in.rel(m.abs)})
+		}
+	}
+}
+
+// handle turns one inotify event into storage changes.
+func (in *inotify) handle(wd int32, mask, cookie uint32, name string, moves map[uint32]pendingMove) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		log.Printf("watcher: inotify queue overflow, rescanning")
+		in.w.RequestRescan()
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(in.dirs, wd)
+		return
+	}
+
+	dir, ok := in.dirs[wd]
+	if !ok || name == "" {
+		return
+	}
+	abs := filepath.Join(dir, name)
+	rel := in.rel(abs)
+	isDir := mask&syscall.IN_ISDIR != 0
+
+	switch {
+	case mask&syscall.IN_MOVED_FROM != 0:
+		moves[cookie] = pendingMove{abs: abs, isDir: isDir}
+
+	case mask&syscall.IN_MOVED_TO != 0:
+		if isDir && !in.w.ignored(rel) {
+			if err := in.addTree(abs); err != nil {
+				log.Printf("watcher: %v", err)
+			}
+		}
+		from, paired := moves[cookie]
+		delete(moves, cookie)
+		// Renames from an internal name (e.g. an upload temp file) are new files
+		if paired && !in.w.ignored(in.rel(from.abs)) {
+			in.w.emit(storage.Change{Op: storage.ChangeRename, Path: rel, OldPath: in.rel(from.abs)})
+		} else {
+			in.w.emit(storage.Change{Op: storage.ChangeWrite, Path: rel})
+		}
+
+	case mask&syscall.IN_CREATE != 0:
+		// Files are reported on close; new directories right away, since
+		// anything copied into them before the watch exists is only found by walking
+		if isDir && !in.w.ignored(rel) {
+			if err := in.addTree(abs); err != nil {
+				log.Printf("watcher: %v", err)
+			}
+			in.w.emit(storage.Change{Op: storage.ChangeWrite, Path: rel})
+		}
+
+	case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_ATTRIB) != 0:
+		if !isDir {
+			in.w.emit(storage.Change{Op: storage.ChangeWrite, Path: rel})
+		}
+
+	case mask&syscall.IN_DELETE != 0:
+		in.w.emit(storage.Change{Op: storage.ChangeRemove, Path: rel})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// startInotify is only implemented on Linux; elsewhere the periodic rescan is used.
+func (w *Watcher) startInotify(root string) error {
+	return errors.New("inotify is only available on Linux")
+}
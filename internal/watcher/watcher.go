@@ -0,0 +1,197 @@
+// Package watcher reports changes made to ROOT outside the app (e.g. photos
+// copied onto the disk over SMB) as storage.Change events, so subscribers such
+// as the search index, thumbnail cache and shares stay current. On Linux with
+// the local driver it uses inotify; a periodic rescan diffs the whole tree as
+// a fallback for anything inotify misses (queue overflow, watch limits, other drivers).
+package watcher
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nas-dop/internal/storage"
+)
+
+// Watcher emits storage changes observed outside Storage.
+type Watcher struct {
+	storage  *storage.Storage
+	interval time.Duration // Rescan period (0 = no periodic rescan)
+	exclude  []string      // Paths relative to root that are never reported (app data inside ROOT)
+	rescan   chan struct{}
+}
+
+// entry is what a rescan remembers about a path.
+type entry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// New creates a watcher. exclude lists absolute directories (DB, upload
+// staging, trash) whose contents must not be reported if they live inside ROOT.
+func New(st *storage.Storage, interval time.Duration, exclude ...string) *Watcher {
+	w := &Watcher{
+		storage:  st,
+		interval: interval,
+		rescan:   make(chan struct{}, 1),
+	}
+
+	if local, ok := st.Backend().(*storage.LocalBackend); ok {
+		root := local.Root()
+		for _, dir := range exclude {
+			if dir == "" {
+				continue
+			}
+			if real, err := filepath.EvalSymlinks(dir); err == nil {
+				dir = real
+			}
+			rel, err := filepath.Rel(root, dir)
+			if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			w.exclude = append(w.exclude, filepath.ToSlash(rel))
+		}
+	}
+	return w
+}
+
+// Start begins watching in the background. With useInotify (and the local
+// driver on Linux) changes are reported as they happen; otherwise only the
+// periodic rescan runs.
+func (w *Watcher) Start(useInotify bool) {
+	if useInotify {
+		if local, ok := w.storage.Backend().(*storage.LocalBackend); ok {
+			if err := w.startInotify(local.Root()); err != nil {
+				log.Printf("watcher: inotify unavailable, using periodic rescan only: %v", err)
+			} else {
+				log.Printf("watcher: watching %s with inotify", local.Root())
+			}
+		}
+	}
+
+	go w.rescanLoop()
+}
+
+// RequestRescan schedules a full rescan (e.g. after an inotify queue overflow).
+func (w *Watcher) RequestRescan() {
+	select {
+	case w.rescan <- struct{}{}:
+	default:
+	}
+}
+
+// rescanLoop takes a baseline snapshot, then diffs against it every interval
+// and whenever a rescan is requested.
+func (w *Watcher) rescanLoop() {
+	snapshot := w.scan()
+
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-w.rescan:
+		}
+		current := w.scan()
+		w.diff(snapshot, current)
+		snapshot = current
+	}
+}
+
+// scan walks the tree through Storage, so it works with every driver.
+func (w *Watcher) scan() map[string]entry {
+	snapshot := make(map[string]entry)
+	w.walk("", snapshot)
+	return snapshot
+}
+
+func (w *Watcher) walk(dir string, snapshot map[string]entry) {
+	files, err := w.storage.List(dir)
+	if err != nil {
+		log.Printf("watcher: failed to scan %q: %v", dir, err)
+		return
+	}
+
+	for _, f := range files {
+		p := path.Join(dir, f.Name)
+		if w.ignored(p) {
+			continue
+		}
+		snapshot[p] = entry{size: f.Size, modTime: f.ModTime, isDir: f.IsDir}
+		if f.IsDir {
+			w.walk(p, snapshot)
+		}
+	}
+}
+
+// diff emits changes between two snapshots. Only the topmost new or removed
+// path is reported; subscribers handle directory contents themselves.
+func (w *Watcher) diff(old, current map[string]entry) {
+	var removed, written []string
+	for p := range old {
+		if _, ok := current[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	for p, cur := range current {
+		prev, ok := old[p]
+		switch {
+		case !ok:
+			written = append(written, p)
+		case cur.isDir != prev.isDir:
+			written = append(written, p)
+		case !cur.isDir && (cur.size != prev.size || !cur.modTime.Equal(prev.modTime)):
+			written = append(written, p)
+		}
+	}
+
+	for _, p := range topmost(removed) {
+		w.emit(storage.Change{Op: storage.ChangeRemove, Path: p})
+	}
+	for _, p := range topmost(written) {
+		w.emit(storage.Change{Op: storage.ChangeWrite, Path: p})
+	}
+}
+
+// topmost sorts paths and drops any that lie inside another path in the list.
+func topmost(paths []string) []string {
+	sort.Strings(paths)
+	var out []string
+	for _, p := range paths {
+		if n := len(out); n > 0 && strings.HasPrefix(p, out[n-1]+"/") {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// emit forwards a change to Storage subscribers unless the path is ignored.
+func (w *Watcher) emit(c storage.Change) {
+	if w.ignored(c.Path) {
+		return
+	}
+	w.storage.Notify(c)
+}
+
+// ignored reports whether p (relative to root) is app-internal or excluded.
+func (w *Watcher) ignored(p string) bool {
+	if storage.IsInternal(p) {
+		return true
+	}
+	for _, dir := range w.exclude {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
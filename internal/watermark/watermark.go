@@ -0,0 +1,260 @@
+// Package watermark stamps a share's watermark, a line of text or a PNG logo,
+// on the images visitors of the share get: thumbnails, previews and downloads.
+package watermark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Where the watermark is placed on the image.
+const (
+	PositionCenter      = "center"
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionTile        = "tile" // Repeated over the whole image
+)
+
+// Positions lists the valid positions, in the order forms offer them.
+var Positions = []string{
+	PositionCenter, PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight, PositionTile,
+}
+
+// Defaults and limits for the settings.
+const (
+	DefaultOpacity = 40 // Percent
+	DefaultScale   = 30 // Percent of the image width
+
+	MaxTextLen    = 100     // Characters
+	MaxImageBytes = 1 << 20 // PNG file size
+	maxImageSide  = 4096    // PNG width or height
+)
+
+// Watermark holds a share's watermark settings.
+type Watermark struct {
+	Text     string // Drawn when there is no Image
+	Image    []byte // PNG logo, drawn with its own transparency
+	Position string // One of Positions
+	Opacity  int    // 1-100 percent
+	Scale    int    // Watermark width as 1-100 percent of the image width
+}
+
+// Validate checks the settings; errors are worded for the share form.
+func (w *Watermark) Validate() error {
+	if len(w.Image) == 0 {
+		if strings.TrimSpace(w.Text) == "" {
+			return errors.New("enter the watermark text or choose a PNG image")
+		}
+		if utf8.RuneCountInString(w.Text) > MaxTextLen {
+			return fmt.Errorf("watermark text is longer than %d characters", MaxTextLen)
+		}
+	} else {
+		if len(w.Image) > MaxImageBytes {
+			return fmt.Errorf("watermark image is larger than %d KB", MaxImageBytes>>10)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(w.Image))
+		if err != nil {
+			return errors.New("watermark image is not a valid PNG")
+		}
+		if cfg.Width > maxImageSide || cfg.Height > maxImageSide {
+			return fmt.Errorf("watermark image is larger than %d×%d pixels", maxImageSide, maxImageSide)
+		}
+	}
+	valid := false
+	for _, p := range Positions {
+		valid = valid || w.Position == p
+	}
+	if !valid {
+		return errors.New("unknown watermark position")
+	}
+	if w.Opacity < 1 || w.Opacity > 100 {
+		return errors.New("watermark opacity must be between 1 and 100%")
+	}
+	if w.Scale < 1 || w.Scale > 100 {
+		return errors.New("watermark size must be between 1 and 100%")
+	}
+	return nil
+}
+
+// Key identifies the settings in cache names (hex digits), so thumbnails made
+// with other settings, or without a watermark, are never served in their place.
+func (w *Watermark) Key() string {
+	h := sha256.New()
+	h.Write([]byte("v1\x00" + w.Position + "\x00" + w.Text + "\x00"))
+	binary.Write(h, binary.BigEndian, [2]int32{int32(w.Opacity), int32(w.Scale)})
+	h.Write(w.Image)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Draw stamps the watermark on img. It fails when the watermark cannot be
+// rendered, so the image is never handed out without it.
+func (w *Watermark) Draw(img *image.RGBA) error {
+	b := img.Bounds()
+	stamp, err := w.stamp(max(1, b.Dx()*w.Scale/100), b.Dy())
+	if err != nil {
+		return fmt.Errorf("watermark: %w", err)
+	}
+	size := stamp.Bounds().Size()
+	mask := image.NewUniform(color.Alpha{uint8(255 * w.Opacity / 100)})
+	for _, pt := range w.places(b, size) {
+		draw.DrawMask(img, image.Rectangle{pt, pt.Add(size)}, stamp, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+	return nil
+}
+
+// places returns the top-left corners the stamp is drawn at.
+func (w *Watermark) places(b image.Rectangle, size image.Point) []image.Point {
+	margin := min(b.Dx(), b.Dy()) / 30
+	left, top := b.Min.X+margin, b.Min.Y+margin
+	right, bottom := b.Max.X-margin-size.X, b.Max.Y-margin-size.Y
+	switch w.Position {
+	case PositionTopLeft:
+		return []image.Point{{left, top}}
+	case PositionTopRight:
+		return []image.Point{{right, top}}
+	case PositionBottomLeft:
+		return []image.Point{{left, bottom}}
+	case PositionBottomRight:
+		return []image.Point{{right, bottom}}
+	case PositionTile:
+		// Staggered rows, so no band of the image is left clean
+		var pts []image.Point
+		stepX, stepY := size.X+size.X/2, size.Y*3
+		for row, y := 0, b.Min.Y+size.Y; y < b.Max.Y; row, y = row+1, y+stepY {
+			for x := b.Min.X - row%2*stepX/2; x < b.Max.X; x += stepX {
+				pts = append(pts, image.Point{x, y})
+			}
+		}
+		return pts
+	}
+	return []image.Point{{b.Min.X + (b.Dx()-size.X)/2, b.Min.Y + (b.Dy()-size.Y)/2}}
+}
+
+// stamp renders the text or logo width pixels wide (and at most maxHeight
+// high), fully opaque; Draw applies the opacity.
+func (w *Watermark) stamp(width, maxHeight int) (*image.RGBA, error) {
+	if len(w.Image) > 0 {
+		logo, err := w.logo()
+		if err != nil {
+			return nil, fmt.Errorf("decode logo: %w", err)
+		}
+		if logo.Bounds().Empty() {
+			return nil, errors.New("logo has no pixels")
+		}
+		lb := logo.Bounds()
+		height := max(1, lb.Dy()*width/lb.Dx())
+		if height > maxHeight {
+			width, height = max(1, lb.Dx()*maxHeight/lb.Dy()), maxHeight
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), logo, lb, draw.Src, nil)
+		return dst, nil
+	}
+	return textStamp(strings.TrimSpace(w.Text), width, maxHeight)
+}
+
+// minTextSize keeps text on small thumbnails from shrinking to nothing.
+const minTextSize = 6
+
+var (
+	boldOnce sync.Once
+	bold     *opentype.Font
+	boldErr  error
+)
+
+// textStamp draws text in white with a dark shadow, so it shows on light and
+// dark pictures alike, sized to fill width.
+func textStamp(text string, width, maxHeight int) (*image.RGBA, error) {
+	boldOnce.Do(func() {
+		bold, boldErr = opentype.Parse(gobold.TTF)
+	})
+	if boldErr != nil {
+		return nil, fmt.Errorf("load font: %w", boldErr)
+	}
+	if text == "" {
+		return nil, errors.New("no text")
+	}
+
+	// Measure at a reference size, then scale to the width wanted
+	const ref = 100
+	face, err := opentype.NewFace(bold, &opentype.FaceOptions{Size: ref, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	adv := font.MeasureString(face, text)
+	face.Close()
+	if adv <= 0 {
+		return nil, errors.New("text has no width")
+	}
+	size := float64(ref) * float64(width) / (float64(adv) / 64)
+	size = max(minTextSize, min(size, float64(maxHeight)/1.2))
+
+	face, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: size, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	m := face.Metrics()
+	shadow := max(1, int(size/24))
+	w := font.MeasureString(face, text).Ceil() + shadow
+	h := (m.Ascent + m.Descent).Ceil() + shadow
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	d := font.Drawer{Dst: dst, Face: face}
+	d.Src = image.NewUniform(color.RGBA{0, 0, 0, 160})
+	d.Dot = fixed.Point26_6{X: fixed.I(shadow), Y: m.Ascent + fixed.I(shadow)}
+	d.DrawString(text)
+	d.Src = image.White
+	d.Dot = fixed.Point26_6{Y: m.Ascent}
+	d.DrawString(text)
+	return dst, nil
+}
+
+// maxLogos bounds the decoded logos kept between requests.
+const maxLogos = 16
+
+var logos = struct {
+	sync.Mutex
+	m map[string]image.Image
+}{m: make(map[string]image.Image)}
+
+// logo returns the decoded PNG, decoding it once per set of settings.
+func (w *Watermark) logo() (image.Image, error) {
+	key := w.Key()
+	logos.Lock()
+	img, ok := logos.m[key]
+	logos.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(w.Image))
+	if err != nil {
+		return nil, err
+	}
+	logos.Lock()
+	if len(logos.m) >= maxLogos {
+		clear(logos.m)
+	}
+	logos.m[key] = img
+	logos.Unlock()
+	return img, nil
+}
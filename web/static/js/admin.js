@@ -1 +1,118 @@
-/* Optional: HTMX or minimal JS for admin. See docs/plan-from-scratch.md. */
+// Admin UI helpers. Resumable uploads speak tus 1.0 to /files/tus (see handlers_tus.go).
+
+(function () {
+    'use strict';
+
+    const CHUNK_SIZE = 5 * 1024 * 1024; // 5MB per PATCH keeps each request short on slow Wi-Fi
+    const TUS_HEADERS = { 'Tus-Resumable': '1.0.0' };
+
+    document.addEventListener('DOMContentLoaded', init);
+
+    function init() {
+        const form = document.getElementById('resumableForm');
+        if (!form || !window.fetch) return;
+        form.addEventListener('submit', handleResumableSubmit);
+    }
+
+    async function handleResumableSubmit(e) {
+        e.preventDefault();
+        const form = e.target;
+        const input = form.querySelector('input[type="file"]');
+        const status = document.getElementById('resumableStatus');
+        const conflict = document.getElementById('resumable-conflict');
+        const dir = form.dataset.path || '';
+        const skipped = [];
+
+        for (const file of input.files) {
+            try {
+                await uploadFile(file, dir, conflict ? conflict.value : '', (sent) => {
+                    status.textContent = `${file.name}: ${Math.floor(sent * 100 / Math.max(file.size, 1))}%`;
+                });
+            } catch (err) {
+                if (err.skipped) {
+                    skipped.push(file.name);
+                    continue;
+                }
+                status.textContent = `${file.name}: ${err.message} (submit again to resume)`;
+                return;
+            }
+        }
+        if (skipped.length) {
+            status.textContent = `Skipped (name already exists): ${skipped.join(', ')}`;
+            return;
+        }
+        window.location.reload();
+    }
+
+    // uploadFile creates (or resumes) a tus upload and sends the remaining chunks.
+    async function uploadFile(file, dir, conflict, onProgress) {
+        const key = `tus:${dir}:${file.name}:${file.size}:${file.lastModified}`;
+        let url = localStorage.getItem(key);
+        let offset = url ? await fetchOffset(url) : null;
+
+        if (offset === null) {
+            url = await createUpload(file, dir, conflict);
+            localStorage.setItem(key, url);
+            offset = 0;
+        }
+
+        while (offset < file.size) {
+            const chunk = file.slice(offset, offset + CHUNK_SIZE);
+            const res = await fetch(url, {
+                method: 'PATCH',
+                headers: Object.assign({
+                    'Upload-Offset': String(offset),
+                    'Content-Type': 'application/offset+octet-stream',
+                }, TUS_HEADERS),
+                body: chunk,
+            });
+            if (res.status === 409) {
+                offset = await fetchOffset(url);
+                if (offset === null) throw new Error('upload expired');
+                continue;
+            }
+            if (res.status === 409) {
+                localStorage.removeItem(key);
+                throw skippedError();
+            }
+            if (!res.ok) throw new Error(`upload failed (${res.status})`);
+            offset = parseInt(res.headers.get('Upload-Offset'), 10);
+            onProgress(offset);
+        }
+
+        localStorage.removeItem(key);
+    }
+
+    async function createUpload(file, dir, conflict) {
+        const res = await fetch('/files/tus', {
+            method: 'POST',
+            headers: Object.assign({
+                'Upload-Length': String(file.size),
+                'Upload-Metadata': `filename ${b64(file.name)},path ${b64(dir)},conflict ${b64(conflict)}`,
+            }, TUS_HEADERS),
+        });
+        if (res.status === 413) throw new Error('file too large');
+        if (res.status === 409) throw skippedError();
+        if (!res.ok) throw new Error(`could not start upload (${res.status})`);
+        return res.headers.get('Location');
+    }
+
+    // fetchOffset returns the server's offset for url, or null if the upload is gone.
+    async function fetchOffset(url) {
+        const res = await fetch(url, { method: 'HEAD', headers: TUS_HEADERS });
+        if (!res.ok) return null;
+        return parseInt(res.headers.get('Upload-Offset'), 10);
+    }
+
+    // skippedError marks a file the server skipped because its name exists.
+    function skippedError() {
+        const err = new Error('already exists');
+        err.skipped = true;
+        return err;
+    }
+
+    // b64 encodes UTF-8 text as base64 for Upload-Metadata.
+    function b64(text) {
+        return btoa(String.fromCharCode(...new TextEncoder().encode(text)));
+    }
+})();
@@ -1,4 +1,4 @@
-// Share page interactivity: view switching, image preview, selection management
+// Share page interactivity: view switching, thumbnail fallbacks, selection management
 
 (function () {
     'use strict';
@@ -6,13 +6,10 @@
     // State
     let currentView = localStorage.getItem('shareView') || 'grid';
     let selectedFiles = new Set();
-    let imageFiles = [];
-    let currentImageIndex = 0;
 
     // DOM elements
     let gridViewBtn, listViewBtn, gridContainer, listContainer;
     let selectAllCheckbox, downloadBtn, fileCountEl;
-    let modal, modalImg, modalClose, modalPrev, modalNext;
 
     // Initialize on page load
     document.addEventListener('DOMContentLoaded', init);
@@ -26,11 +23,6 @@
         selectAllCheckbox = document.getElementById('selectAll');
         downloadBtn = document.getElementById('downloadBtn');
         fileCountEl = document.getElementById('fileCount');
-        modal = document.getElementById('imageModal');
-        modalImg = document.getElementById('modalImage');
-        modalClose = document.getElementById('modalClose');
-        modalPrev = document.getElementById('modalPrev');
-        modalNext = document.getElementById('modalNext');
 
         // Set initial view
         setView(currentView);
@@ -39,18 +31,12 @@
         if (gridViewBtn) gridViewBtn.addEventListener('click', () => setView('grid'));
         if (listViewBtn) listViewBtn.addEventListener('click', () => setView('list'));
         if (selectAllCheckbox) selectAllCheckbox.addEventListener('change', handleSelectAll);
-        if (modalClose) modalClose.addEventListener('click', closeModal);
-        if (modalPrev) modalPrev.addEventListener('click', showPrevImage);
-        if (modalNext) modalNext.addEventListener('click', showNextImage);
 
         // Setup file checkboxes
         setupCheckboxes();
 
-        // Setup image thumbnails for preview
-        setupImagePreviews();
-
-        // Keyboard shortcuts
-        document.addEventListener('keydown', handleKeyboard);
+        // Replace thumbnails that failed with an icon
+        setupThumbnails();
 
         // Update UI
         updateSelectionUI();
@@ -145,93 +131,25 @@
         }
     }
 
-    function setupImagePreviews() {
-        // Collect all image elements
-        const imgElements = document.querySelectorAll('.preview-thumbnail');
-
-        imgElements.forEach((img, index) => {
-            const filename = img.dataset.filename;
-            const src = img.src;
-
-            imageFiles.push({ filename, src, element: img });
-
-            // Add click listener to open modal
-            img.addEventListener('click', () => openModal(index));
-            img.style.cursor = 'pointer';
-            img.title = 'Click to preview';
-        });
-    }
-
-    function openModal(index) {
-        if (!modal || !modalImg || imageFiles.length === 0) return;
-
-        currentImageIndex = index;
-        const image = imageFiles[index];
-
-        // Load full-size image (replace /thumb/ with /dl/ for higher quality)
-        const fullSizeSrc = image.src.replace('/thumb/', '/dl/');
-        modalImg.src = fullSizeSrc;
-        modalImg.alt = image.filename;
-
-        modal.style.display = 'flex';
-        document.body.style.overflow = 'hidden';
-
-        // Show/hide navigation buttons
-        updateModalNavigation();
-    }
-
-    function closeModal() {
-        if (modal) {
-            modal.style.display = 'none';
-            document.body.style.overflow = '';
-        }
-    }
-
-    function showPrevImage() {
-        if (imageFiles.length === 0) return;
-        currentImageIndex = (currentImageIndex - 1 + imageFiles.length) % imageFiles.length;
-        const image = imageFiles[currentImageIndex];
-        const fullSizeSrc = image.src.replace('/thumb/', '/dl/');
-        modalImg.src = fullSizeSrc;
-        modalImg.alt = image.filename;
-        updateModalNavigation();
-    }
-
-    function showNextImage() {
-        if (imageFiles.length === 0) return;
-        currentImageIndex = (currentImageIndex + 1) % imageFiles.length;
-        const image = imageFiles[currentImageIndex];
-        const fullSizeSrc = image.src.replace('/thumb/', '/dl/');
-        modalImg.src = fullSizeSrc;
-        modalImg.alt = image.filename;
-        updateModalNavigation();
-    }
-
-    function updateModalNavigation() {
-        if (modalPrev) modalPrev.style.display = imageFiles.length > 1 ? 'block' : 'none';
-        if (modalNext) modalNext.style.display = imageFiles.length > 1 ? 'block' : 'none';
-    }
-
-    function handleKeyboard(e) {
-        // Modal shortcuts
-        if (modal && modal.style.display === 'flex') {
-            if (e.key === 'Escape') {
-                closeModal();
-            } else if (e.key === 'ArrowLeft') {
-                showPrevImage();
-            } else if (e.key === 'ArrowRight') {
-                showNextImage();
+    function setupThumbnails() {
+        // Thumbnails the server could not make (unsupported, damaged or too
+        // large a file) become a plain icon; the link to the viewer stays.
+        // The template's onerror marks ones that failed before this ran.
+        document.querySelectorAll('.preview-thumbnail').forEach((img) => {
+            if (img.classList.contains('thumb-failed')) {
+                replaceWithIcon(img);
+                return;
             }
-        }
+            img.addEventListener('error', () => replaceWithIcon(img));
+        });
     }
 
-    // Click outside modal to close
-    if (modal) {
-        modal.addEventListener('click', function (e) {
-            if (e.target === modal) {
-                closeModal();
-            }
-        });
+    function replaceWithIcon(img) {
+        const icon = document.createElement('div');
+        icon.className = 'file-icon';
+        icon.textContent = '🖼️';
+        icon.title = 'Preview not available';
+        img.replaceWith(icon);
     }
 
 })();
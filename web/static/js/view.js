@@ -0,0 +1,65 @@
+// Share viewer: swipe and keyboard navigation between photos, and a message
+// when the preview cannot be made. Prev/next are plain links, so the page
+// works without this script.
+
+(function () {
+    'use strict';
+
+    // A horizontal move longer than this (px), and mostly sideways, is a swipe
+    const SWIPE_MIN = 50;
+
+    document.addEventListener('DOMContentLoaded', init);
+
+    function init() {
+        const stage = document.getElementById('viewerStage');
+        const image = document.getElementById('viewerImage');
+
+        // The template's onerror marks a preview that failed before this ran
+        if (image.classList.contains('thumb-failed')) {
+            showMissing(image);
+        }
+        image.addEventListener('error', () => showMissing(image));
+
+        document.addEventListener('keydown', (e) => {
+            if (e.key === 'ArrowLeft') {
+                follow('viewerPrev');
+            } else if (e.key === 'ArrowRight') {
+                follow('viewerNext');
+            } else if (e.key === 'Escape') {
+                follow('viewerBack');
+            }
+        });
+
+        // Swipes: one finger only, so pinch zoom is left alone
+        let startX = null, startY = 0;
+        stage.addEventListener('touchstart', (e) => {
+            if (e.touches.length !== 1) {
+                startX = null;
+                return;
+            }
+            startX = e.touches[0].clientX;
+            startY = e.touches[0].clientY;
+        }, { passive: true });
+        stage.addEventListener('touchend', (e) => {
+            if (startX === null || e.changedTouches.length !== 1) return;
+            const dx = e.changedTouches[0].clientX - startX;
+            const dy = e.changedTouches[0].clientY - startY;
+            startX = null;
+            if (Math.abs(dx) < SWIPE_MIN || Math.abs(dx) < Math.abs(dy) * 2) return;
+            // Zoomed in: the finger is panning the photo, not swiping
+            if (window.visualViewport && window.visualViewport.scale > 1) return;
+            follow(dx > 0 ? 'viewerPrev' : 'viewerNext');
+        }, { passive: true });
+    }
+
+    function follow(id) {
+        const link = document.getElementById(id);
+        if (link) window.location.href = link.href;
+    }
+
+    function showMissing(image) {
+        image.hidden = true;
+        document.getElementById('viewerMissing').hidden = false;
+    }
+
+})();